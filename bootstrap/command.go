@@ -145,11 +145,24 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 		fatalErrors(errs)
 	}
 
+	// Print the warnings reported by mutators and modules however Main exits from here on.
+	// fatalErrors and fatalf exit without running deferred functions, so the warnings are
+	// also flushed explicitly before the fatal errors that can follow them.
+	warningsFlushed := false
+	flushWarnings := func() {
+		if !warningsFlushed {
+			warningsFlushed = true
+			printWarnings(ctx.Warnings())
+		}
+	}
+	defer flushWarnings()
+
 	// Add extra ninja file dependencies
 	deps = append(deps, extraNinjaFileDeps...)
 
 	extraDeps, errs := ctx.ResolveDependencies(config)
 	if len(errs) > 0 {
+		flushWarnings()
 		fatalErrors(errs)
 	}
 	deps = append(deps, extraDeps...)
@@ -157,6 +170,7 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 	if docFile != "" {
 		err := writeDocs(ctx, docFile)
 		if err != nil {
+			flushWarnings()
 			fatalErrors([]error{err})
 		}
 		return
@@ -169,6 +183,7 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 	}
 
 	extraDeps, errs = ctx.PrepareBuildActions(config)
+	flushWarnings()
 	if len(errs) > 0 {
 		fatalErrors(errs)
 	}
@@ -252,6 +267,15 @@ func fatalf(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
+func printWarnings(warnings []error) {
+	yellow := "\x1b[33m"
+	unyellow := "\x1b[0m"
+
+	for _, warning := range warnings {
+		fmt.Printf("%swarning:%s %s\n", yellow, unyellow, warning.Error())
+	}
+}
+
 func fatalErrors(errs []error) {
 	red := "\x1b[31m"
 	unred := "\x1b[0m"
@@ -15,6 +15,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"text/scanner"
 	"unicode"
 
 	"github.com/google/blueprint/parser"
@@ -26,22 +27,34 @@ var (
 	write           = flag.Bool("w", false, "write result to (source) file instead of stdout")
 	doDiff          = flag.Bool("d", false, "display diffs instead of rewriting files")
 	sortLists       = flag.Bool("s", false, "sort touched lists, even if they were unsorted")
+	count           = flag.Bool("count", false, "print the number of files, modules and properties that would be modified instead of modifying them")
 	parameter       = flag.String("parameter", "deps", "name of parameter to modify on each module")
+	inDefaults      = flag.Bool("in-defaults", false, "modify the parameter in the defaults of each module instead of in the module")
 	targetedModules = new(identSet)
 	addIdents       = new(identSet)
 	removeIdents    = new(identSet)
+	setLiteral      = new(literalProperty)
 )
 
 func init() {
 	flag.Var(targetedModules, "m", "comma or whitespace separated list of modules on which to operate")
 	flag.Var(addIdents, "a", "comma or whitespace separated list of identifiers to add")
 	flag.Var(removeIdents, "r", "comma or whitespace separated list of identifiers to remove")
+	flag.Var(setLiteral, "set-literal", "property=value to replace the value of a property with a Blueprint literal")
 }
 
 var (
 	exitCode = 0
 )
 
+// counts of the changes that would be made, reported by -count
+var (
+	modifiedFiles      = 0
+	modifiedModules    = 0
+	modifiedProperties = 0
+	parseErrorFiles    = 0
+)
+
 func report(err error) {
 	fmt.Fprintln(os.Stderr, err)
 	exitCode = 2
@@ -76,6 +89,7 @@ func processFile(filename string, in io.Reader, out io.Writer) error {
 		for _, err := range errs {
 			fmt.Fprintln(os.Stderr, err)
 		}
+		parseErrorFiles++
 		return fmt.Errorf("%d parsing errors", len(errs))
 	}
 
@@ -87,7 +101,9 @@ func processFile(filename string, in io.Reader, out io.Writer) error {
 		fmt.Fprintln(os.Stderr, "continuing...")
 	}
 
-	if modified {
+	if modified && *count {
+		modifiedFiles++
+	} else if modified {
 		res, err := parser.Print(file)
 		if err != nil {
 			return err
@@ -125,10 +141,20 @@ func findModules(file *parser.File) (modified bool, errs []error) {
 		if module, ok := def.(*parser.Module); ok {
 			for _, prop := range module.Properties {
 				if prop.Name == "name" && prop.Value.Type() == parser.StringType {
-					if targetedModule(prop.Value.Eval().(*parser.String).Value) {
-						m, newErrs := processModule(module, prop.Name, file)
+					name := prop.Value.Eval().(*parser.String).Value
+					if targetedModule(name) {
+						var m bool
+						var newErrs []error
+						if *inDefaults {
+							m, newErrs = processDefaults(module, name, file)
+						} else {
+							m, newErrs = processModule(module, prop.Name, file)
+						}
 						errs = append(errs, newErrs...)
 						modified = modified || m
+						if m {
+							modifiedModules++
+						}
 					}
 				}
 			}
@@ -138,10 +164,137 @@ func findModules(file *parser.File) (modified bool, errs []error) {
 	return modified, errs
 }
 
-func processModule(module *parser.Module, moduleName string,
+// processDefaults modifies the parameter in the defaults of a module instead of
+// in the module itself.  The defaults are found through the module's
+// "defaults" property, which is either a map, in which case the parameter is
+// modified inside the map, or a list of names of other modules in the same
+// file, in which case the parameter is modified in each of the named modules.
+// It is an error if the module has no "defaults" property or if a named
+// module is not defined in the file.
+func processDefaults(module *parser.Module, moduleName string,
 	file *parser.File) (modified bool, errs []error) {
 
+	var defaults parser.Expression
 	for _, prop := range module.Properties {
+		if prop.Name == "defaults" {
+			defaults = prop.Value
+		}
+	}
+
+	switch defaults := defaults.(type) {
+	case nil:
+		return false, []error{fmt.Errorf("module %s has no defaults", moduleName)}
+	case *parser.Map:
+		return processProperties(&defaults.Properties, moduleName, file)
+	case *parser.List:
+		for _, value := range defaults.Values {
+			name, ok := value.(*parser.String)
+			if !ok {
+				errs = append(errs, fmt.Errorf("expected defaults in module %s to be a list of strings, found %s",
+					moduleName, value.Type().String()))
+				continue
+			}
+
+			defaultsModule := findModuleByName(file, name.Value)
+			if defaultsModule == nil {
+				errs = append(errs, fmt.Errorf("defaults %s of module %s not found in %s",
+					name.Value, moduleName, file.Name))
+				continue
+			}
+
+			m, newErrs := processModule(defaultsModule, name.Value, file)
+			errs = append(errs, newErrs...)
+			modified = modified || m
+		}
+		return modified, errs
+	default:
+		return false, []error{fmt.Errorf("expected defaults in module %s to be a map or list, found %s",
+			moduleName, defaults.Type().String())}
+	}
+}
+
+func findModuleByName(file *parser.File, name string) *parser.Module {
+	for _, def := range file.Defs {
+		if module, ok := def.(*parser.Module); ok {
+			for _, prop := range module.Properties {
+				if prop.Name == "name" && prop.Value.Type() == parser.StringType &&
+					prop.Value.Eval().(*parser.String).Value == name {
+					return module
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func processModule(module *parser.Module, moduleName string,
+	file *parser.File) (modified bool, errs []error) {
+
+	return processProperties(&module.Properties, moduleName, file)
+}
+
+func processProperties(properties *[]*parser.Property, moduleName string,
+	file *parser.File) (modified bool, errs []error) {
+
+	if setLiteral.name != "" {
+		m, err := processLiteral(properties)
+		if err != nil {
+			return false, []error{err}
+		}
+		modified = m
+	}
+
+	if len(addIdents.idents) == 0 && len(removeIdents.idents) == 0 {
+		return modified, nil
+	}
+
+	m, errs := processList(properties, moduleName, file)
+	return modified || m, errs
+}
+
+// processLiteral replaces the value of the property given to -set-literal,
+// adding the property if it is not present.
+func processLiteral(properties *[]*parser.Property) (modified bool, err error) {
+	for _, prop := range *properties {
+		if prop.Name == setLiteral.name {
+			// Place the new value where the old one ended so that the printer
+			// doesn't leave a gap where a longer old value used to be.
+			value, err := setLiteral.parse(prop.Value.End())
+			if err != nil {
+				return false, err
+			}
+
+			oldText, err := parser.PrintExpression(prop.Value)
+			if err != nil {
+				return false, err
+			}
+			newText, err := parser.PrintExpression(value)
+			if err != nil {
+				return false, err
+			}
+			if bytes.Equal(oldText, newText) {
+				return false, nil
+			}
+			prop.Value = value
+			modifiedProperties++
+			return true, nil
+		}
+	}
+
+	value, err := setLiteral.parse(scanner.Position{})
+	if err != nil {
+		return false, err
+	}
+
+	*properties = append(*properties, &parser.Property{Name: setLiteral.name, Value: value})
+	modifiedProperties++
+	return true, nil
+}
+
+func processList(properties *[]*parser.Property, moduleName string,
+	file *parser.File) (modified bool, errs []error) {
+
+	for _, prop := range *properties {
 		if prop.Name == *parameter {
 			modified, errs = processParameter(prop.Value, *parameter, moduleName, file)
 			return
@@ -152,7 +305,7 @@ func processModule(module *parser.Module, moduleName string,
 	modified, errs = processParameter(prop.Value, *parameter, moduleName, file)
 
 	if modified {
-		module.Properties = append(module.Properties, &prop)
+		*properties = append(*properties, &prop)
 	}
 
 	return modified, errs
@@ -192,6 +345,10 @@ func processParameter(value parser.Expression, paramName, moduleName string,
 		parser.SortList(file, list)
 	}
 
+	if modified {
+		modifiedProperties++
+	}
+
 	return modified, nil
 }
 
@@ -222,9 +379,18 @@ func walkDir(path string) {
 	filepath.Walk(path, visitFile)
 }
 
+func printCounts() {
+	fmt.Printf("files=%d modules=%d properties=%d parse_errors=%d\n",
+		modifiedFiles, modifiedModules, modifiedProperties, parseErrorFiles)
+}
+
 func main() {
 	flag.Parse()
 
+	if *count {
+		defer printCounts()
+	}
+
 	if flag.NArg() == 0 {
 		if *write {
 			fmt.Fprintln(os.Stderr, "error: cannot use -w with standard input")
@@ -242,8 +408,8 @@ func main() {
 		return
 	}
 
-	if len(addIdents.idents) == 0 && len(removeIdents.idents) == 0 {
-		report(fmt.Errorf("-a or -r parameter is required"))
+	if len(addIdents.idents) == 0 && len(removeIdents.idents) == 0 && setLiteral.name == "" {
+		report(fmt.Errorf("-a, -r or -set-literal parameter is required"))
 		return
 	}
 
@@ -312,3 +478,79 @@ func (m *identSet) Set(s string) error {
 func (m *identSet) Get() interface{} {
 	return m.idents
 }
+
+// literalProperty is the value of the -set-literal flag, a property name and
+// the text of the Blueprint literal to assign to it.
+type literalProperty struct {
+	name  string
+	value string
+}
+
+func (l *literalProperty) String() string {
+	if l.name == "" {
+		return ""
+	}
+	return l.name + "=" + l.value
+}
+
+func (l *literalProperty) Set(s string) error {
+	i := strings.IndexRune(s, '=')
+	if i < 1 {
+		return fmt.Errorf("expected property=value, found %q", s)
+	}
+	l.name, l.value = strings.TrimSpace(s[:i]), s[i+1:]
+
+	// Parse the value once up front so that invalid literals are reported
+	// before any files are processed.
+	_, err := l.parse(scanner.Position{})
+	return err
+}
+
+// parse returns a new Expression for the literal each time it is called, so
+// that each modified property gets its own copy.  All positions in the
+// Expression are set to pos so that the printer lays it out where it is
+// inserted instead of relative to its position on the command line.
+func (l *literalProperty) parse(pos scanner.Position) (parser.Expression, error) {
+	value, errs := parser.ParseExpression(strings.NewReader(l.value))
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid literal %q: %s", l.value, errs[0])
+	}
+
+	file := &parser.File{
+		Defs: []parser.Definition{
+			&parser.Module{Map: parser.Map{Properties: []*parser.Property{{Value: value}}}},
+		},
+	}
+	parser.Walk(file, func(node parser.Node, parent parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.Property:
+			n.NamePos, n.ColonPos = pos, pos
+		case *parser.Map:
+			n.LBracePos, n.RBracePos = pos, pos
+		case *parser.List:
+			n.LBracePos, n.RBracePos = pos, pos
+		case *parser.Operator:
+			n.OperatorPos = pos
+		case *parser.Select:
+			n.KeywordPos, n.VariablePos, n.LBracePos, n.RBracePos, n.RParenPos =
+				pos, pos, pos, pos, pos
+		case *parser.SelectCase:
+			n.KeyPos, n.ColonPos = pos, pos
+		case *parser.Variable:
+			n.NamePos = pos
+		case *parser.String:
+			n.LiteralPos = pos
+		case *parser.Int64:
+			n.LiteralPos = pos
+		case *parser.Bool:
+			n.LiteralPos = pos
+		}
+		return true
+	})
+
+	return value, nil
+}
+
+func (l *literalProperty) Get() interface{} {
+	return l.String()
+}
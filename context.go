@@ -17,6 +17,7 @@ package blueprint
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -32,6 +33,7 @@ import (
 	"sync/atomic"
 	"text/scanner"
 	"text/template"
+	"time"
 
 	"github.com/google/blueprint/parser"
 	"github.com/google/blueprint/pathtools"
@@ -68,6 +70,8 @@ const MockModuleListFile = "bplist"
 // write phase generates the Ninja manifest text based on the generated build
 // actions.
 type Context struct {
+	// cancelling the context aborts parsing, ResolveDependencies and
+	// PrepareBuildActions with the context's error
 	context.Context
 
 	// set at instantiation
@@ -82,17 +86,114 @@ type Context struct {
 	earlyMutatorInfo    []*mutatorInfo
 	variantMutatorNames []string
 
+	wholeGraphMutatorInfo []*wholeGraphMutatorInfo
+
+	lintPassInfo []*lintPassInfo
+
+	// set by RegisterModuleTypesFromSchema
+	schemaModuleTypes map[string]*schemaModuleType
+
 	depsModified uint32 // positive if a mutator modified the dependencies
 
+	mutatorGeneration int32 // incremented at the start of each runMutator
+
 	dependenciesReady bool // set to true on a successful ResolveDependencies
 	buildActionsReady bool // set to true on a successful PrepareBuildActions
 
+	resolvingDependenciesBegun bool // set to true when ResolveDependencies is first called
+	dependenciesPartial        bool // set to true by ResolveDependenciesUntil until a full resolve
+
 	// set by SetIgnoreUnknownModuleTypes
 	ignoreUnknownModuleTypes bool
 
+	// set by SetValidatePropertyStructs
+	validatePropertyStructs bool
+
 	// set by SetAllowMissingDependencies
 	allowMissingDependencies bool
 
+	// set by SetMaxErrors
+	maxErrors    int
+	maxErrorsSet bool
+
+	// set by SetPreParseHook
+	preParseHook PreParseHook
+
+	// set by SetGlobalParseVariables
+	globalParseVariables map[string]string
+
+	// set by SetConfigResolver
+	configResolver func(module Module) interface{}
+
+	// set by SetNinjaNameSanitizer
+	ninjaNameSanitizer func(name string) string
+
+	// set by SetVisibilityChecker
+	visibilityChecker func(from, to Module, tag DependencyTag) error
+
+	// set by SetModuleNameValidator
+	moduleNameValidator func(name string) error
+
+	// set by SetPropertyUnpackHook
+	propertyUnpackHook propertyUnpackHook
+
+	// set by SetPropertyProvenanceTracking
+	propertyProvenanceTracking bool
+
+	// set by RegisterFileValidator
+	fileValidators []func(file *parser.File) []error
+
+	// warnings reported while parsing and by modules during ResolveDependencies and
+	// PrepareBuildActions
+	warnings []error
+
+	// set by SetOutDirVariableName
+	outDirVariableName string
+
+	// set by AddNinjaDefaultTargets
+	ninjaDefaultTargets []string
+
+	// set by SetBuildFileHeader and SetBuildFileFooter
+	buildFileHeader string
+	buildFileFooter string
+
+	// set by SetEmitModuleComments
+	emitModuleComments bool
+
+	// set by SetValidateNinjaReferences
+	checkNinjaReferences bool
+
+	// set by SetMaxVariantsPerModule
+	maxVariantsPerModule int
+
+	// set by SetHighFanoutWarningThreshold
+	highFanoutWarningThreshold int
+
+	// set by SetSortDependencies
+	sortDependencies bool
+
+	// set by AddExcludeDirPatterns
+	excludeDirPatterns []string
+
+	// set by SetParseSingleFileOnly
+	parseSingleFileOnly bool
+
+	// set by SetVariantOutDirPattern
+	variantOutDirPattern string
+
+	// files excluded by excludeDirPatterns, set during WalkBlueprintsFiles
+	skippedModules []SkippedModule
+
+	// set during ResolveDependencies
+	mutatorTimings []MutatorTiming
+
+	// recorded by beginEvent and endEvent for WriteChromeTrace
+	traceEvents []traceEvent
+	traceDepth  int // the number of events that have begun but not ended
+
+	// set by SetPhaseAllocTracking
+	phaseAllocTracking bool
+
 	// set during PrepareBuildActions
 	pkgNames        map[*packageContext]string
 	liveGlobals     *liveTracker
@@ -114,8 +215,26 @@ type Context struct {
 	globs    map[string]GlobPath
 	globLock sync.Mutex
 
+	// set by ParseFileList and ReparseChangedFiles
+	parseRootDir    string
+	parsedFilePaths []string
+
+	// set by WalkBlueprintsFiles
+	fileScopes     map[string]*parser.Scope
+	fileScopesLock sync.Mutex
+
+	// set by WalkBlueprintsFiles
+	blueprintFiles map[string]bool
+
+	// set by TagModule
+	moduleTags     map[*moduleInfo]map[string]bool
+	moduleTagsLock sync.Mutex
+
 	fs             pathtools.FileSystem
 	moduleListFile string
+
+	// set by SetBlueprintsFileName
+	blueprintsFileName string
 }
 
 // An Error describes a problem that was encountered that is related to a
@@ -151,8 +270,64 @@ func (e *PropertyError) Error() string {
 	return fmt.Sprintf("%s: %s: %s: %s", e.Pos, e.module, e.property, e.Err)
 }
 
+// errorPos returns the Blueprints file location associated with an error, if
+// there is one.
+func errorPos(err error) (scanner.Position, bool) {
+	switch err := err.(type) {
+	case *BlueprintError:
+		return err.Pos, true
+	case *ModuleError:
+		return err.Pos, true
+	case *PropertyError:
+		return err.Pos, true
+	case *LintFinding:
+		return err.Pos, true
+	default:
+		return scanner.Position{}, false
+	}
+}
+
+// errorLess orders errors by their Blueprints file location.  Errors without a
+// location are ordered after those with one, and by their message.
+func errorLess(a, b error) bool {
+	aPos, aOk := errorPos(a)
+	bPos, bOk := errorPos(b)
+	switch {
+	case aOk && bOk:
+		if aPos.Filename != bPos.Filename {
+			return aPos.Filename < bPos.Filename
+		}
+		if aPos.Line != bPos.Line {
+			return aPos.Line < bPos.Line
+		}
+		return aPos.Column < bPos.Column
+	case aOk != bOk:
+		return aOk
+	default:
+		return a.Error() < b.Error()
+	}
+}
+
+// sortErrors flattens groups of errors collected from multiple goroutines into
+// a list that is in a deterministic order.  The groups are sorted by the
+// location of their first error, but the order of errors within a group is
+// preserved so that related errors (for example a redefinition and the
+// location of the previous definition) stay together.
+func sortErrors(groups [][]error) []error {
+	sort.SliceStable(groups, func(i, j int) bool {
+		return errorLess(groups[i][0], groups[j][0])
+	})
+
+	var errs []error
+	for _, group := range groups {
+		errs = append(errs, group...)
+	}
+	return errs
+}
+
 type localBuildActions struct {
 	variables []*localVariable
+	pools     []*localPool
 	rules     []*localRule
 	buildDefs []*buildDef
 }
@@ -173,6 +348,7 @@ type moduleInfo struct {
 	relBlueprintsFile string
 	pos               scanner.Position
 	propertyPos       map[string]scanner.Position
+	propertyDefaults  map[string]PropertyDefault
 
 	variantName       string
 	variant           variationMap
@@ -182,6 +358,15 @@ type moduleInfo struct {
 	group       *moduleGroup
 	properties  []interface{}
 
+	// set if the module was created by a mutator calling CreateModule
+	createdBy *moduleInfo
+
+	// set by CreateModule if property provenance tracking is enabled
+	propertyProvenance map[string]*moduleInfo
+
+	// set by processModuleDef for properties set through deprecated aliases
+	parseWarnings []error
+
 	// set during ResolveDependencies
 	directDeps  []depInfo
 	missingDeps []string
@@ -196,8 +381,17 @@ type moduleInfo struct {
 	// set during each runMutator
 	splitModules []*moduleInfo
 
+	// the mutatorGeneration of the last mutator that finished visiting the
+	// module, accessed atomically
+	finishedMutator int32
+
 	// set during PrepareBuildActions
-	actionDefs localBuildActions
+	actionDefs    localBuildActions
+	defaultTarget bool
+
+	// set to 1 during PrepareBuildActions, accessed atomically
+	startedGenerateBuildActions  int32
+	finishedGenerateBuildActions int32
 }
 
 type depInfo struct {
@@ -269,6 +463,12 @@ type singletonInfo struct {
 	actionDefs localBuildActions
 }
 
+type lintPassInfo struct {
+	// set during RegisterLintPass
+	pass func(LintContext)
+	name string
+}
+
 type mutatorInfo struct {
 	// set during RegisterMutator
 	topDownMutator  TopDownMutator
@@ -277,6 +477,12 @@ type mutatorInfo struct {
 	parallel        bool
 }
 
+type wholeGraphMutatorInfo struct {
+	// set during RegisterWholeGraphMutator
+	mutator WholeGraphMutator
+	name    string
+}
+
 func newContext() *Context {
 	return &Context{
 		Context:            context.Background(),
@@ -285,6 +491,8 @@ func newContext() *Context {
 		moduleInfo:         make(map[Module]*moduleInfo),
 		globs:              make(map[string]GlobPath),
 		fs:                 pathtools.OsFs,
+		outDirVariableName: "builddir",
+		emitModuleComments: true,
 		ninjaBuildDir:      nil,
 		requiredNinjaMajor: 1,
 		requiredNinjaMinor: 7,
@@ -332,7 +540,10 @@ type ModuleFactory func() (m Module, propertyStructs []interface{})
 //
 // The fields of the properties struct must be either []string, a string, or
 // bool. The Context will panic if a Module gets instantiated with a properties
-// struct containing a field that is not one these supported types.
+// struct containing a field that is not one these supported types.  If
+// SetValidatePropertyStructs has been called, the factory is invoked once by
+// RegisterModuleType and it panics immediately with the path of the first
+// unsupported field instead, for example "my_module.properties.Nested.Foo".
 //
 // Any properties that appear in the Blueprints files that are not built-in
 // module properties (such as "name" and "deps") and do not have a corresponding
@@ -374,6 +585,25 @@ func (c *Context) RegisterModuleType(name string, factory ModuleFactory) {
 	if _, present := c.moduleFactories[name]; present {
 		panic(errors.New("module type name is already registered"))
 	}
+
+	if c.validatePropertyStructs {
+		_, properties := factory()
+		for _, props := range properties {
+			err := proptools.ValidatePropertyStruct(props)
+			if fieldErr, ok := err.(*proptools.ValidatePropertyError); ok {
+				path := name + ".properties"
+				// An invalid anonymous field at the top level has no name.
+				if fieldErr.Field != "" {
+					path += "." + fieldErr.Field
+				}
+				panic(fmt.Errorf("%s: %s", path, fieldErr.Err))
+			} else if err != nil {
+				panic(fmt.Errorf("module type %q: invalid properties struct %T: %s",
+					name, props, err))
+			}
+		}
+	}
+
 	c.moduleFactories[name] = factory
 }
 
@@ -426,6 +656,26 @@ func (c *Context) RegisterPreSingletonType(name string, factory SingletonFactory
 	})
 }
 
+// RegisterLintPass registers a read-only check that is run over the modules by
+// RunLintPasses after PrepareBuildActions has completed.  Unlike a singleton, a
+// lint pass can not create build actions; it can only inspect the modules and
+// the build actions they generated and report findings about them through
+// LintContext.Report.  Lint passes are run in registration order.
+//
+// The lint pass names given here must be unique for the context.
+func (c *Context) RegisterLintPass(name string, pass func(LintContext)) {
+	for _, l := range c.lintPassInfo {
+		if l.name == name {
+			panic(errors.New("lint pass name is already registered"))
+		}
+	}
+
+	c.lintPassInfo = append(c.lintPassInfo, &lintPassInfo{
+		pass: pass,
+		name: name,
+	})
+}
+
 func (c *Context) SetNameInterface(i NameInterface) {
 	c.nameInterface = i
 }
@@ -545,6 +795,28 @@ func (c *Context) RegisterEarlyMutator(name string, mutator EarlyMutator) {
 	c.variantMutatorNames = append(c.variantMutatorNames, name)
 }
 
+// RegisterWholeGraphMutator registers a mutator that will be invoked exactly once, with access to
+// every module and variant in the graph, instead of once per module.  Whole graph mutators run in
+// registration order after all TopDownMutators, BottomUpMutators and EarlyMutators have run, and
+// before the modules are cloned at the end of ResolveDependencies.
+//
+// A whole graph mutator can read the dependency graph and create new modules, but can't add
+// dependencies or split modules into variants, since no mutators run after it.
+//
+// The mutator names given here must be unique to all whole graph mutators in the Context.
+func (c *Context) RegisterWholeGraphMutator(name string, mutator WholeGraphMutator) {
+	for _, m := range c.wholeGraphMutatorInfo {
+		if m.name == name {
+			panic(fmt.Errorf("whole graph mutator name %s is already registered", name))
+		}
+	}
+
+	c.wholeGraphMutatorInfo = append(c.wholeGraphMutatorInfo, &wholeGraphMutatorInfo{
+		mutator: mutator,
+		name:    name,
+	})
+}
+
 // SetIgnoreUnknownModuleTypes sets the behavior of the context in the case
 // where it encounters an unknown module type while parsing Blueprints files. By
 // default, the context will report unknown module types as an error.  If this
@@ -557,6 +829,222 @@ func (c *Context) SetIgnoreUnknownModuleTypes(ignoreUnknownModuleTypes bool) {
 	c.ignoreUnknownModuleTypes = ignoreUnknownModuleTypes
 }
 
+// SetValidatePropertyStructs sets whether RegisterModuleType should call each
+// factory when it is registered and check that the fields of the returned
+// properties structs all have supported types.  By default, an unsupported
+// field type is only reported when a module of that type is parsed.  This
+// method must be called before any module types are registered to have an
+// effect on them.
+func (c *Context) SetValidatePropertyStructs(validate bool) {
+	c.validatePropertyStructs = validate
+}
+
+// SetOutDirVariableName sets the name of the top-level Ninja variable that is
+// assigned the value passed to SingletonContext.SetNinjaBuildDir.  The default
+// is "builddir", which is the variable Ninja uses to decide where to store its
+// build log files.
+func (c *Context) SetOutDirVariableName(name string) {
+	c.outDirVariableName = name
+}
+
+// SetBuildFileHeader sets text that is written as a comment near the top of
+// the Ninja file by WriteBuildFile, after the generated file warning and the
+// list of Go packages.  Each line of a multi-line text is written as a
+// separate comment line.
+func (c *Context) SetBuildFileHeader(text string) {
+	c.buildFileHeader = text
+}
+
+// SetBuildFileFooter sets text that is written as a comment at the end of the
+// Ninja file by WriteBuildFile.  Each line of a multi-line text is written as
+// a separate comment line.
+func (c *Context) SetBuildFileFooter(text string) {
+	c.buildFileFooter = text
+}
+
+// SetEmitModuleComments sets whether WriteBuildFile writes a comment before the
+// build actions of each module and singleton describing where they came from.
+// The comments are written by default, disabling them reduces the size of the
+// Ninja file for large builds.
+func (c *Context) SetEmitModuleComments(emitModuleComments bool) {
+	c.emitModuleComments = emitModuleComments
+}
+
+// SetValidateNinjaReferences sets whether WriteBuildFile checks that every
+// variable referenced by the rules and build statements it is about to write
+// is defined, either globally or by the module or singleton that defined the
+// rule or build statement, and fails instead of writing a Ninja file that
+// Ninja would reject.  It is disabled by default because of its cost for large
+// builds.
+func (c *Context) SetValidateNinjaReferences(validate bool) {
+	c.checkNinjaReferences = validate
+}
+
+// SetMaxVariantsPerModule sets the maximum number of variants that mutators may
+// split a single module into.  A mutator that causes a module to exceed the
+// limit fails with an error listing the mutators that created its variants.
+// The default of 0 means no limit.
+func (c *Context) SetMaxVariantsPerModule(n int) {
+	c.maxVariantsPerModule = n
+}
+
+// SetHighFanoutWarningThreshold sets the number of direct dependencies that a
+// module variant may have before ResolveDependencies reports a warning for it,
+// to help find modules that have grown too many dependencies.  Dependencies
+// added with different tags are counted separately, and the implicit ordering
+// of variants after the earlier variants of the same module is not counted.
+// The default of 0 disables the warning.
+func (c *Context) SetHighFanoutWarningThreshold(n int) {
+	c.highFanoutWarningThreshold = n
+}
+
+// SetSortDependencies sets whether ResolveDependencies sorts the direct
+// dependencies of each module variant by name, variant and the type of the
+// dependency tag after all mutators have run, so that the order in which
+// modules visit their dependencies does not depend on the order in which
+// mutators added them.  Sorting is disabled by default, as some modules rely on
+// the order of their dependencies, for example to order the libraries on a
+// link command line.
+func (c *Context) SetSortDependencies(sortDeps bool) {
+	c.sortDependencies = sortDeps
+}
+
+// sortDirectDeps stably sorts the direct dependencies of every module variant
+// for SetSortDependencies.
+func (c *Context) sortDirectDeps() {
+	for _, module := range c.modulesSorted {
+		deps := module.directDeps
+		sort.SliceStable(deps, func(i, j int) bool {
+			if depSorter(deps).Less(i, j) {
+				return true
+			}
+			if depSorter(deps).Less(j, i) {
+				return false
+			}
+			return qualifiedTypeName(reflect.TypeOf(deps[i].tag)) <
+				qualifiedTypeName(reflect.TypeOf(deps[j].tag))
+		})
+	}
+}
+
+// checkHighFanout returns a warning for each module variant with more direct
+// dependencies than the threshold set by SetHighFanoutWarningThreshold.
+func (c *Context) checkHighFanout() []error {
+	var warnings []error
+	for _, module := range c.modulesSorted {
+		if n := len(module.directDeps); n > c.highFanoutWarningThreshold {
+			warnings = append(warnings, &ModuleError{
+				BlueprintError: BlueprintError{
+					Err: fmt.Errorf("has %d direct dependencies, more than the threshold of %d",
+						n, c.highFanoutWarningThreshold),
+					Pos: module.pos,
+				},
+				module: module,
+			})
+		}
+	}
+	return warnings
+}
+
+// SetVariantOutDirPattern sets the pattern used by ModuleContext.VariantOutDir
+// to compute the directory for the intermediate files of each module variant.
+// The placeholders "{dir}", "{name}", "{variant}" and "{type}" in the pattern
+// are replaced with the module's directory, name, variant name and module type,
+// and the result is cleaned with filepath.Clean.  The default pattern is
+// "{dir}/{variant}".
+func (c *Context) SetVariantOutDirPattern(pattern string) {
+	c.variantOutDirPattern = pattern
+}
+
+func (c *Context) variantOutDir(module *moduleInfo) string {
+	pattern := c.variantOutDirPattern
+	if pattern == "" {
+		pattern = "{dir}/{variant}"
+	}
+	return filepath.Clean(strings.NewReplacer(
+		"{dir}", filepath.Dir(module.relBlueprintsFile),
+		"{name}", module.Name(),
+		"{variant}", module.variantName,
+		"{type}", module.typeName,
+	).Replace(pattern))
+}
+
+// SetParseSingleFileOnly sets whether ParseBlueprintsFiles and ParseFileList
+// parse only the files they are given.  When set, ParseBlueprintsFiles parses
+// only its root file instead of every Blueprints file in the tree, and the
+// "build" variable of each parsed file is ignored instead of causing the
+// listed files to be parsed, so that a single file can be checked without the
+// rest of the tree being available.
+func (c *Context) SetParseSingleFileOnly(singleFileOnly bool) {
+	c.parseSingleFileOnly = singleFileOnly
+}
+
+// AddExcludeDirPatterns adds glob patterns for directories whose Blueprints
+// files are ignored by ParseBlueprintsFiles, ParseFileList and
+// WalkBlueprintsFiles.  A Blueprints file that is in a matching directory, or
+// in a subdirectory of one if the pattern ends in "/**", is never read, so
+// neither its modules nor the files listed in its subdirs and build variables
+// are added.  For example, "**/testdata/**" excludes all Blueprints files in
+// any directory named testdata and in its subdirectories.
+func (c *Context) AddExcludeDirPatterns(patterns ...string) {
+	c.excludeDirPatterns = append(c.excludeDirPatterns, patterns...)
+}
+
+// excludedBlueprintsFile returns the first pattern added by AddExcludeDirPatterns
+// that excludes the Blueprints file at the given path, which includes rootDir,
+// or the empty string if the file is not excluded.
+func (c *Context) excludedBlueprintsFile(rootDir, filename string) (string, error) {
+	if len(c.excludeDirPatterns) == 0 {
+		return "", nil
+	}
+	relFile, err := filepath.Rel(rootDir, filename)
+	if err != nil {
+		return "", err
+	}
+	return c.excludeDirPattern(relFile)
+}
+
+// excludeDirPattern returns the first pattern added by AddExcludeDirPatterns
+// that excludes the Blueprints file with the given name, or the empty string if
+// the file is not excluded.
+func (c *Context) excludeDirPattern(filename string) (string, error) {
+	dir := filepath.Dir(filename)
+	for _, pattern := range c.excludeDirPatterns {
+		if strings.HasSuffix(pattern, "/**") {
+			// pathtools.Match does not support a trailing "**", match the rest
+			// of the pattern against the directory and each of its parents.
+			prefix := strings.TrimSuffix(pattern, "/**")
+			for d := dir; d != "." && d != "/"; d = filepath.Dir(d) {
+				match, err := pathtools.Match(prefix, d)
+				if err != nil {
+					return "", fmt.Errorf("invalid exclude pattern %q: %s", pattern, err)
+				}
+				if match {
+					return pattern, nil
+				}
+			}
+		} else {
+			match, err := pathtools.Match(pattern, dir)
+			if err != nil {
+				return "", fmt.Errorf("invalid exclude pattern %q: %s", pattern, err)
+			}
+			if match {
+				return pattern, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// AddNinjaDefaultTargets adds paths to the list of targets that are written
+// to a Ninja "default" statement at the end of the build file, in addition to
+// the primary outputs of modules that call ModuleContext.SetAsDefaultTarget.
+// The targets are written literally, so any "$" characters must already be
+// escaped for Ninja.
+func (c *Context) AddNinjaDefaultTargets(targets ...string) {
+	c.ninjaDefaultTargets = append(c.ninjaDefaultTargets, targets...)
+}
+
 // SetAllowMissingDependencies changes the behavior of Blueprint to ignore
 // unresolved dependencies.  If the module's GenerateBuildActions calls
 // ModuleContext.GetMissingDependencies Blueprint will not emit any errors
@@ -565,6 +1053,383 @@ func (c *Context) SetAllowMissingDependencies(allowMissingDependencies bool) {
 	c.allowMissingDependencies = allowMissingDependencies
 }
 
+// SetMaxErrors sets the maximum number of errors that Blueprint returns from
+// parsing Blueprints files or generating singleton build actions.  Blueprint
+// stops once more errors than that have been found, and setting it to 0 or less
+// collects every error.  If it is not called Blueprint stops once more than 10
+// errors have been found, and returns every error it collected up to that
+// point.
+func (c *Context) SetMaxErrors(maxErrors int) {
+	c.maxErrors = maxErrors
+	c.maxErrorsSet = true
+}
+
+// tooManyErrors returns true if numErrs has exceeded the limit set by
+// SetMaxErrors, or the default limit if SetMaxErrors was not called.
+func (c *Context) tooManyErrors(numErrs int) bool {
+	limit := maxErrors
+	if c.maxErrorsSet {
+		limit = c.maxErrors
+	}
+	return limit > 0 && numErrs > limit
+}
+
+// limitErrors truncates errs to the limit set by SetMaxErrors.  Errors may be
+// reported from multiple goroutines before they notice the limit has been
+// reached, so the list can end up longer than the limit.  Without a call to
+// SetMaxErrors errs is returned unchanged.
+func (c *Context) limitErrors(errs []error) []error {
+	if c.maxErrorsSet && c.maxErrors > 0 && len(errs) > c.maxErrors {
+		return errs[:c.maxErrors]
+	}
+	return errs
+}
+
+// Warnings returns the warnings that have been reported by modules through
+// the Warningf, ModuleWarningf and PropertyWarningf methods of their contexts
+// during ResolveDependencies and PrepareBuildActions, along with warnings for
+// properties set in Blueprints files through a deprecated alias and for modules
+// exceeding the threshold set by SetHighFanoutWarningThreshold, sorted by
+// position.  Warnings do not cause any of these methods to fail.
+func (c *Context) Warnings() []error {
+	warnings := append([]error(nil), c.warnings...)
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return errorLess(warnings[i], warnings[j])
+	})
+	return warnings
+}
+
+// SetGlobalParseVariables sets string variables that are visible to every
+// Blueprints file as if they had been assigned in a parent scope, so that they
+// can be referenced in property values, for example
+// `cflags: ["-DVERSION=" + my_version]`.  Blueprints files may not reassign or
+// append to these variables.  A reference to a variable that is neither set
+// here nor assigned in the file or its ancestors is reported as a parse error.
+func (c *Context) SetGlobalParseVariables(vars map[string]string) {
+	c.globalParseVariables = make(map[string]string, len(vars))
+	for name, value := range vars {
+		c.globalParseVariables[name] = value
+	}
+}
+
+// newRootScope returns the scope that the scopes of top-level Blueprints files
+// inherit from, containing the variables set by SetGlobalParseVariables.
+func (c *Context) newRootScope() *parser.Scope {
+	scope := parser.NewScope(nil)
+	for name, value := range c.globalParseVariables {
+		str := &parser.String{Value: value}
+		err := scope.Add(&parser.Assignment{
+			Name:      name,
+			Value:     str,
+			OrigValue: str,
+			Assigner:  "=",
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	return scope
+}
+
+// SetConfigResolver sets a function that selects the config object returned by
+// the Config method of the contexts passed to mutators and to the
+// GenerateBuildActions method of each module, so that a single parsed tree can
+// be analyzed under several configs.  The resolver is called with each module
+// variant before the mutator or GenerateBuildActions call that it is used for,
+// and may be called from multiple goroutines at once.  If no resolver is set,
+// or the resolver returns nil, the config passed to ResolveDependencies or
+// PrepareBuildActions is used.  Singletons always see that config.
+func (c *Context) SetConfigResolver(resolver func(module Module) interface{}) {
+	c.configResolver = resolver
+}
+
+// SetNinjaNameSanitizer sets a function that replaces the default conversion
+// of module names into the names used for their Ninja variables, rules and
+// pools, which replaces every character that is not valid in a Ninja name with
+// an underscore.  The function may be called more than once for each module,
+// from multiple goroutines, and must always return the same valid Ninja name
+// for the same input.  Because the default conversion is not injective, two
+// modules whose names only differ in invalid characters share a prefix; when a
+// sanitizer is set PrepareBuildActions instead reports an error for any two
+// module variants that end up with the same prefix, and for any invalid name
+// returned by the sanitizer.
+func (c *Context) SetNinjaNameSanitizer(sanitizer func(name string) string) {
+	c.ninjaNameSanitizer = sanitizer
+}
+
+// SetVisibilityChecker sets a function that is called by ResolveDependencies
+// for every dependency in the build graph once all mutators have run, so that
+// visibility rules or allowlists can be enforced without building a policy into
+// Blueprint.  It is called with the depending module, the dependency and the
+// dependency tag, and any error it returns is reported at the position of the
+// depending module together with the names of both modules.
+func (c *Context) SetVisibilityChecker(checker func(from, to Module, tag DependencyTag) error) {
+	c.visibilityChecker = checker
+}
+
+// SetModuleNameValidator sets a function that is called with the name of every
+// module when it is added to the Context, whether it was defined in a
+// Blueprints file or created by a mutator, and with the new name of every
+// module renamed by a mutator.  Any error it returns is reported at the
+// position of the module.
+func (c *Context) SetModuleNameValidator(validator func(name string) error) {
+	c.moduleNameValidator = validator
+}
+
+// SetPropertyUnpackHook sets a function that is offered the value of every
+// property set in a Blueprints file before it is unpacked into a field of a
+// module's property structs that is not itself a struct.  If the hook returns
+// true its result, which must be assignable to the field, replaces the value of
+// the field; otherwise the property is unpacked as usual.  This allows fields
+// with custom types of a supported kind, such as *time.Duration, to be set from
+// values that Blueprint would not otherwise assign to them.
+func (c *Context) SetPropertyUnpackHook(hook func(field reflect.StructField,
+	value parser.Expression) (interface{}, bool, error)) {
+
+	c.propertyUnpackHook = hook
+}
+
+// SetPropertyProvenanceTracking sets whether CreateModule records, for each property of the
+// created module that is set by the property structs passed to it, the module the property
+// struct came from.  A property struct wrapped in PropertiesFrom comes from its Source module,
+// any other property struct comes from the module whose mutator called CreateModule.  The
+// recorded modules can be retrieved with PropertyProvenance.  Tracking is disabled by default, as
+// it requires a map per created module.
+func (c *Context) SetPropertyProvenanceTracking(track bool) {
+	c.propertyProvenanceTracking = track
+}
+
+// PropertyProvenance returns the module whose property struct last set the property with the
+// given name, for example "cflags" or "target.linux.srcs", on a module created by CreateModule.
+// It returns nil if the property was not set by CreateModule or if property provenance tracking
+// was not enabled with SetPropertyProvenanceTracking when the module was created.
+func (c *Context) PropertyProvenance(module Module, property string) Module {
+	info := c.moduleInfo[module]
+	if info == nil {
+		panic(fmt.Errorf("PropertyProvenance called with unknown module %q", c.ModuleName(module)))
+	}
+	if source := info.propertyProvenance[property]; source != nil {
+		return source.logicModule
+	}
+	return nil
+}
+
+// appendCreatedModuleProperties appends the property structs passed to CreateModule to the
+// properties of the created module, unwrapping any PropertiesFrom and recording the provenance of
+// the properties they set if property provenance tracking is enabled.  creator is the module the
+// new module is created by, and is recorded for property structs that are not wrapped.  The
+// defaults of a PropertyDefaultsModule are recorded for the properties that are left unset.
+func (c *Context) appendCreatedModuleProperties(module, creator *moduleInfo, props []interface{}) {
+	if c.propertyProvenanceTracking {
+		module.propertyProvenance = make(map[string]*moduleInfo)
+	}
+
+	set := make(map[string]bool)
+
+	for _, p := range props {
+		source := creator
+		if from, ok := p.(PropertiesFrom); ok {
+			source = c.moduleInfo[from.Source]
+			if source == nil {
+				panic(fmt.Errorf("CreateModule called with properties from unknown module %q",
+					from.Source.Name()))
+			}
+			p = from.Properties
+		}
+
+		filter := createdPropertyFilter(set, module.propertyProvenance, source)
+		err := proptools.AppendMatchingProperties(module.properties, p, filter)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	setPropertyDefaults(module, func(property string) bool { return set[property] })
+}
+
+// createdPropertyFilter returns a proptools.ExtendPropertyFilterFunc that appends every property
+// and adds the ones that are set to set.  If provenance is not nil it also records source as their
+// provenance.
+func createdPropertyFilter(set map[string]bool, provenance map[string]*moduleInfo,
+	source *moduleInfo) proptools.ExtendPropertyFilterFunc {
+
+	return func(property string, dstField, srcField reflect.StructField,
+		dstValue, srcValue interface{}) (bool, error) {
+
+		v := reflect.ValueOf(srcValue)
+		switch v.Kind() {
+		case reflect.Bool:
+			if !v.Bool() {
+				return true, nil
+			}
+		case reflect.String, reflect.Slice:
+			if v.Len() == 0 {
+				return true, nil
+			}
+		case reflect.Ptr:
+			if v.IsNil() {
+				return true, nil
+			}
+		}
+		set[property] = true
+		if provenance != nil {
+			provenance[property] = source
+		}
+		return true, nil
+	}
+}
+
+// RegisterFileValidator registers a function that is called with each parsed
+// Blueprints file before the modules it defines are created, for example to
+// enforce policies about the contents of Blueprints files.  If any validator
+// returns errors they are reported and none of the modules in the file are
+// added to the Context.  Files are processed in parallel, so validators must
+// be reentrant.
+func (c *Context) RegisterFileValidator(validator func(file *parser.File) []error) {
+	c.fileValidators = append(c.fileValidators, validator)
+}
+
+// validateFile returns the errors reported by the functions registered with
+// RegisterFileValidator for a Blueprints file.
+func (c *Context) validateFile(file *parser.File) []error {
+	var errs []error
+	for _, validator := range c.fileValidators {
+		errs = append(errs, validator(file)...)
+	}
+	return errs
+}
+
+// validateModuleName returns an error if the function set by
+// SetModuleNameValidator rejects a name for a module.
+func (c *Context) validateModuleName(name string, pos scanner.Position) error {
+	if c.moduleNameValidator == nil {
+		return nil
+	}
+	if err := c.moduleNameValidator(name); err != nil {
+		return &BlueprintError{
+			Err: fmt.Errorf("invalid module name %q: %s", name, err),
+			Pos: pos,
+		}
+	}
+	return nil
+}
+
+// checkVisibility calls the function set by SetVisibilityChecker for every
+// dependency and returns the errors it reports.
+func (c *Context) checkVisibility() (errs []error) {
+	for _, module := range c.modulesSorted {
+		for _, dep := range module.directDeps {
+			err := c.visibilityChecker(module.logicModule, dep.module.logicModule, dep.tag)
+			if err != nil {
+				errs = append(errs, &ModuleError{
+					BlueprintError: BlueprintError{
+						Err: fmt.Errorf("dependency on %s is not allowed: %s", dep.module, err),
+						Pos: module.pos,
+					},
+					module: module,
+				})
+			}
+		}
+	}
+	return errs
+}
+
+// moduleNinjaName returns the name of a module converted to a valid Ninja
+// name, either by the function set by SetNinjaNameSanitizer or by toNinjaName.
+func (c *Context) moduleNinjaName(module *moduleInfo) string {
+	uniqueName := c.nameInterface.UniqueName(newNamespaceContext(module), module.group.name)
+	if c.ninjaNameSanitizer != nil {
+		return c.ninjaNameSanitizer(uniqueName)
+	}
+	return toNinjaName(uniqueName)
+}
+
+// moduleNinjaPrefix returns the prefix of the names of the Ninja variables,
+// rules and pools defined by a module.
+func (c *Context) moduleNinjaPrefix(module *moduleInfo) string {
+	return moduleNamespacePrefix(c.moduleNinjaName(module) + "_" + module.variantName)
+}
+
+// checkModuleNinjaPrefixes returns errors for any module variants whose Ninja
+// names are invalid or whose prefixes collide with those of other module
+// variants.
+func (c *Context) checkModuleNinjaPrefixes() (errs []error) {
+	// Visit the modules in the order they were defined so that the same module
+	// is reported for a collision on every run.
+	modules := append([]*moduleInfo(nil), c.modulesSorted...)
+	sort.SliceStable(modules, func(i, j int) bool {
+		a, b := modules[i].pos, modules[j].pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Offset != b.Offset {
+			return a.Offset < b.Offset
+		}
+		return modules[i].variantName < modules[j].variantName
+	})
+
+	owners := make(map[string]*moduleInfo)
+	for _, module := range modules {
+		prefix := c.moduleNinjaPrefix(module)
+		if err := validateNinjaName(c.moduleNinjaName(module)); err != nil {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("invalid Ninja name for %s: %s", module, err),
+				Pos: module.pos,
+			})
+		} else if other, exists := owners[prefix]; exists {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("Ninja name %q for %s is already used by %s",
+					prefix, module, other),
+				Pos: module.pos,
+			})
+		} else {
+			owners[prefix] = module
+		}
+	}
+	return errs
+}
+
+// moduleConfig returns the config that a module context for the given module
+// should return from Config.
+func (c *Context) moduleConfig(config interface{}, module *moduleInfo) interface{} {
+	if c.configResolver != nil {
+		if moduleConfig := c.configResolver(module.logicModule); moduleConfig != nil {
+			return moduleConfig
+		}
+	}
+	return config
+}
+
+// A PreParseHook is called with the name and contents of each Blueprints file
+// before it is parsed, and returns the contents that should be parsed instead.
+type PreParseHook func(filename string, contents []byte) ([]byte, error)
+
+// SetPreParseHook sets a function that can rewrite the contents of each
+// Blueprints file before it is parsed, for example to expand macros.  The hook
+// is called from the goroutine that reads the file, and may be called from
+// multiple goroutines at once.  An error returned by the hook is reported at
+// the beginning of the file.  The file is still recorded as a dependency under
+// its original path.
+func (c *Context) SetPreParseHook(hook PreParseHook) {
+	c.preParseHook = hook
+}
+
+// SetBlueprintsFileName sets the name of the files that define modules, which
+// defaults to "Blueprints".  It is used by MockFileSystem to find the files to
+// parse when no module list file is given, and as the default name of the
+// Blueprints files in subdirectories.
+func (c *Context) SetBlueprintsFileName(name string) {
+	c.blueprintsFileName = name
+}
+
+// blueprintsFile returns the name set by SetBlueprintsFileName, or "Blueprints".
+func (c *Context) blueprintsFile() string {
+	if c.blueprintsFileName != "" {
+		return c.blueprintsFileName
+	}
+	return "Blueprints"
+}
+
 func (c *Context) SetModuleListFile(listFile string) {
 	c.moduleListFile = listFile
 }
@@ -615,6 +1480,9 @@ type fileParseContext struct {
 // subdirs are found.
 func (c *Context) ParseBlueprintsFiles(rootFile string) (deps []string, errs []error) {
 	baseDir := filepath.Dir(rootFile)
+	if c.parseSingleFileOnly {
+		return c.ParseFileList(baseDir, []string{rootFile})
+	}
 	pathsToParse, err := c.ListModulePaths(baseDir)
 	if err != nil {
 		return nil, []error{err}
@@ -629,60 +1497,258 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string) (deps []stri
 		return nil, []error{fmt.Errorf("no paths provided to parse")}
 	}
 
-	c.dependenciesReady = false
+	c.parseRootDir = rootDir
+	c.parsedFilePaths = append([]string(nil), filePaths...)
 
-	moduleCh := make(chan *moduleInfo)
-	errsCh := make(chan []error)
-	doneCh := make(chan struct{})
-	var numErrs uint32
-	var numGoroutines int32
+	return c.parseFileList(rootDir, filePaths, nil)
+}
 
-	// handler must be reentrant
-	handleOneFile := func(file *parser.File) {
-		if atomic.LoadUint32(&numErrs) > maxErrors {
-			return
-		}
+// ReparseChangedFiles updates the modules of a Context that has already parsed
+// its Blueprints files with ParseBlueprintsFiles or ParseFileList after some of
+// those files have been modified, added or deleted, without recreating the
+// modules defined by the other files.  The paths in changed are in the same form
+// as the paths passed to ParseFileList.  A changed path that does not exist is
+// treated as deleted, and one that was not parsed before is added to the list of
+// files.  config is currently unused, parsing does not depend on it.
+//
+// Only the modules defined by the changed files are removed and recreated.  The
+// ancestors of the changed files are parsed again to rebuild the variable scopes
+// of the changed files, but their modules are kept and the files they list in
+// their subdirs and build variables are not parsed again.  Files that inherit
+// variables from a changed file are not reparsed, so they must be listed in
+// changed as well if a change to a variable affects them.  Modules keep their
+// Module objects and positions if they are not recreated.
+//
+// ReparseChangedFiles may only be called before ResolveDependencies, as the
+// mutators modify the modules in ways that can't be undone, and requires a
+// NameInterface with a RemoveModule method like SimpleNameInterface.RemoveModule.
+// As with ParseFileList, if errors are returned the Context should not be used
+// further.
+func (c *Context) ReparseChangedFiles(changed []string, config interface{}) (deps []string,
+	errs []error) {
 
-		for _, def := range file.Defs {
-			var module *moduleInfo
-			var errs []error
-			switch def := def.(type) {
-			case *parser.Module:
-				module, errs = c.processModuleDef(def, file.Name)
-			case *parser.Assignment:
-				// Already handled via Scope object
-			default:
-				panic("unknown definition type")
-			}
+	if c.resolvingDependenciesBegun {
+		return nil, []error{fmt.Errorf("ReparseChangedFiles called after ResolveDependencies")}
+	}
+	if c.parsedFilePaths == nil {
+		return nil, []error{fmt.Errorf("ReparseChangedFiles called before ParseFileList")}
+	}
+	remover, ok := c.nameInterface.(moduleRemover)
+	if !ok {
+		return nil, []error{fmt.Errorf("ReparseChangedFiles called with a NameInterface " +
+			"that can't remove modules")}
+	}
 
-			if len(errs) > 0 {
-				atomic.AddUint32(&numErrs, uint32(len(errs)))
-				errsCh <- errs
-			} else if module != nil {
-				moduleCh <- module
-			}
+	rootDir := c.parseRootDir
+
+	changedFiles := make(map[string]bool)
+	var changedDirs []string
+	for _, path := range changed {
+		relPath, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return nil, []error{err}
+		}
+		changedFiles[relPath] = true
+		changedDirs = append(changedDirs, filepath.Dir(relPath))
+	}
+
+	// inDir returns true if dir is parent or a subdirectory of parent.
+	inDir := func(dir, parent string) bool {
+		return parent == "." || dir == parent || strings.HasPrefix(dir, parent+"/")
+	}
+	// reparse returns true if the modules in a Blueprints file, given relative to
+	// the root directory, need to be recreated.
+	reparse := func(relPath string) bool {
+		return changedFiles[relPath]
+	}
+	// isAncestor returns true if a Blueprints file, given relative to the root
+	// directory, provides variables to a changed file.
+	isAncestor := func(relPath string) bool {
+		for _, changedDir := range changedDirs {
+			if inDir(changedDir, filepath.Dir(relPath)) {
+				return true
+			}
+		}
+		return false
+	}
+	var filePaths []string
+	seen := make(map[string]bool)
+	for _, path := range append(append([]string(nil), c.parsedFilePaths...), changed...) {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		exists, _, err := c.fs.Exists(path)
+		if err != nil {
+			return nil, []error{err}
+		}
+		if exists {
+			filePaths = append(filePaths, path)
+		}
+	}
+	if _, err := findBlueprintDescendants(filePaths); err != nil {
+		return nil, []error{err}
+	}
+	c.parsedFilePaths = filePaths
+
+	for file := range c.blueprintFiles {
+		if exists, _, err := c.fs.Exists(file); err == nil && !exists {
+			delete(c.blueprintFiles, file)
+		}
+	}
+
+	c.removeModules(remover, func(module *moduleInfo) bool {
+		return reparse(module.relBlueprintsFile)
+	})
+
+	c.fileScopesLock.Lock()
+	for file := range c.fileScopes {
+		if reparse(file) {
+			delete(c.fileScopes, file)
+		}
+	}
+	c.fileScopesLock.Unlock()
+
+	var skipped []SkippedModule
+	for _, module := range c.skippedModules {
+		if !reparse(module.Filename) {
+			skipped = append(skipped, module)
+		}
+	}
+	c.skippedModules = skipped
+
+	var warnings []error
+	for _, warning := range c.warnings {
+		if pos, ok := errorPos(warning); ok {
+			relPath, err := filepath.Rel(rootDir, pos.Filename)
+			if err != nil {
+				return nil, []error{err}
+			}
+			if reparse(relPath) {
+				continue
+			}
+		}
+		warnings = append(warnings, warning)
+	}
+	c.warnings = warnings
+
+	var walkPaths []string
+	for _, path := range filePaths {
+		relPath, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return nil, []error{err}
+		}
+		if reparse(relPath) || isAncestor(relPath) {
+			walkPaths = append(walkPaths, path)
+		}
+	}
+	if len(walkPaths) == 0 {
+		return nil, nil
+	}
+
+	return c.parseFileList(rootDir, walkPaths, reparse)
+}
+
+// moduleRemover is implemented by NameInterfaces that support removing modules,
+// which is required by ReparseChangedFiles.
+type moduleRemover interface {
+	RemoveModule(group ModuleGroup, namespace Namespace)
+}
+
+// removeModules removes the modules for which pred returns true from the
+// Context and from its NameInterface.  It may only be called before
+// ResolveDependencies, when every module group contains a single module.
+func (c *Context) removeModules(remover moduleRemover, pred func(module *moduleInfo) bool) {
+	var groups []*moduleGroup
+	for _, group := range c.moduleGroups {
+		module := group.modules[0]
+		if pred(module) {
+			remover.RemoveModule(ModuleGroup{moduleGroup: group}, group.namespace)
+			delete(c.moduleInfo, module.logicModule)
+		} else {
+			groups = append(groups, group)
+		}
+	}
+	c.moduleGroups = groups
+	c.cachedSortedModuleGroups = nil
+}
+
+// parseFileList parses the given Blueprints files and adds the modules they
+// define to the Context.  If filter is not nil only the given files are parsed,
+// without following their subdirs and build variables, and the modules of the
+// files, given relative to rootDir, for which it returns false are ignored.
+func (c *Context) parseFileList(rootDir string, filePaths []string,
+	filter func(relPath string) bool) (deps []string, errs []error) {
+
+	c.dependenciesReady = false
+
+	moduleCh := make(chan *moduleInfo)
+	errsCh := make(chan []error)
+	doneCh := make(chan struct{})
+	var numErrs uint32
+	var numGoroutines int32
+
+	// handler must be reentrant
+	handleOneFile := func(file *parser.File) {
+		if filter != nil && !filter(file.Name) {
+			return
+		}
+
+		if errs := c.validateFile(file); len(errs) > 0 {
+			atomic.AddUint32(&numErrs, uint32(len(errs)))
+			errsCh <- errs
+			return
+		}
+
+		if c.tooManyErrors(int(atomic.LoadUint32(&numErrs))) {
+			return
+		}
+
+		for _, def := range file.Defs {
+			var module *moduleInfo
+			var errs []error
+			switch def := def.(type) {
+			case *parser.Module:
+				module, errs = c.processModuleDef(def, file.Name)
+			case *parser.Assignment:
+				// Already handled via Scope object
+			default:
+				panic("unknown definition type")
+			}
+
+			if len(errs) > 0 {
+				atomic.AddUint32(&numErrs, uint32(len(errs)))
+				errsCh <- errs
+			} else if module != nil {
+				moduleCh <- module
+			}
 		}
 	}
 
 	atomic.AddInt32(&numGoroutines, 1)
 	go func() {
 		var errs []error
-		deps, errs = c.WalkBlueprintsFiles(rootDir, filePaths, handleOneFile)
+		deps, errs = c.walkBlueprintsFiles(rootDir, filePaths, handleOneFile, filter != nil)
 		if len(errs) > 0 {
 			errsCh <- errs
 		}
 		doneCh <- struct{}{}
 	}()
 
+	var errGroups [][]error
+
 loop:
 	for {
 		select {
 		case newErrs := <-errsCh:
-			errs = append(errs, newErrs...)
+			errGroups = append(errGroups, newErrs)
 		case module := <-moduleCh:
 			newErrs := c.addModule(module)
 			if len(newErrs) > 0 {
-				errs = append(errs, newErrs...)
+				errGroups = append(errGroups, newErrs)
+			} else {
+				c.warnings = append(c.warnings, module.parseWarnings...)
 			}
 		case <-doneCh:
 			n := atomic.AddInt32(&numGoroutines, -1)
@@ -692,7 +1758,28 @@ loop:
 		}
 	}
 
-	return deps, errs
+	return deps, c.limitErrors(sortErrors(errGroups))
+}
+
+// A SkippedModule describes modules that were not added to the module graph
+// because the Blueprints file that defines them is excluded by
+// AddExcludeDirPatterns.  Excluded files are never read, so the names of the
+// modules are not known and a single SkippedModule is recorded for each
+// excluded file.
+type SkippedModule struct {
+	Filename string // The Blueprints file that defines the modules
+	Reason   string // Why the module was skipped
+}
+
+// SkippedModules returns the modules that were not added to the module graph
+// by ParseBlueprintsFiles or ParseFileList because of the patterns passed to
+// AddExcludeDirPatterns, sorted by filename.
+func (c *Context) SkippedModules() []SkippedModule {
+	skipped := append([]SkippedModule(nil), c.skippedModules...)
+	sort.SliceStable(skipped, func(i, j int) bool {
+		return skipped[i].Filename < skipped[j].Filename
+	})
+	return skipped
 }
 
 type FileHandler func(*parser.File)
@@ -720,12 +1807,23 @@ type FileHandler func(*parser.File)
 func (c *Context) WalkBlueprintsFiles(rootDir string, filePaths []string,
 	visitor FileHandler) (deps []string, errs []error) {
 
+	return c.walkBlueprintsFiles(rootDir, filePaths, visitor, false)
+}
+
+// walkBlueprintsFiles is like WalkBlueprintsFiles, but if listedOnly is true the
+// Blueprints files found through the subdirs and build variables of the given
+// files are not walked.
+func (c *Context) walkBlueprintsFiles(rootDir string, filePaths []string,
+	visitor FileHandler, listedOnly bool) (deps []string, errs []error) {
+
 	// make a mapping from ancestors to their descendants to facilitate parsing ancestors first
 	descendantsMap, err := findBlueprintDescendants(filePaths)
 	if err != nil {
 		panic(err.Error())
 	}
 	blueprintsSet := make(map[string]bool)
+	excludedSet := make(map[string]bool)
+	var skipped []SkippedModule
 
 	// Channels to receive data back from openAndParse goroutines
 	blueprintsCh := make(chan fileParseContext)
@@ -748,16 +1846,40 @@ func (c *Context) WalkBlueprintsFiles(rootDir string, filePaths []string,
 	visitorWaitGroup := sync.WaitGroup{}
 
 	startParseBlueprintsFile := func(blueprint fileParseContext) {
-		if blueprintsSet[blueprint.fileName] {
+		if blueprintsSet[blueprint.fileName] || excludedSet[blueprint.fileName] {
 			return
 		}
-		blueprintsSet[blueprint.fileName] = true
+
+		// Excluded files are not opened, but are still treated as parsed so that the
+		// files below them in filePaths are walked.
+		pattern, excludeErr := c.excludedBlueprintsFile(rootDir, blueprint.fileName)
+		excluded := excludeErr != nil || pattern != ""
+		if excluded {
+			excludedSet[blueprint.fileName] = true
+			if pattern != "" {
+				relFile, _ := filepath.Rel(rootDir, blueprint.fileName)
+				skipped = append(skipped, SkippedModule{
+					Filename: relFile,
+					Reason:   fmt.Sprintf("directory excluded by pattern %q", pattern),
+				})
+			}
+		} else {
+			blueprintsSet[blueprint.fileName] = true
+			deps = append(deps, blueprint.fileName)
+		}
 		activeCount++
-		deps = append(deps, blueprint.fileName)
 		visitorWaitGroup.Add(1)
 		go func() {
-			file, blueprints, deps, errs := c.openAndParse(blueprint.fileName, blueprint.Scope, rootDir,
-				&blueprint)
+			var file *parser.File
+			var blueprints []fileParseContext
+			var deps []string
+			var errs []error
+			if excludeErr != nil {
+				errs = []error{excludeErr}
+			} else if !excluded {
+				file, blueprints, deps, errs = c.openAndParse(blueprint.fileName, blueprint.Scope,
+					rootDir, &blueprint)
+			}
 			if len(errs) > 0 {
 				errsCh <- errs
 			}
@@ -774,7 +1896,8 @@ func (c *Context) WalkBlueprintsFiles(rootDir string, filePaths []string,
 				<-blueprint.parent.doneVisiting
 			}
 
-			if len(errs) == 0 {
+			if file != nil && len(errs) == 0 && c.Context.Err() == nil {
+				c.setFileScope(file.Name, blueprint.Scope)
 				// process this file
 				visitor(file)
 			}
@@ -803,21 +1926,34 @@ func (c *Context) WalkBlueprintsFiles(rootDir string, filePaths []string,
 	}
 
 	// begin parsing any files that have no ancestors
-	startParseDescendants(fileParseContext{"", parser.NewScope(nil), nil, nil})
+	startParseDescendants(fileParseContext{"", c.newRootScope(), nil, nil})
+
+	var errGroups [][]error
+	numErrs := 0
+
+	// Stop starting new files if the Context's context.Context is cancelled
+	ctxDoneCh := c.Context.Done()
 
 loop:
 	for {
-		if len(errs) > maxErrors {
+		if c.tooManyErrors(numErrs) {
 			tooManyErrors = true
 		}
 
 		select {
+		case <-ctxDoneCh:
+			// Treat cancellation like too many errors, and wait for the files that are
+			// already being parsed to finish.
+			tooManyErrors = true
+			pending = nil
+			ctxDoneCh = nil
 		case newErrs := <-errsCh:
-			errs = append(errs, newErrs...)
+			errGroups = append(errGroups, newErrs)
+			numErrs += len(newErrs)
 		case dep := <-depsCh:
 			deps = append(deps, dep)
 		case blueprint := <-blueprintsCh:
-			if tooManyErrors {
+			if tooManyErrors || listedOnly {
 				continue
 			}
 			foundParseableBlueprint(blueprint)
@@ -839,10 +1975,23 @@ loop:
 	}
 
 	sort.Strings(deps)
+	errs = c.limitErrors(sortErrors(errGroups))
+
+	if c.blueprintFiles == nil {
+		c.blueprintFiles = make(map[string]bool)
+	}
+	for file := range blueprintsSet {
+		c.blueprintFiles[file] = true
+	}
+	c.skippedModules = append(c.skippedModules, skipped...)
 
 	// wait for every visitor() to complete
 	visitorWaitGroup.Wait()
 
+	if err := c.Context.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
 	return
 }
 
@@ -852,15 +2001,15 @@ func (c *Context) MockFileSystem(files map[string][]byte) {
 	// look for a module list file
 	_, ok := files[MockModuleListFile]
 	if !ok {
-		// no module list file specified; find every file named Blueprints
+		// no module list file specified; find every Blueprints file
 		pathsToParse := []string{}
 		for candidate := range files {
-			if filepath.Base(candidate) == "Blueprints" {
+			if filepath.Base(candidate) == c.blueprintsFile() {
 				pathsToParse = append(pathsToParse, candidate)
 			}
 		}
 		if len(pathsToParse) < 1 {
-			panic(fmt.Sprintf("No Blueprints files found in mock filesystem: %v\n", files))
+			panic(fmt.Sprintf("No %s files found in mock filesystem: %v\n", c.blueprintsFile(), files))
 		}
 		// put the list of Blueprints files into a list file
 		files[MockModuleListFile] = []byte(strings.Join(pathsToParse, "\n"))
@@ -905,7 +2054,16 @@ func (c *Context) openAndParse(filename string, scope *parser.Scope, rootDir str
 				errs = append(errs, err)
 			}
 		}()
-		file, subBlueprints, errs = c.parseOne(rootDir, filename, f, scope, parent)
+
+		var reader io.Reader = f
+		if c.preParseHook != nil {
+			reader, errs = c.runPreParseHook(filename, f)
+			if len(errs) > 0 {
+				return
+			}
+		}
+
+		file, subBlueprints, errs = c.parseOne(rootDir, filename, reader, scope, parent)
 	}()
 
 	if len(errs) > 0 {
@@ -919,6 +2077,25 @@ func (c *Context) openAndParse(filename string, scope *parser.Scope, rootDir str
 	return file, subBlueprints, deps, nil
 }
 
+// runPreParseHook reads the contents of a Blueprints file and passes them
+// through the hook set by SetPreParseHook, returning a reader for the result.
+func (c *Context) runPreParseHook(filename string, r io.Reader) (io.Reader, []error) {
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	contents, err = c.preParseHook(filename, contents)
+	if err != nil {
+		return nil, []error{&BlueprintError{
+			Err: err,
+			Pos: scanner.Position{Filename: filename, Line: 1, Column: 1},
+		}}
+	}
+
+	return bytes.NewReader(contents), nil
+}
+
 // parseOne parses a single Blueprints file from the given reader, creating Module
 // objects for each of the module definitions encountered.  If the Blueprints
 // file contains an assignment to the "subdirs" variable, then the
@@ -941,24 +2118,18 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 	scope.Remove("subdirs")
 	scope.Remove("optional_subdirs")
 	scope.Remove("build")
-	file, errs = parser.ParseAndEval(filename, reader, scope)
+	file, errs = parseAndEval(filename, reader, scope)
 	if len(errs) > 0 {
-		for i, err := range errs {
-			if parseErr, ok := err.(*parser.ParseError); ok {
-				err = &BlueprintError{
-					Err: parseErr.Err,
-					Pos: parseErr.Pos,
-				}
-				errs[i] = err
-			}
-		}
-
 		// If there were any parse errors don't bother trying to interpret the
 		// result.
 		return nil, nil, errs
 	}
 	file.Name = relBlueprintsFile
 
+	if c.parseSingleFileOnly {
+		return file, nil, nil
+	}
+
 	build, buildPos, err := getLocalStringListFromScope(scope, "build")
 	if err != nil {
 		errs = append(errs, err)
@@ -978,7 +2149,7 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 	}
 
 	if subBlueprintsName == "" {
-		subBlueprintsName = "Blueprints"
+		subBlueprintsName = c.blueprintsFile()
 	}
 
 	var blueprints []string
@@ -994,6 +2165,71 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 	return file, subBlueprintsAndScope, errs
 }
 
+// parseAndEval parses and evaluates a single Blueprints file and converts any
+// parser errors into BlueprintErrors.
+func parseAndEval(filename string, reader io.Reader, scope *parser.Scope) (*parser.File, []error) {
+	file, errs := parser.ParseAndEval(filename, reader, scope)
+
+	for i, err := range errs {
+		if parseErr, ok := err.(*parser.ParseError); ok {
+			errs[i] = &BlueprintError{
+				Err: parseErr.Err,
+				Pos: parseErr.Pos,
+			}
+		}
+	}
+
+	return file, errs
+}
+
+// ParseAndValidate parses the Blueprints file contents in src as if they had
+// been read from filename, and creates and unpacks the properties of each of
+// the modules it defines using the registered module types.  The modules are
+// returned without being added to the Context, and no other files are read and
+// no mutators are run, so it can be used to check the contents of a single
+// file in isolation, for example for fuzzing.  config is currently unused, it
+// is accepted so that validation can later depend on the configuration.
+//
+// ParseAndValidate never panics, a panic while processing the file, for
+// example in a module factory, is returned as an error.
+func (c *Context) ParseAndValidate(filename string, src []byte,
+	config interface{}) (modules []Module, errs []error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			modules = nil
+			errs = append(errs, newPanicErrorf(r, "ParseAndValidate(%s)", filename))
+		}
+	}()
+
+	file, errs := parseAndEval(filename, bytes.NewReader(src), parser.NewScope(c.newRootScope()))
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	for _, def := range file.Defs {
+		moduleDef, ok := def.(*parser.Module)
+		if !ok {
+			continue
+		}
+
+		module, moduleErrs := c.processModuleDef(moduleDef, filename)
+		if len(moduleErrs) > 0 {
+			errs = append(errs, moduleErrs...)
+			continue
+		}
+		if module != nil {
+			modules = append(modules, module.logicModule)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return modules, nil
+}
+
 func (c *Context) findBuildBlueprints(dir string, build []string,
 	buildPos scanner.Position) ([]string, []error) {
 
@@ -1208,6 +2444,23 @@ func (c *Context) createVariations(origModule *moduleInfo, mutatorName string,
 	return newModules, errs
 }
 
+func (c *Context) tooManyVariantsError(group *moduleGroup) error {
+	module := group.modules[0]
+
+	var axes []string
+	for mutator := range module.variant {
+		axes = append(axes, mutator)
+	}
+	sort.Strings(axes)
+
+	return &BlueprintError{
+		Err: fmt.Errorf("module %q has %d variants, more than the maximum of %d, "+
+			"created by mutators: %s", group.name, len(group.modules),
+			c.maxVariantsPerModule, strings.Join(axes, ", ")),
+		Pos: module.pos,
+	}
+}
+
 func (c *Context) convertDepsToVariation(module *moduleInfo,
 	mutatorName, variationName string) (errs []error) {
 
@@ -1281,10 +2534,12 @@ func (c *Context) processModuleDef(moduleDef *parser.Module,
 
 	module.relBlueprintsFile = relBlueprintsFile
 
-	propertyMap, errs := unpackProperties(moduleDef.Properties, module.properties...)
+	propertyMap, warnings, errs := unpackPropertiesWithHook(moduleDef.Properties,
+		c.propertyUnpackHook, module.properties...)
 	if len(errs) > 0 {
 		return nil, errs
 	}
+	module.parseWarnings = warnings
 
 	module.pos = moduleDef.TypePos
 	module.propertyPos = make(map[string]scanner.Position)
@@ -1292,9 +2547,29 @@ func (c *Context) processModuleDef(moduleDef *parser.Module,
 		module.propertyPos[name] = propertyDef.ColonPos
 	}
 
+	setPropertyDefaults(module, func(property string) bool {
+		_, ok := module.propertyPos[property]
+		return ok
+	})
+
 	return module, nil
 }
 
+// setPropertyDefaults records the defaults of a PropertyDefaultsModule for the
+// properties for which isSet returns false.
+func setPropertyDefaults(module *moduleInfo, isSet func(property string) bool) {
+	if m, ok := module.logicModule.(PropertyDefaultsModule); ok {
+		for name, def := range m.PropertyDefaults() {
+			if !isSet(name) {
+				if module.propertyDefaults == nil {
+					module.propertyDefaults = make(map[string]PropertyDefault)
+				}
+				module.propertyDefaults[name] = def
+			}
+		}
+	}
+}
+
 func (c *Context) addModule(module *moduleInfo) []error {
 	name := module.logicModule.Name()
 	if name == "" {
@@ -1305,6 +2580,9 @@ func (c *Context) addModule(module *moduleInfo) []error {
 			},
 		}
 	}
+	if err := c.validateModuleName(name, module.pos); err != nil {
+		return []error{err}
+	}
 	c.moduleInfo[module.logicModule] = module
 
 	group := &moduleGroup{
@@ -1334,11 +2612,46 @@ func (c *Context) addModule(module *moduleInfo) []error {
 // the modules depended upon are defined and that no circular dependencies
 // exist.
 func (c *Context) ResolveDependencies(config interface{}) (deps []string, errs []error) {
-	return c.resolveDependencies(c.Context, config)
+	return c.resolveDependencies(c.Context, config, "")
+}
+
+// ResolveDependenciesUntil is like ResolveDependencies, but stops after running
+// the mutator registered with the name mutatorName, leaving the module graph
+// as it was at that point so that it can be inspected.  Later mutators, whole
+// graph mutators and the final cleanup of the graph are not run, so the
+// dependencies are not considered resolved and PrepareBuildActions returns an
+// error if it is called afterwards, until ResolveDependencies has completed
+// successfully.
+func (c *Context) ResolveDependenciesUntil(config interface{},
+	mutatorName string) (deps []string, errs []error) {
+
+	if !c.isMutatorRegistered(mutatorName) {
+		return nil, []error{fmt.Errorf("unknown mutator %q", mutatorName)}
+	}
+
+	return c.resolveDependencies(c.Context, config, mutatorName)
+}
+
+func (c *Context) isMutatorRegistered(name string) bool {
+	for _, mutators := range [][]*mutatorInfo{c.earlyMutatorInfo, c.mutatorInfo} {
+		for _, mutator := range mutators {
+			if mutator.name == name {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (deps []string, errs []error) {
+func (c *Context) resolveDependencies(ctx context.Context, config interface{},
+	stopAfterMutator string) (deps []string, errs []error) {
+
 	pprof.Do(ctx, pprof.Labels("blueprint", "ResolveDependencies"), func(ctx context.Context) {
+		c.beginEvent("phase", "ResolveDependencies")
+		defer c.endEvent("phase", "ResolveDependencies")
+
+		c.dependenciesReady = false
+		c.resolvingDependenciesBegun = true
 		c.liveGlobals = newLiveTracker(config)
 
 		deps, errs = c.generateSingletonBuildActions(config, c.preSingletonInfo, c.liveGlobals)
@@ -1351,16 +2664,45 @@ func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (
 			return
 		}
 
+		if stopAfterMutator != "" {
+			c.dependenciesPartial = true
+		}
+
 		var mutatorDeps []string
-		mutatorDeps, errs = c.runMutators(ctx, config)
+		mutatorDeps, errs = c.runMutators(ctx, config, stopAfterMutator)
 		if len(errs) > 0 {
 			return
 		}
 		deps = append(deps, mutatorDeps...)
 
+		if stopAfterMutator != "" {
+			return
+		}
+
+		errs = c.runWholeGraphMutators(ctx, config)
+		if len(errs) > 0 {
+			return
+		}
+
+		if c.sortDependencies {
+			c.sortDirectDeps()
+		}
+
 		c.cloneModules()
 
+		if c.visibilityChecker != nil {
+			errs = c.limitErrors(c.checkVisibility())
+			if len(errs) > 0 {
+				return
+			}
+		}
+
+		if c.highFanoutWarningThreshold > 0 {
+			c.warnings = append(c.warnings, c.checkHighFanout()...)
+		}
+
 		c.dependenciesReady = true
+		c.dependenciesPartial = false
 	})
 
 	if len(errs) > 0 {
@@ -1409,13 +2751,19 @@ func (c *Context) findMatchingVariant(module *moduleInfo, possible []*moduleInfo
 	return nil
 }
 
-func (c *Context) addDependency(module *moduleInfo, tag DependencyTag, depName string) []error {
+// addDependency adds a dependency on the variant of the module named depName
+// that matches module, and returns the dependency.  If index is negative the
+// dependency is appended to the existing dependencies of module, otherwise it
+// is inserted at index.
+func (c *Context) addDependency(module *moduleInfo, tag DependencyTag, depName string,
+	index int) (*moduleInfo, []error) {
+
 	if _, ok := tag.(BaseDependencyTag); ok {
 		panic("BaseDependencyTag is not allowed to be used directly!")
 	}
 
 	if depName == module.Name() {
-		return []error{&BlueprintError{
+		return nil, []error{&BlueprintError{
 			Err: fmt.Errorf("%q depends on itself", depName),
 			Pos: module.pos,
 		}}
@@ -1423,13 +2771,19 @@ func (c *Context) addDependency(module *moduleInfo, tag DependencyTag, depName s
 
 	possibleDeps := c.modulesFromName(depName, module.namespace())
 	if possibleDeps == nil {
-		return c.discoveredMissingDependencies(module, depName)
+		return nil, c.discoveredMissingDependencies(module, depName)
 	}
 
 	if m := c.findMatchingVariant(module, possibleDeps); m != nil {
-		module.directDeps = append(module.directDeps, depInfo{m, tag})
+		if index < 0 {
+			module.directDeps = append(module.directDeps, depInfo{m, tag})
+		} else {
+			module.directDeps = append(module.directDeps, depInfo{})
+			copy(module.directDeps[index+1:], module.directDeps[index:])
+			module.directDeps[index] = depInfo{m, tag}
+		}
 		atomic.AddUint32(&c.depsModified, 1)
-		return nil
+		return m, nil
 	}
 
 	variants := make([]string, len(possibleDeps))
@@ -1438,7 +2792,7 @@ func (c *Context) addDependency(module *moduleInfo, tag DependencyTag, depName s
 	}
 	sort.Strings(variants)
 
-	return []error{&BlueprintError{
+	return nil, []error{&BlueprintError{
 		Err: fmt.Errorf("dependency %q of %q missing variant:\n  %s\navailable variants:\n  %s",
 			depName, module.Name(),
 			c.prettyPrintVariant(module.dependencyVariant),
@@ -1723,11 +3077,18 @@ func (c *Context) parallelVisit(order visitOrderer, visit func(group *moduleInfo
 		}
 	}
 
+	// Stop visiting new modules if the Context's context.Context is cancelled
+	ctxDoneCh := c.Context.Done()
+
 	for count > 0 || len(backlog) > 0 {
 		select {
 		case <-cancelCh:
 			cancel = true
 			backlog = nil
+		case <-ctxDoneCh:
+			cancel = true
+			backlog = nil
+			ctxDoneCh = nil
 		case doneModule := <-doneCh:
 			count--
 			if !cancel {
@@ -1747,6 +3108,16 @@ func (c *Context) parallelVisit(order visitOrderer, visit func(group *moduleInfo
 	}
 }
 
+// UpdateDependencies recomputes the dependency ordering of the modules, and the
+// dependency and reverse dependency lists derived from each module's direct
+// dependencies, in the same way as ResolveDependencies does after each
+// mutator.  It returns errors for any dependency cycles.  It is intended for
+// tests and tools that modify the dependency graph after ResolveDependencies
+// and should not be needed in normal use.
+func (c *Context) UpdateDependencies() []error {
+	return c.updateDependencies()
+}
+
 // updateDependencies recursively walks the module dependency graph and updates
 // additional fields based on the dependencies.  It builds a sorted list of modules
 // such that dependencies of a module always appear first, and populates reverse
@@ -1879,13 +3250,24 @@ func (c *Context) updateDependencies() (errs []error) {
 // by the modules and singletons via the ModuleContext.AddNinjaFileDeps(),
 // SingletonContext.AddNinjaFileDeps(), and PackageContext.AddNinjaFileDeps()
 // methods.
+//
+// Warnings reported by modules do not cause PrepareBuildActions to fail, they
+// can be retrieved afterwards with the Warnings method.
 func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs []error) {
 	pprof.Do(c.Context, pprof.Labels("blueprint", "PrepareBuildActions"), func(ctx context.Context) {
+		c.beginEvent("phase", "PrepareBuildActions")
+		defer c.endEvent("phase", "PrepareBuildActions")
+
 		c.buildActionsReady = false
 
+		if c.dependenciesPartial {
+			errs = []error{fmt.Errorf("PrepareBuildActions called after ResolveDependenciesUntil")}
+			return
+		}
+
 		if !c.dependenciesReady {
 			var extraDeps []string
-			extraDeps, errs = c.resolveDependencies(ctx, config)
+			extraDeps, errs = c.resolveDependencies(ctx, config, "")
 			if len(errs) > 0 {
 				return
 			}
@@ -1893,7 +3275,11 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 		}
 
 		var depsModules []string
-		depsModules, errs = c.generateModuleBuildActions(config, c.liveGlobals)
+		var warnings []error
+		c.beginEvent("phase", "generateModuleBuildActions")
+		depsModules, warnings, errs = c.generateModuleBuildActions(config, c.liveGlobals)
+		c.endEvent("phase", "generateModuleBuildActions")
+		c.warnings = append(c.warnings, warnings...)
 		if len(errs) > 0 {
 			return
 		}
@@ -1915,6 +3301,11 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 			}
 		}
 
+		if c.liveGlobals.hasValidations {
+			// The "|@" syntax for validations was added in ninja 1.10.
+			c.requireNinjaVersion(1, 10, 0)
+		}
+
 		pkgNames, depsPackages := c.makeUniquePackageNames(c.liveGlobals)
 
 		deps = append(deps, depsPackages...)
@@ -1937,15 +3328,50 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 	return deps, nil
 }
 
-func (c *Context) runMutators(ctx context.Context, config interface{}) (deps []string, errs []error) {
+// A MutatorTiming records how long a single mutator took to run during
+// ResolveDependencies and how many modules it was run on.
+type MutatorTiming struct {
+	Name           string
+	Duration       time.Duration
+	ModulesVisited int
+}
+
+// MutatorTimings returns the time taken by each mutator during the last call
+// to ResolveDependencies, sorted by descending duration.
+func (c *Context) MutatorTimings() []MutatorTiming {
+	timings := append([]MutatorTiming(nil), c.mutatorTimings...)
+	sort.SliceStable(timings, func(i, j int) bool {
+		return timings[i].Duration > timings[j].Duration
+	})
+	return timings
+}
+
+func (c *Context) runMutators(ctx context.Context, config interface{},
+	stopAfterMutator string) (deps []string, errs []error) {
+
 	var mutators []*mutatorInfo
 
+	c.mutatorTimings = nil
+
 	pprof.Do(ctx, pprof.Labels("blueprint", "runMutators"), func(ctx context.Context) {
 		mutators = append(mutators, c.earlyMutatorInfo...)
 		mutators = append(mutators, c.mutatorInfo...)
 
 		for _, mutator := range mutators {
 			pprof.Do(ctx, pprof.Labels("mutator", mutator.name), func(context.Context) {
+				c.beginEvent("mutator", mutator.name)
+				defer c.endEvent("mutator", mutator.name)
+
+				start := time.Now()
+				modulesVisited := len(c.modulesSorted)
+				defer func() {
+					c.mutatorTimings = append(c.mutatorTimings, MutatorTiming{
+						Name:           mutator.name,
+						Duration:       time.Since(start),
+						ModulesVisited: modulesVisited,
+					})
+				}()
+
 				var newDeps []string
 				if mutator.topDownMutator != nil {
 					newDeps, errs = c.runMutator(config, mutator, topDownMutator)
@@ -1959,7 +3385,7 @@ func (c *Context) runMutators(ctx context.Context, config interface{}) (deps []s
 				}
 				deps = append(deps, newDeps...)
 			})
-			if len(errs) > 0 {
+			if len(errs) > 0 || mutator.name == stopAfterMutator {
 				return
 			}
 		}
@@ -1972,6 +3398,54 @@ func (c *Context) runMutators(ctx context.Context, config interface{}) (deps []s
 	return deps, nil
 }
 
+func (c *Context) runWholeGraphMutators(ctx context.Context, config interface{}) (errs []error) {
+	for _, info := range c.wholeGraphMutatorInfo {
+		wctx := &wholeGraphMutatorContext{
+			name:    info.name,
+			context: c,
+			config:  config,
+		}
+
+		pprof.Do(ctx, pprof.Labels("mutator", info.name), func(context.Context) {
+			defer func() {
+				if r := recover(); r != nil {
+					in := fmt.Sprintf("whole graph mutator %q", info.name)
+					if err, ok := r.(panicError); ok {
+						err.addIn(in)
+						wctx.error(err)
+					} else {
+						wctx.error(newPanicErrorf(r, "%s", in))
+					}
+				}
+			}()
+			info.mutator(wctx)
+		})
+
+		if len(wctx.errs) > 0 {
+			return wctx.errs
+		}
+
+		if len(wctx.newModules) > 0 {
+			for _, module := range wctx.newModules {
+				errs = append(errs, c.addModule(module)...)
+			}
+			if len(errs) > 0 {
+				return errs
+			}
+
+			// The new modules need to be visible to later whole graph mutators.
+			c.cachedSortedModuleGroups = nil
+
+			errs = c.updateDependencies()
+			if len(errs) > 0 {
+				return errs
+			}
+		}
+	}
+
+	return nil
+}
+
 type mutatorDirection interface {
 	run(mutator *mutatorInfo, ctx *mutatorContext)
 	orderer() visitOrderer
@@ -2027,6 +3501,7 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 	type globalStateChange struct {
 		reverse    []reverseDep
 		rename     []rename
+		alias      []alias
 		replace    []replace
 		newModules []*moduleInfo
 		deps       []string
@@ -2034,25 +3509,34 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 
 	reverseDeps := make(map[*moduleInfo][]depInfo)
 	var rename []rename
+	var alias []alias
 	var replace []replace
 	var newModules []*moduleInfo
+	var errGroups [][]error
+	var warnings []error
 
 	errsCh := make(chan []error)
+	warningsCh := make(chan []error)
 	globalStateCh := make(chan globalStateChange)
 	newVariationsCh := make(chan []*moduleInfo)
 	done := make(chan bool)
 
 	c.depsModified = 0
+	c.mutatorGeneration++
 
 	visit := func(module *moduleInfo) bool {
 		if module.splitModules != nil {
 			panic("split module found in sorted module list")
 		}
 
+		if c.Context.Err() != nil {
+			return true
+		}
+
 		mctx := &mutatorContext{
 			baseModuleContext: baseModuleContext{
 				context: c,
-				config:  config,
+				config:  c.moduleConfig(config, module),
 				module:  module,
 			},
 			name: mutator.name,
@@ -2073,6 +3557,12 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 			direction.run(mutator, mctx)
 		}()
 
+		atomic.StoreInt32(&module.finishedMutator, c.mutatorGeneration)
+
+		if len(mctx.warnings) > 0 {
+			warningsCh <- mctx.warnings
+		}
+
 		if len(mctx.errs) > 0 {
 			errsCh <- mctx.errs
 			return true
@@ -2082,11 +3572,14 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 			newVariationsCh <- mctx.newVariations
 		}
 
-		if len(mctx.reverseDeps) > 0 || len(mctx.replace) > 0 || len(mctx.rename) > 0 || len(mctx.newModules) > 0 {
+		if len(mctx.reverseDeps) > 0 || len(mctx.replace) > 0 || len(mctx.rename) > 0 ||
+			len(mctx.alias) > 0 || len(mctx.newModules) > 0 {
+
 			globalStateCh <- globalStateChange{
 				reverse:    mctx.reverseDeps,
 				replace:    mctx.replace,
 				rename:     mctx.rename,
+				alias:      mctx.alias,
 				newModules: mctx.newModules,
 				deps:       mctx.ninjaFileDeps,
 			}
@@ -2100,13 +3593,16 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 		for {
 			select {
 			case newErrs := <-errsCh:
-				errs = append(errs, newErrs...)
+				errGroups = append(errGroups, newErrs)
+			case newWarnings := <-warningsCh:
+				warnings = append(warnings, newWarnings...)
 			case globalStateChange := <-globalStateCh:
 				for _, r := range globalStateChange.reverse {
 					reverseDeps[r.module] = append(reverseDeps[r.module], r.dep)
 				}
 				replace = append(replace, globalStateChange.replace...)
 				rename = append(rename, globalStateChange.rename...)
+				alias = append(alias, globalStateChange.alias...)
 				newModules = append(newModules, globalStateChange.newModules...)
 				deps = append(deps, globalStateChange.deps...)
 			case newVariations := <-newVariationsCh:
@@ -2127,8 +3623,16 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 
 	done <- true
 
-	if len(errs) > 0 {
-		return nil, errs
+	c.warnings = append(c.warnings, warnings...)
+
+	if err := c.Context.Err(); err != nil {
+		errGroups = append(errGroups, []error{err})
+	}
+
+	if len(errGroups) > 0 {
+		// Mutators may run in parallel, sort the errors so that they are
+		// reported in the same order every time.
+		return nil, sortErrors(errGroups)
 	}
 
 	c.moduleInfo = newModuleInfo
@@ -2150,6 +3654,14 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 				}
 			}
 		}
+
+		if c.maxVariantsPerModule > 0 && len(group.modules) > c.maxVariantsPerModule {
+			errs = append(errs, c.tooManyVariantsError(group))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
 	}
 
 	for module, deps := range reverseDeps {
@@ -2171,6 +3683,11 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 		return nil, errs
 	}
 
+	errs = c.handleAliases(alias)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
 	errs = c.handleReplacements(replace)
 	if len(errs) > 0 {
 		return nil, errs
@@ -2242,13 +3759,17 @@ func spliceModules(modules []*moduleInfo, i int, newModules []*moduleInfo) ([]*m
 }
 
 func (c *Context) generateModuleBuildActions(config interface{},
-	liveGlobals *liveTracker) ([]string, []error) {
+	liveGlobals *liveTracker) (deps []string, warnings []error, errs []error) {
 
-	var deps []string
-	var errs []error
+	if c.ninjaNameSanitizer != nil {
+		if errs = c.checkModuleNinjaPrefixes(); len(errs) > 0 {
+			return nil, nil, errs
+		}
+	}
 
 	cancelCh := make(chan struct{})
 	errsCh := make(chan []error)
+	warningsCh := make(chan []error)
 	depsCh := make(chan []string)
 
 	go func() {
@@ -2259,6 +3780,8 @@ func (c *Context) generateModuleBuildActions(config interface{},
 				return
 			case newErrs := <-errsCh:
 				errs = append(errs, newErrs...)
+			case newWarnings := <-warningsCh:
+				warnings = append(warnings, newWarnings...)
 			case newDeps := <-depsCh:
 				deps = append(deps, newDeps...)
 
@@ -2268,10 +3791,7 @@ func (c *Context) generateModuleBuildActions(config interface{},
 
 	c.parallelVisit(bottomUpVisitor, func(module *moduleInfo) bool {
 
-		uniqueName := c.nameInterface.UniqueName(newNamespaceContext(module), module.group.name)
-		sanitizedName := toNinjaName(uniqueName)
-
-		prefix := moduleNamespacePrefix(sanitizedName + "_" + module.variantName)
+		prefix := c.moduleNinjaPrefix(module)
 
 		// The parent scope of the moduleContext's local scope gets overridden to be that of the
 		// calling Go package on a per-call basis.  Since the initial parent scope doesn't matter we
@@ -2281,7 +3801,7 @@ func (c *Context) generateModuleBuildActions(config interface{},
 		mctx := &moduleContext{
 			baseModuleContext: baseModuleContext{
 				context: c,
-				config:  config,
+				config:  c.moduleConfig(config, module),
 				module:  module,
 			},
 			scope:              scope,
@@ -2300,9 +3820,19 @@ func (c *Context) generateModuleBuildActions(config interface{},
 					}
 				}
 			}()
+			atomic.StoreInt32(&module.startedGenerateBuildActions, 1)
 			mctx.module.logicModule.GenerateBuildActions(mctx)
 		}()
 
+		if mctx.defaultTarget && len(mctx.actionDefs.buildDefs) == 0 {
+			mctx.ModuleErrorf("SetAsDefaultTarget called without any build statements")
+		}
+		module.defaultTarget = mctx.defaultTarget
+
+		if len(mctx.warnings) > 0 {
+			warningsCh <- mctx.warnings
+		}
+
 		if len(mctx.errs) > 0 {
 			errsCh <- mctx.errs
 			return true
@@ -2325,13 +3855,19 @@ func (c *Context) generateModuleBuildActions(config interface{},
 			errsCh <- newErrs
 			return true
 		}
+
+		atomic.StoreInt32(&module.finishedGenerateBuildActions, 1)
 		return false
 	})
 
 	cancelCh <- struct{}{}
 	<-cancelCh
 
-	return deps, errs
+	if err := c.Context.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return deps, warnings, errs
 }
 
 func (c *Context) generateSingletonBuildActions(config interface{},
@@ -2366,12 +3902,14 @@ func (c *Context) generateSingletonBuildActions(config interface{},
 					}
 				}
 			}()
+			c.beginEvent("singleton", info.name)
+			defer c.endEvent("singleton", info.name)
 			info.singleton.GenerateBuildActions(sctx)
 		}()
 
 		if len(sctx.errs) > 0 {
 			errs = append(errs, sctx.errs...)
-			if len(errs) > maxErrors {
+			if c.tooManyErrors(len(errs)) {
 				break
 			}
 			continue
@@ -2382,12 +3920,12 @@ func (c *Context) generateSingletonBuildActions(config interface{},
 		newErrs := c.processLocalBuildActions(&info.actionDefs,
 			&sctx.actionDefs, liveGlobals)
 		errs = append(errs, newErrs...)
-		if len(errs) > maxErrors {
+		if c.tooManyErrors(len(errs)) {
 			break
 		}
 	}
 
-	return deps, errs
+	return deps, c.limitErrors(errs)
 }
 
 func (c *Context) processLocalBuildActions(out, in *localBuildActions,
@@ -2421,6 +3959,13 @@ func (c *Context) processLocalBuildActions(out, in *localBuildActions,
 		}
 	}
 
+	for _, p := range in.pools {
+		isLive := liveGlobals.RemovePoolIfLive(p)
+		if isLive {
+			out.pools = append(out.pools, p)
+		}
+	}
+
 	for _, r := range in.rules {
 		isLive := liveGlobals.RemoveRuleIfLive(r)
 		if isLive {
@@ -2476,6 +4021,11 @@ type rename struct {
 	name  string
 }
 
+type alias struct {
+	group *moduleGroup
+	name  string
+}
+
 func (c *Context) moduleMatchingVariant(module *moduleInfo, name string) *moduleInfo {
 	targets := c.modulesFromName(name, module.namespace())
 
@@ -2500,12 +4050,52 @@ func (c *Context) handleRenames(renames []rename) []error {
 			continue
 		}
 
+		if err := c.validateModuleName(name, group.modules[0].pos); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
 		errs = append(errs, c.nameInterface.Rename(group.name, rename.name, group.namespace)...)
 	}
 
 	return errs
 }
 
+// moduleAliaser is implemented by NameInterfaces that support aliases, which is
+// required by CreateAlias.
+type moduleAliaser interface {
+	Alias(aliasName string, group ModuleGroup, namespace Namespace) []error
+}
+
+func (c *Context) handleAliases(aliases []alias) []error {
+	var errs []error
+	for _, alias := range aliases {
+		group, name := alias.group, alias.name
+		if name == group.name || len(group.modules) < 1 {
+			continue
+		}
+
+		aliaser, ok := c.nameInterface.(moduleAliaser)
+		if !ok {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("alias %q for module %q can't be created with a NameInterface "+
+					"that doesn't support aliases", name, group.name),
+				Pos: group.modules[0].pos,
+			})
+			continue
+		}
+
+		for _, err := range aliaser.Alias(name, ModuleGroup{group}, group.namespace) {
+			errs = append(errs, &BlueprintError{
+				Err: err,
+				Pos: group.modules[0].pos,
+			})
+		}
+	}
+
+	return errs
+}
+
 func (c *Context) handleReplacements(replacements []replace) []error {
 	var errs []error
 	for _, replace := range replacements {
@@ -2602,6 +4192,25 @@ func (c *Context) visitAllModulesIf(pred func(Module) bool,
 	}
 }
 
+func (c *Context) visitAllModulesUntil(visit func(Module) bool) {
+	var module *moduleInfo
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitAllModulesUntil(%s) for %s",
+				funcName(visit), module))
+		}
+	}()
+
+	for _, moduleGroup := range c.sortedModuleGroups() {
+		for _, module = range moduleGroup.modules {
+			if visit(module.logicModule) {
+				return
+			}
+		}
+	}
+}
+
 func (c *Context) visitAllModuleVariants(module *moduleInfo,
 	visit func(Module)) {
 
@@ -2811,156 +4420,1046 @@ func (c *Context) AllTargets() (map[string]string, error) {
 	return targets, nil
 }
 
-func (c *Context) NinjaBuildDir() (string, error) {
-	if c.ninjaBuildDir != nil {
-		return c.ninjaBuildDir.Eval(c.globalVariables)
-	} else {
-		return "", nil
-	}
+// A BuildAction describes a single build statement generated by a module, with
+// all variable references in its inputs, outputs and description evaluated.
+type BuildAction struct {
+	Rule        string
+	Inputs      []string
+	Outputs     []string
+	Description string
 }
 
-// ModuleTypePropertyStructs returns a mapping from module type name to a list of pointers to
-// property structs returned by the factory for that module type.
-func (c *Context) ModuleTypePropertyStructs() map[string][]interface{} {
-	ret := make(map[string][]interface{})
-	for moduleType, factory := range c.moduleFactories {
-		_, ret[moduleType] = factory()
+// ModuleBuildActions returns the build statements generated by the given
+// module, in the order they were added.  Implicit inputs and outputs are
+// included in Inputs and Outputs after the explicit ones.  Description is the
+// description passed in BuildParams, if any, and does not include the
+// description of the rule.  It may only be called after PrepareBuildActions,
+// and returns an error if the module is not in the Context.
+func (c *Context) ModuleBuildActions(logicModule Module) ([]BuildAction, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
 	}
 
-	return ret
-}
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		return nil, fmt.Errorf("ModuleBuildActions called with a module that is not in the Context")
+	}
+	variables := c.moduleActionVariables(module)
 
-func (c *Context) ModuleTypeFactories() map[string]ModuleFactory {
-	ret := make(map[string]ModuleFactory)
-	for k, v := range c.moduleFactories {
-		ret[k] = v
+	evalList := func(lists ...[]*ninjaString) ([]string, error) {
+		var ret []string
+		for _, list := range lists {
+			for _, s := range list {
+				value, err := s.evalLayers(variables)
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, value)
+			}
+		}
+		return ret, nil
 	}
-	return ret
-}
 
-func (c *Context) ModuleName(logicModule Module) string {
-	module := c.moduleInfo[logicModule]
-	return module.Name()
-}
+	var actions []BuildAction
+	for _, buildDef := range module.actionDefs.buildDefs {
+		action := BuildAction{
+			Rule: buildDef.Rule.fullName(c.pkgNames),
+		}
 
-func (c *Context) ModulePath(logicModule Module) string {
-	module := c.moduleInfo[logicModule]
-	return module.relBlueprintsFile
-}
+		var err error
+		action.Inputs, err = evalList(buildDef.Inputs, buildDef.Implicits)
+		if err != nil {
+			return nil, err
+		}
+		action.Outputs, err = evalList(buildDef.Outputs, buildDef.ImplicitOutputs)
+		if err != nil {
+			return nil, err
+		}
+		if description, ok := buildDef.Variables["description"]; ok {
+			action.Description, err = description.evalLayers(variables)
+			if err != nil {
+				return nil, err
+			}
+		}
 
-func (c *Context) ModuleDir(logicModule Module) string {
-	return filepath.Dir(c.ModulePath(logicModule))
-}
+		actions = append(actions, action)
+	}
 
-func (c *Context) ModuleSubDir(logicModule Module) string {
-	module := c.moduleInfo[logicModule]
-	return module.variantName
+	return actions, nil
 }
 
-func (c *Context) ModuleType(logicModule Module) string {
-	module := c.moduleInfo[logicModule]
-	return module.typeName
+// moduleActionVariables returns the values of the variables defined by a module
+// layered over the values of the global variables, for evaluating the module's
+// build statements.
+func (c *Context) moduleActionVariables(module *moduleInfo) variableLayers {
+	locals := make(map[Variable]*ninjaString, len(module.actionDefs.variables))
+	for _, v := range module.actionDefs.variables {
+		locals[v] = v.value_
+	}
+	return variableLayers{locals, c.globalVariables}
 }
 
-func (c *Context) BlueprintFile(logicModule Module) string {
-	module := c.moduleInfo[logicModule]
-	return module.relBlueprintsFile
-}
+// WriteModuleOutputManifest writes a JSON object to w that maps each module
+// variant that has build statements, keyed by "name:variant", to the list of
+// explicit outputs of those statements.  The name is the unique name given to
+// the module by the NameInterface, so modules with the same name in different
+// namespaces don't collide.  Implicit outputs are not included.
+// It is intended for tools that need to map Ninja outputs back to the modules
+// that produce them.  If this is called before PrepareBuildActions
+// successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) WriteModuleOutputManifest(w io.Writer) error {
+	if !c.buildActionsReady {
+		return ErrBuildActionsNotReady
+	}
 
-func (c *Context) ModuleErrorf(logicModule Module, format string,
-	args ...interface{}) error {
+	manifest := make(map[string][]string)
+	for _, module := range c.modulesSorted {
+		if len(module.actionDefs.buildDefs) == 0 {
+			continue
+		}
 
-	module := c.moduleInfo[logicModule]
-	return &BlueprintError{
-		Err: fmt.Errorf(format, args...),
-		Pos: module.pos,
+		variables := c.moduleActionVariables(module)
+		var outputs []string
+		for _, buildDef := range module.actionDefs.buildDefs {
+			for _, output := range buildDef.Outputs {
+				value, err := output.evalLayers(variables)
+				if err != nil {
+					return fmt.Errorf("module %s: %s", module, err)
+				}
+				outputs = append(outputs, value)
+			}
+		}
+
+		name := c.nameInterface.UniqueName(newNamespaceContext(module), module.group.name)
+		manifest[name+":"+module.variantName] = outputs
 	}
+
+	return json.NewEncoder(w).Encode(manifest)
 }
 
-func (c *Context) VisitAllModules(visit func(Module)) {
-	c.visitAllModules(visit)
+// A CompileCommand is an entry of a compilation database, as written by
+// WriteCompileCommands.
+type CompileCommand struct {
+	Directory string `json:"directory"`
+	Command   string `json:"command"`
+	File      string `json:"file"`
 }
 
-func (c *Context) VisitAllModulesIf(pred func(Module) bool,
-	visit func(Module)) {
+// WriteCompileCommands writes a compilation database in the JSON format used
+// by compile_commands.json to w, containing the command of each build
+// statement of a module whose rule, given by its full Ninja name, is accepted
+// by ruleFilter.  The file of each command is the first explicit input of the
+// build statement, statements without inputs are skipped, and the directory is
+// the current working directory, which is assumed to be the directory Ninja
+// runs in.  If the rule uses a response file, references to it in the form
+// "@file" are replaced with its contents.  If this is called before
+// PrepareBuildActions successfully completes then ErrBuildActionsNotReady is
+// returned.
+func (c *Context) WriteCompileCommands(w io.Writer, ruleFilter func(rule string) bool) error {
+	if !c.buildActionsReady {
+		return ErrBuildActionsNotReady
+	}
 
-	c.visitAllModulesIf(pred, visit)
-}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
 
-func (c *Context) VisitDirectDeps(module Module, visit func(Module)) {
-	topModule := c.moduleInfo[module]
+	commands := []CompileCommand{}
+	for _, module := range c.modulesSorted {
+		variables := c.moduleActionVariables(module)
+		for _, buildDef := range module.actionDefs.buildDefs {
+			if buildDef.RuleDef == nil || len(buildDef.Inputs) == 0 ||
+				!ruleFilter(buildDef.Rule.fullName(c.pkgNames)) {
+				continue
+			}
 
-	var visiting *moduleInfo
+			command, err := c.buildDefCommand(buildDef, variables)
+			if err != nil {
+				return fmt.Errorf("module %s: %s", module, err)
+			}
+			file, err := buildDef.Inputs[0].evalLayers(variables)
+			if err != nil {
+				return fmt.Errorf("module %s: %s", module, err)
+			}
 
-	defer func() {
-		if r := recover(); r != nil {
-			panic(newPanicErrorf(r, "VisitDirectDeps(%s, %s) for dependency %s",
-				topModule, funcName(visit), visiting))
+			commands = append(commands, CompileCommand{
+				Directory: dir,
+				Command:   command,
+				File:      file,
+			})
 		}
-	}()
-
-	for _, dep := range topModule.directDeps {
-		visiting = dep.module
-		visit(dep.module.logicModule)
 	}
-}
 
-func (c *Context) VisitDirectDepsIf(module Module, pred func(Module) bool, visit func(Module)) {
-	topModule := c.moduleInfo[module]
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(commands)
+}
 
-	var visiting *moduleInfo
+// buildDefCommand returns the command of the rule of a build statement with
+// the arguments of the statement and the $in and $out variables substituted,
+// and with the contents of the response file of the rule, if any, substituted
+// for references to it.
+func (c *Context) buildDefCommand(buildDef *buildDef,
+	variables variableLayers) (string, error) {
 
-	defer func() {
-		if r := recover(); r != nil {
-			panic(newPanicErrorf(r, "VisitDirectDepsIf(%s, %s, %s) for dependency %s",
-				topModule, funcName(pred), funcName(visit), visiting))
+	evalList := func(list []*ninjaString) (string, error) {
+		values := make([]string, len(list))
+		for i, s := range list {
+			value, err := s.evalLayers(variables)
+			if err != nil {
+				return "", err
+			}
+			values[i] = value
 		}
-	}()
+		return strings.Join(values, " "), nil
+	}
 
-	for _, dep := range topModule.directDeps {
-		visiting = dep.module
-		if pred(dep.module.logicModule) {
-			visit(dep.module.logicModule)
+	in, err := evalList(buildDef.Inputs)
+	if err != nil {
+		return "", err
+	}
+	out, err := evalList(buildDef.Outputs)
+	if err != nil {
+		return "", err
+	}
+
+	// The arguments of the rule only exist in the scope of the rule, so add
+	// them in a layer over the variables.
+	args := make(map[Variable]*ninjaString)
+	for _, value := range buildDef.RuleDef.Variables {
+		for _, v := range value.Variables() {
+			if _, ok := v.(*argVariable); !ok {
+				continue
+			}
+			switch {
+			case v.name() == "in":
+				args[v] = simpleNinjaString(in)
+			case v.name() == "out":
+				args[v] = simpleNinjaString(out)
+			case buildDef.Args[v] != nil:
+				args[v] = buildDef.Args[v]
+			default:
+				// Ninja expands arguments that are not set to empty strings.
+				args[v] = simpleNinjaString("")
+			}
 		}
 	}
-}
+	ruleVariables := append(variableLayers{args}, variables...)
 
-func (c *Context) VisitDepsDepthFirst(module Module, visit func(Module)) {
-	topModule := c.moduleInfo[module]
+	command, err := buildDef.RuleDef.Variables["command"].evalLayers(ruleVariables)
+	if err != nil {
+		return "", err
+	}
+
+	if rspfile, ok := buildDef.RuleDef.Variables["rspfile"]; ok {
+		file, err := rspfile.evalLayers(ruleVariables)
+		if err != nil {
+			return "", err
+		}
+		content, err := buildDef.RuleDef.Variables["rspfile_content"].evalLayers(ruleVariables)
+		if err != nil {
+			return "", err
+		}
+		command = strings.Replace(command, "@"+file, content, -1)
+	}
+
+	return command, nil
+}
+
+// FindDuplicateOutputs returns the output paths, explicit or implicit, that are
+// produced by the build statements of more than one module variant, mapped to
+// the modules that produce them in the order they were generated.  Outputs of
+// singletons are not considered.  If this is called before PrepareBuildActions
+// successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) FindDuplicateOutputs() (map[string][]Module, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+
+	producers := make(map[string][]*moduleInfo)
+	for _, module := range c.modulesSorted {
+		if len(module.actionDefs.buildDefs) == 0 {
+			continue
+		}
+
+		variables := c.moduleActionVariables(module)
+		for _, buildDef := range module.actionDefs.buildDefs {
+			for _, list := range [][]*ninjaString{buildDef.Outputs, buildDef.ImplicitOutputs} {
+				for _, output := range list {
+					value, err := output.evalLayers(variables)
+					if err != nil {
+						return nil, err
+					}
+
+					modules := producers[value]
+					if len(modules) == 0 || modules[len(modules)-1] != module {
+						producers[value] = append(modules, module)
+					}
+				}
+			}
+		}
+	}
+
+	duplicates := make(map[string][]Module)
+	for output, modules := range producers {
+		if len(modules) > 1 {
+			for _, module := range modules {
+				duplicates[output] = append(duplicates[output], module.logicModule)
+			}
+		}
+	}
+
+	return duplicates, nil
+}
+
+// RuleDefiningPackage returns the path of the Go package whose PackageContext defined the rule,
+// for example "github.com/google/blueprint/bootstrap", or the empty string for built-in rules like
+// Phony and for rules defined by modules and singletons.  It panics if it is called before
+// PrepareBuildActions successfully completes.
+func (c *Context) RuleDefiningPackage(rule Rule) string {
+	if !c.buildActionsReady {
+		panic(fmt.Errorf("RuleDefiningPackage called before PrepareBuildActions"))
+	}
+	return definingPackage(rule.packageContext())
+}
+
+// PoolDefiningPackage is like RuleDefiningPackage, but for pools.
+func (c *Context) PoolDefiningPackage(pool Pool) string {
+	if !c.buildActionsReady {
+		panic(fmt.Errorf("PoolDefiningPackage called before PrepareBuildActions"))
+	}
+	return definingPackage(pool.packageContext())
+}
+
+// VariableDefiningPackage is like RuleDefiningPackage, but for variables.  It returns the empty
+// string for the arguments of rules.
+func (c *Context) VariableDefiningPackage(variable Variable) string {
+	if !c.buildActionsReady {
+		panic(fmt.Errorf("VariableDefiningPackage called before PrepareBuildActions"))
+	}
+	if _, ok := variable.(*argVariable); ok {
+		return ""
+	}
+	return definingPackage(variable.packageContext())
+}
+
+func definingPackage(pctx *packageContext) string {
+	if pctx == nil {
+		return ""
+	}
+	return pctx.pkgPath
+}
+
+func (c *Context) NinjaBuildDir() (string, error) {
+	if c.ninjaBuildDir != nil {
+		return c.ninjaBuildDir.Eval(c.globalVariables)
+	} else {
+		return "", nil
+	}
+}
+
+// ModuleTypePropertyStructs returns a mapping from module type name to a list of pointers to
+// property structs returned by the factory for that module type.
+func (c *Context) ModuleTypePropertyStructs() map[string][]interface{} {
+	ret := make(map[string][]interface{})
+	for moduleType, factory := range c.moduleFactories {
+		_, ret[moduleType] = factory()
+	}
+
+	return ret
+}
+
+func (c *Context) ModuleTypeFactories() map[string]ModuleFactory {
+	ret := make(map[string]ModuleFactory)
+	for k, v := range c.moduleFactories {
+		ret[k] = v
+	}
+	return ret
+}
+
+// UsedModuleTypes returns the number of modules of each module type that has
+// been instantiated by parsing Blueprints files or by mutators calling
+// CreateModule.  Variants of a module are counted once.  Module types that are
+// registered but not used are not included.  Modules created by mutators are
+// counted under the type name passed to CreateModuleWithType, or under "" if
+// they were created with CreateModule.
+func (c *Context) UsedModuleTypes() map[string]int {
+	ret := make(map[string]int)
+	for _, group := range c.moduleGroups {
+		ret[group.modules[0].typeName]++
+	}
+	return ret
+}
+
+func (c *Context) ModuleName(logicModule Module) string {
+	module := c.moduleInfo[logicModule]
+	return module.Name()
+}
+
+func (c *Context) ModulePath(logicModule Module) string {
+	module := c.moduleInfo[logicModule]
+	return module.relBlueprintsFile
+}
+
+func (c *Context) ModuleDir(logicModule Module) string {
+	return filepath.Dir(c.ModulePath(logicModule))
+}
+
+// ModuleDirMismatches calls check with each module and the directory of the
+// Blueprints file that defines it, and returns an error positioned at the
+// module definition, sorted by position, for each module for which check
+// returns false.  It can be called any time after ParseBlueprintsFiles, and
+// calls check once for each module definition, with the first variant of the
+// module if it has been split by mutators.
+func (c *Context) ModuleDirMismatches(check func(module Module, dir string) (ok bool, reason string)) []error {
+	var errs []error
+	for _, group := range c.moduleGroups {
+		module := group.modules[0]
+		dir := filepath.Dir(module.relBlueprintsFile)
+		if ok, reason := check(module.logicModule, dir); !ok {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("module %q in %q: %s", module.Name(), dir, reason),
+				Pos: module.pos,
+			})
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errorLess(errs[i], errs[j])
+	})
+	return errs
+}
+
+// ModuleNamespaceName returns the name of the namespace that the
+// NameInterface placed the given module in, or the empty string if the module
+// is in the default namespace, its namespace does not implement NamedNamespace,
+// or it is not in the Context.
+func (c *Context) ModuleNamespaceName(logicModule Module) string {
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		return ""
+	}
+	if namespace, ok := module.namespace().(NamedNamespace); ok {
+		return namespace.NamespaceName()
+	}
+	return ""
+}
+
+func (c *Context) ModuleSubDir(logicModule Module) string {
+	module := c.moduleInfo[logicModule]
+	return module.variantName
+}
+
+func (c *Context) ModuleType(logicModule Module) string {
+	module := c.moduleInfo[logicModule]
+	return module.typeName
+}
+
+func (c *Context) BlueprintFile(logicModule Module) string {
+	module := c.moduleInfo[logicModule]
+	return module.relBlueprintsFile
+}
+
+// BlueprintFiles returns the sorted paths of the Blueprints files that have
+// been parsed, including those listed in build variables and those that do not
+// define any modules, in the form in which they are returned as dependencies by
+// ParseBlueprintsFiles.  Files that were deleted before a call to
+// ReparseChangedFiles are removed from it.
+func (c *Context) BlueprintFiles() []string {
+	files := make([]string, 0, len(c.blueprintFiles))
+	for file := range c.blueprintFiles {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// A FileScopeVariable is a variable in the scope of a Blueprints file.
+type FileScopeVariable struct {
+	Value     parser.Expression // The evaluated value of the variable
+	Pos       scanner.Position  // The position of the name in the assignment
+	Inherited bool              // Whether the variable was assigned by an ancestor Blueprints file
+}
+
+// FileScope returns the variables in the scope of a Blueprints file after it
+// was parsed, including those inherited from the Blueprints files in ancestor
+// directories and from SetGlobalParseVariables.  blueprintFile is the path of
+// the file relative to the root directory, as returned by BlueprintFile.  It
+// returns false if the file has not been parsed.
+func (c *Context) FileScope(blueprintFile string) (map[string]FileScopeVariable, bool) {
+	c.fileScopesLock.Lock()
+	scope, ok := c.fileScopes[blueprintFile]
+	c.fileScopesLock.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	variables := make(map[string]FileScopeVariable)
+	for _, name := range scope.Names() {
+		assignment, local := scope.Get(name)
+		variables[name] = FileScopeVariable{
+			Value:     assignment.Value.Eval(),
+			Pos:       assignment.NamePos,
+			Inherited: !local,
+		}
+	}
+	return variables, true
+}
+
+func (c *Context) setFileScope(blueprintFile string, scope *parser.Scope) {
+	c.fileScopesLock.Lock()
+	defer c.fileScopesLock.Unlock()
+
+	if c.fileScopes == nil {
+		c.fileScopes = make(map[string]*parser.Scope)
+	}
+	c.fileScopes[blueprintFile] = scope
+}
+
+// HasStartedBuildActions returns true if the GenerateBuildActions method of the
+// given module has been called.  Modules generate their build actions in
+// parallel, so the result for a module other than the caller's own may race
+// with its generation unless the caller is ordered after it, for example by
+// depending on it.  It returns false for a module that is not in the Context.
+func (c *Context) HasStartedBuildActions(logicModule Module) bool {
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		return false
+	}
+	return atomic.LoadInt32(&module.startedGenerateBuildActions) != 0
+}
+
+// HasGeneratedBuildActions returns true if the GenerateBuildActions method of
+// the given module has returned without reporting errors and its build actions
+// have been added.  It remains false for a module whose GenerateBuildActions
+// panicked or reported errors, or that is not in the Context.  The same caveats
+// about parallel generation as HasStartedBuildActions apply.
+func (c *Context) HasGeneratedBuildActions(logicModule Module) bool {
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		return false
+	}
+	return atomic.LoadInt32(&module.finishedGenerateBuildActions) != 0
+}
+
+func (c *Context) ModuleErrorf(logicModule Module, format string,
+	args ...interface{}) error {
+
+	module := c.moduleInfo[logicModule]
+	return &BlueprintError{
+		Err: fmt.Errorf(format, args...),
+		Pos: module.pos,
+	}
+}
+
+func (c *Context) VisitAllModules(visit func(Module)) {
+	c.visitAllModules(visit)
+}
+
+func (c *Context) VisitAllModulesIf(pred func(Module) bool,
+	visit func(Module)) {
+
+	c.visitAllModulesIf(pred, visit)
+}
+
+// VisitAllModulesUntil calls visit for each module in the same order as VisitAllModules until
+// visit returns true, after which no more modules are visited.
+func (c *Context) VisitAllModulesUntil(visit func(Module) bool) {
+	c.visitAllModulesUntil(visit)
+}
+
+// VisitModulesInNamespace calls visit for each module in the given namespace, in the same order
+// as VisitAllModules.  Modules in the default namespace can be visited by passing the namespace
+// the NameInterface uses for them, which is nil for the SimpleNameInterface.
+func (c *Context) VisitModulesInNamespace(namespace Namespace, visit func(Module)) {
+	var module *moduleInfo
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitModulesInNamespace(%s) for %s",
+				funcName(visit), module))
+		}
+	}()
+
+	for _, moduleGroup := range c.sortedModuleGroups() {
+		if moduleGroup.namespace != namespace {
+			continue
+		}
+		for _, module = range moduleGroup.modules {
+			visit(module.logicModule)
+		}
+	}
+}
+
+func (c *Context) VisitDirectDeps(module Module, visit func(Module)) {
+	topModule := c.moduleInfo[module]
+
+	var visiting *moduleInfo
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitDirectDeps(%s, %s) for dependency %s",
+				topModule, funcName(visit), visiting))
+		}
+	}()
+
+	for _, dep := range topModule.directDeps {
+		visiting = dep.module
+		visit(dep.module.logicModule)
+	}
+}
+
+func (c *Context) VisitDirectDepsIf(module Module, pred func(Module) bool, visit func(Module)) {
+	topModule := c.moduleInfo[module]
+
+	var visiting *moduleInfo
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitDirectDepsIf(%s, %s, %s) for dependency %s",
+				topModule, funcName(pred), funcName(visit), visiting))
+		}
+	}()
+
+	for _, dep := range topModule.directDeps {
+		visiting = dep.module
+		if pred(dep.module.logicModule) {
+			visit(dep.module.logicModule)
+		}
+	}
+}
+
+func (c *Context) VisitDepsDepthFirst(module Module, visit func(Module)) {
+	topModule := c.moduleInfo[module]
+
+	var visiting *moduleInfo
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitDepsDepthFirst(%s, %s) for dependency %s",
+				topModule, funcName(visit), visiting))
+		}
+	}()
+
+	c.walkDeps(topModule, false, nil, func(dep depInfo, parent *moduleInfo) {
+		visiting = dep.module
+		visit(dep.module.logicModule)
+	})
+}
+
+func (c *Context) VisitDepsDepthFirstIf(module Module, pred func(Module) bool, visit func(Module)) {
+	topModule := c.moduleInfo[module]
+
+	var visiting *moduleInfo
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitDepsDepthFirstIf(%s, %s, %s) for dependency %s",
+				topModule, funcName(pred), funcName(visit), visiting))
+		}
+	}()
+
+	c.walkDeps(topModule, false, nil, func(dep depInfo, parent *moduleInfo) {
+		if pred(dep.module.logicModule) {
+			visiting = dep.module
+			visit(dep.module.logicModule)
+		}
+	})
+}
+
+// MissingDependencies returns the names of the dependencies of module that could not be found
+// when missing dependencies are allowed by SetAllowMissingDependencies.  It returns an empty,
+// non-nil slice if there were none, or nil if module is not in the module graph.  It must only
+// be called after ResolveDependencies has completed successfully.
+func (c *Context) MissingDependencies(module Module) []string {
+	if !c.dependenciesReady {
+		panic(fmt.Errorf("MissingDependencies called before ResolveDependencies"))
+	}
+
+	info := c.moduleInfo[module]
+	if info == nil {
+		return nil
+	}
+	return append([]string{}, info.missingDeps...)
+}
+
+// TransitiveDeps returns every module that is reachable from module by following dependencies,
+// not including module itself.  The returned modules are deduplicated and sorted by name and
+// variant.  It returns nil if module is not in the module graph.  It must only be called after
+// ResolveDependencies has completed successfully.
+func (c *Context) TransitiveDeps(module Module) []Module {
+	return c.TransitiveDepsIf(module, func(DependencyTag) bool { return true })
+}
+
+// TransitiveDepsIf is like TransitiveDeps, but only follows dependencies whose dependency tag
+// satisfies pred.
+func (c *Context) TransitiveDepsIf(module Module, pred func(DependencyTag) bool) []Module {
+	if !c.dependenciesReady {
+		panic(fmt.Errorf("TransitiveDepsIf called before ResolveDependencies"))
+	}
+
+	topModule := c.moduleInfo[module]
+	if topModule == nil {
+		return nil
+	}
+
+	visited := map[*moduleInfo]bool{topModule: true}
+	queue := []*moduleInfo{topModule}
+	var deps []*moduleInfo
+
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+		for _, dep := range m.directDeps {
+			if visited[dep.module] || !pred(dep.tag) {
+				continue
+			}
+			visited[dep.module] = true
+			deps = append(deps, dep.module)
+			queue = append(queue, dep.module)
+		}
+	}
+
+	sort.Sort(moduleSorter{deps, c.nameInterface})
+
+	ret := make([]Module, len(deps))
+	for i, dep := range deps {
+		ret[i] = dep.logicModule
+	}
+	return ret
+}
+
+// DependencyTagTypes returns the distinct types of the dependency tags of all dependencies in the
+// build graph, sorted.  Named types are qualified with the full import path of their package, for
+// example "github.com/google/blueprint.BaseDependencyTag", and nil tags are reported as "<nil>".
+// It must only be called after ResolveDependencies has completed successfully.
+func (c *Context) DependencyTagTypes() []string {
+	if !c.dependenciesReady {
+		panic(fmt.Errorf("DependencyTagTypes called before ResolveDependencies"))
+	}
+
+	seen := make(map[reflect.Type]bool)
+	var types []string
+	for _, module := range c.modulesSorted {
+		for _, dep := range module.directDeps {
+			typ := reflect.TypeOf(dep.tag)
+			if !seen[typ] {
+				seen[typ] = true
+				types = append(types, qualifiedTypeName(typ))
+			}
+		}
+	}
+
+	sort.Strings(types)
+	return types
+}
+
+// qualifiedTypeName returns the name of a type with the names of any named types qualified with
+// the full import path of their package.
+func qualifiedTypeName(typ reflect.Type) string {
+	switch {
+	case typ == nil:
+		return "<nil>"
+	case typ.Name() != "" && typ.PkgPath() != "":
+		return typ.PkgPath() + "." + typ.Name()
+	case typ.Kind() == reflect.Ptr:
+		return "*" + qualifiedTypeName(typ.Elem())
+	default:
+		return typ.String()
+	}
+}
+
+// TagModule attaches arbitrary labels to a module variant for use by tools, for example to mark
+// the modules that are part of an exported API surface.  The tags are unrelated to dependency
+// tags and variations, and are not copied to new variants if the module is later split by a
+// mutator.  TagModule may be called concurrently, for example from a mutator.
+func (c *Context) TagModule(module Module, tags ...string) {
+	info := c.moduleInfo[module]
+	if info == nil {
+		panic(fmt.Errorf("TagModule called with unknown module %v", module))
+	}
+
+	c.moduleTagsLock.Lock()
+	defer c.moduleTagsLock.Unlock()
+
+	if c.moduleTags == nil {
+		c.moduleTags = make(map[*moduleInfo]map[string]bool)
+	}
+	if c.moduleTags[info] == nil {
+		c.moduleTags[info] = make(map[string]bool)
+	}
+
+	for _, tag := range tags {
+		c.moduleTags[info][tag] = true
+	}
+}
+
+// ModulesWithTag returns the module variants that were tagged with tag by TagModule, sorted by
+// name and variant.
+func (c *Context) ModulesWithTag(tag string) []Module {
+	c.moduleTagsLock.Lock()
+	defer c.moduleTagsLock.Unlock()
+
+	var modules []*moduleInfo
+	for module, tags := range c.moduleTags {
+		// Skip modules that have been replaced by their variants.
+		if tags[tag] && c.moduleInfo[module.logicModule] == module {
+			modules = append(modules, module)
+		}
+	}
+
+	sort.Sort(moduleSorter{modules, c.nameInterface})
+
+	ret := make([]Module, len(modules))
+	for i, module := range modules {
+		ret[i] = module.logicModule
+	}
+	return ret
+}
+
+// RunLintPasses runs the lint passes registered with RegisterLintPass and
+// returns the findings they reported, sorted by position.  It must only be
+// called after PrepareBuildActions has completed successfully, otherwise it
+// returns ErrBuildActionsNotReady.  A lint pass that panics is reported as an
+// error, and does not prevent the remaining passes from running.
+func (c *Context) RunLintPasses(config interface{}) (findings []*LintFinding, errs []error) {
+	if !c.buildActionsReady {
+		return nil, []error{ErrBuildActionsNotReady}
+	}
+
+	for _, info := range c.lintPassInfo {
+		lctx := &lintContext{
+			name:    info.name,
+			context: c,
+			config:  config,
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					errs = append(errs, newPanicErrorf(r, "lint pass %s", info.name))
+				}
+			}()
+			info.pass(lctx)
+		}()
+
+		findings = append(findings, lctx.findings...)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return errorLess(findings[i], findings[j])
+	})
+
+	return findings, errs
+}
+
+// DependencyPath returns the shortest chain of modules that leads from one module to another by
+// following dependencies, starting with from and ending with to, and true, or nil and false if to
+// is not reachable from from or if either module is not in the module graph.  It must only be
+// called after ResolveDependencies has completed successfully.
+func (c *Context) DependencyPath(from, to Module) ([]Module, bool) {
+	path, _, found := c.DependencyPathWithTags(from, to)
+	return path, found
+}
+
+// DependencyPathWithTags is like DependencyPath, but also returns the dependency tags of the
+// dependencies along the path.  The tag at index i is the tag of the dependency from the module at
+// index i to the module at index i+1.
+func (c *Context) DependencyPathWithTags(from, to Module) ([]Module, []DependencyTag, bool) {
+	if !c.dependenciesReady {
+		panic(fmt.Errorf("DependencyPathWithTags called before ResolveDependencies"))
+	}
+
+	fromModule := c.moduleInfo[from]
+	toModule := c.moduleInfo[to]
+	if fromModule == nil || toModule == nil {
+		return nil, nil, false
+	}
 
-	var visiting *moduleInfo
+	// Breadth first search, recording the dependency that first reached each module so that the
+	// path can be reconstructed backwards from the destination.
+	type step struct {
+		parent *moduleInfo
+		tag    DependencyTag
+	}
+	reachedBy := map[*moduleInfo]step{fromModule: {}}
+	queue := []*moduleInfo{fromModule}
 
-	defer func() {
-		if r := recover(); r != nil {
-			panic(newPanicErrorf(r, "VisitDepsDepthFirst(%s, %s) for dependency %s",
-				topModule, funcName(visit), visiting))
+	for len(queue) > 0 && toModule != fromModule {
+		m := queue[0]
+		queue = queue[1:]
+		if _, found := reachedBy[toModule]; found {
+			break
 		}
-	}()
+		for _, dep := range m.directDeps {
+			if _, visited := reachedBy[dep.module]; visited {
+				continue
+			}
+			reachedBy[dep.module] = step{m, dep.tag}
+			queue = append(queue, dep.module)
+		}
+	}
 
-	c.walkDeps(topModule, false, nil, func(dep depInfo, parent *moduleInfo) {
-		visiting = dep.module
-		visit(dep.module.logicModule)
-	})
+	if _, found := reachedBy[toModule]; !found {
+		return nil, nil, false
+	}
+
+	var path []Module
+	var tags []DependencyTag
+	for m := toModule; m != fromModule; m = reachedBy[m].parent {
+		path = append(path, m.logicModule)
+		tags = append(tags, reachedBy[m].tag)
+	}
+	path = append(path, fromModule.logicModule)
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	for i, j := 0, len(tags)-1; i < j; i, j = i+1, j-1 {
+		tags[i], tags[j] = tags[j], tags[i]
+	}
+
+	return path, tags, true
 }
 
-func (c *Context) VisitDepsDepthFirstIf(module Module, pred func(Module) bool, visit func(Module)) {
-	topModule := c.moduleInfo[module]
+// ExplainVariant describes the variations of a module variant, one for each mutator that split
+// the module into variants, in the order in which the mutators were registered.  Each entry reads
+// like "arch=arm64 (created by arch)", as variations are named after the mutator that created them,
+// and variations created by CreateLocalVariations, which dependencies are not required to match,
+// are marked as local.  It must only be called after ResolveDependencies has completed
+// successfully.
+func (c *Context) ExplainVariant(module Module) []string {
+	if !c.dependenciesReady {
+		panic(fmt.Errorf("ExplainVariant called before ResolveDependencies"))
+	}
 
-	var visiting *moduleInfo
+	info := c.moduleInfo[module]
 
-	defer func() {
-		if r := recover(); r != nil {
-			panic(newPanicErrorf(r, "VisitDepsDepthFirstIf(%s, %s, %s) for dependency %s",
-				topModule, funcName(pred), funcName(visit), visiting))
+	var explanation []string
+	for _, mutator := range c.variantMutatorNames {
+		variation, ok := info.variant[mutator]
+		if !ok {
+			continue
 		}
-	}()
+		if _, ok := info.dependencyVariant[mutator]; ok {
+			explanation = append(explanation,
+				fmt.Sprintf("%s=%s (created by %s)", mutator, variation, mutator))
+		} else {
+			explanation = append(explanation,
+				fmt.Sprintf("%s=%s (created by %s as a local variation)", mutator, variation, mutator))
+		}
+	}
 
-	c.walkDeps(topModule, false, nil, func(dep depInfo, parent *moduleInfo) {
-		if pred(dep.module.logicModule) {
-			visiting = dep.module
-			visit(dep.module.logicModule)
+	return explanation
+}
+
+// RootModules returns the module variants that no other module variant depends on, sorted by
+// name and variant, for example to find modules that may be unused.  Only dependencies added by
+// mutators are considered, not the implicit ordering of the variants of a module after the
+// earlier variants.  It must only be called after ResolveDependencies has completed successfully.
+func (c *Context) RootModules() []Module {
+	if !c.dependenciesReady {
+		panic(fmt.Errorf("RootModules called before ResolveDependencies"))
+	}
+
+	dependedOn := make(map[*moduleInfo]bool)
+	for _, module := range c.modulesSorted {
+		for _, dep := range module.directDeps {
+			dependedOn[dep.module] = true
 		}
-	})
+	}
+
+	var roots []*moduleInfo
+	for _, module := range c.modulesSorted {
+		if !dependedOn[module] {
+			roots = append(roots, module)
+		}
+	}
+	sort.Sort(moduleSorter{roots, c.nameInterface})
+
+	ret := make([]Module, len(roots))
+	for i, module := range roots {
+		ret[i] = module.logicModule
+	}
+	return ret
+}
+
+// LongestDependencyChain returns the longest chain of modules in the build graph in which each
+// module depends directly on the next, measured by the number of dependencies, starting with a
+// module that nothing depends on.  Long chains limit how much of the build can run in parallel.
+// When there are several chains of the same length the one starting with the first module sorted
+// by name and variant is returned, following dependencies in the order in which they were added.
+// It must only be called after ResolveDependencies has completed successfully.
+func (c *Context) LongestDependencyChain() []Module {
+	if !c.dependenciesReady {
+		panic(fmt.Errorf("LongestDependencyChain called before ResolveDependencies"))
+	}
+
+	depths := c.dependencyDepths()
+
+	var starts []*moduleInfo
+	maxDepth := -1
+	for _, module := range c.modulesSorted {
+		if depth := depths[module]; depth > maxDepth {
+			maxDepth = depth
+			starts = []*moduleInfo{module}
+		} else if depth == maxDepth {
+			starts = append(starts, module)
+		}
+	}
+	if len(starts) == 0 {
+		return nil
+	}
+	sort.Sort(moduleSorter{starts, c.nameInterface})
+
+	var chain []Module
+	for module := starts[0]; module != nil; {
+		chain = append(chain, module.logicModule)
+		var next *moduleInfo
+		for _, dep := range module.directDeps {
+			if depths[dep.module] == depths[module]-1 {
+				next = dep.module
+				break
+			}
+		}
+		module = next
+	}
+
+	return chain
+}
+
+// DependencyDepthHistogram returns the number of modules in the build graph for each dependency
+// depth, which is the number of dependencies in the longest chain of dependencies starting with
+// the module, so modules without dependencies have depth 0.  It must only be called after
+// ResolveDependencies has completed successfully.
+func (c *Context) DependencyDepthHistogram() map[int]int {
+	if !c.dependenciesReady {
+		panic(fmt.Errorf("DependencyDepthHistogram called before ResolveDependencies"))
+	}
+
+	histogram := make(map[int]int)
+	for _, depth := range c.dependencyDepths() {
+		histogram[depth]++
+	}
+	return histogram
+}
+
+// dependencyDepths returns the length of the longest chain of dependencies starting with each
+// module.  modulesSorted lists dependencies before the modules that depend on them, so each depth
+// can be computed from the already computed depths of the module's dependencies.
+func (c *Context) dependencyDepths() map[*moduleInfo]int {
+	depths := make(map[*moduleInfo]int, len(c.modulesSorted))
+	for _, module := range c.modulesSorted {
+		depth := 0
+		for _, dep := range module.directDeps {
+			if depths[dep.module]+1 > depth {
+				depth = depths[dep.module] + 1
+			}
+		}
+		depths[module] = depth
+	}
+	return depths
 }
 
 func (c *Context) PrimaryModule(module Module) Module {
@@ -3008,6 +5507,13 @@ func (c *Context) WriteBuildFile(w io.Writer) error {
 			return
 		}
 
+		if c.checkNinjaReferences {
+			err = c.validateNinjaReferences()
+			if err != nil {
+				return
+			}
+		}
+
 		nw := newNinjaWriter(w)
 
 		err = c.writeBuildFileHeader(nw)
@@ -3056,6 +5562,16 @@ func (c *Context) WriteBuildFile(w io.Writer) error {
 		if err != nil {
 			return
 		}
+
+		err = c.writeNinjaDefaultTargets(nw)
+		if err != nil {
+			return
+		}
+
+		err = c.writeBuildFileFooter(nw)
+		if err != nil {
+			return
+		}
 	})
 
 	if err != nil {
@@ -3065,6 +5581,134 @@ func (c *Context) WriteBuildFile(w io.Writer) error {
 	return nil
 }
 
+// validateNinjaReferences returns an error for the first rule, build statement
+// or global variable that references a variable that will not be defined in
+// the Ninja file.
+func (c *Context) validateNinjaReferences() error {
+	// checkStrings returns the name of the first undefined variable referenced
+	// by strs, or the empty string if they are all defined.
+	checkStrings := func(locals []*localVariable, strs ...*ninjaString) string {
+		for _, str := range strs {
+		varLoop:
+			for _, v := range str.Variables() {
+				if _, ok := v.(*argVariable); ok {
+					continue
+				}
+				if _, ok := c.globalVariables[v]; ok {
+					continue
+				}
+				for _, local := range locals {
+					if v == Variable(local) {
+						continue varLoop
+					}
+				}
+				return v.fullName(c.pkgNames)
+			}
+		}
+		return ""
+	}
+
+	ruleStrings := func(def *ruleDef) []*ninjaString {
+		strs := append([]*ninjaString(nil), def.CommandDeps...)
+		strs = append(strs, def.CommandOrderOnly...)
+		for _, value := range def.Variables {
+			strs = append(strs, value)
+		}
+		return strs
+	}
+
+	buildStrings := func(def *buildDef) []*ninjaString {
+		strs := append([]*ninjaString(nil), def.Outputs...)
+		strs = append(strs, def.ImplicitOutputs...)
+		strs = append(strs, def.Inputs...)
+		strs = append(strs, def.Implicits...)
+		strs = append(strs, def.OrderOnly...)
+		strs = append(strs, def.Validations...)
+		for _, value := range def.Args {
+			strs = append(strs, value)
+		}
+		for _, value := range def.Variables {
+			strs = append(strs, value)
+		}
+		return strs
+	}
+
+	checkActions := func(actionDefs *localBuildActions) error {
+		for _, r := range actionDefs.rules {
+			if missing := checkStrings(actionDefs.variables, ruleStrings(r.def_)...); missing != "" {
+				return fmt.Errorf("rule %s references undefined variable %s",
+					r.fullName(c.pkgNames), missing)
+			}
+		}
+		for _, def := range actionDefs.buildDefs {
+			if missing := checkStrings(actionDefs.variables, buildStrings(def)...); missing != "" {
+				outputs := def.Outputs
+				if len(outputs) == 0 {
+					outputs = def.ImplicitOutputs
+				}
+				return fmt.Errorf("build %s references undefined variable %s",
+					outputs[0].Value(c.pkgNames), missing)
+			}
+		}
+		return nil
+	}
+
+	globalVariables := make([]globalEntity, 0, len(c.globalVariables))
+	for v := range c.globalVariables {
+		globalVariables = append(globalVariables, v)
+	}
+	sort.Sort(&globalEntitySorter{c.pkgNames, globalVariables})
+	for _, entity := range globalVariables {
+		v := entity.(Variable)
+		if missing := checkStrings(nil, c.globalVariables[v]); missing != "" {
+			return fmt.Errorf("variable %s references undefined variable %s",
+				v.fullName(c.pkgNames), missing)
+		}
+	}
+
+	globalRules := make([]globalEntity, 0, len(c.globalRules))
+	for r := range c.globalRules {
+		globalRules = append(globalRules, r)
+	}
+	sort.Sort(&globalEntitySorter{c.pkgNames, globalRules})
+	for _, entity := range globalRules {
+		r := entity.(Rule)
+		if missing := checkStrings(nil, ruleStrings(c.globalRules[r])...); missing != "" {
+			return fmt.Errorf("rule %s references undefined variable %s",
+				r.fullName(c.pkgNames), missing)
+		}
+	}
+
+	modules := make([]*moduleInfo, 0, len(c.moduleInfo))
+	for _, module := range c.moduleInfo {
+		modules = append(modules, module)
+	}
+	sort.Sort(moduleSorter{modules, c.nameInterface})
+
+	for _, module := range modules {
+		if err := checkActions(&module.actionDefs); err != nil {
+			return &BlueprintError{
+				Err: fmt.Errorf("%s: %s", module, err),
+				Pos: module.pos,
+			}
+		}
+	}
+
+	for _, info := range c.preSingletonInfo {
+		if err := checkActions(&info.actionDefs); err != nil {
+			return fmt.Errorf("pre-singleton %s: %s", info.name, err)
+		}
+	}
+
+	for _, info := range c.singletonInfo {
+		if err := checkActions(&info.actionDefs); err != nil {
+			return fmt.Errorf("singleton %s: %s", info.name, err)
+		}
+	}
+
+	return nil
+}
+
 type pkgAssociation struct {
 	PkgName string
 	PkgPath string
@@ -3124,7 +5768,37 @@ func (c *Context) writeBuildFileHeader(nw *ninjaWriter) error {
 		return err
 	}
 
-	return nw.Comment(buf.String())
+	err = nw.Comment(buf.String())
+	if err != nil {
+		return err
+	}
+
+	if c.buildFileHeader != "" {
+		err = nw.Comment(c.buildFileHeader)
+		if err != nil {
+			return err
+		}
+
+		err = nw.BlankLine()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Context) writeBuildFileFooter(nw *ninjaWriter) error {
+	if c.buildFileFooter == "" {
+		return nil
+	}
+
+	err := nw.BlankLine()
+	if err != nil {
+		return err
+	}
+
+	return nw.Comment(c.buildFileFooter)
 }
 
 func (c *Context) writeNinjaRequiredVersion(nw *ninjaWriter) error {
@@ -3151,7 +5825,7 @@ func (c *Context) writeSubninjas(nw *ninjaWriter) error {
 
 func (c *Context) writeBuildDir(nw *ninjaWriter) error {
 	if c.ninjaBuildDir != nil {
-		err := nw.Assign("builddir", c.ninjaBuildDir.Value(c.pkgNames))
+		err := nw.Assign(c.outDirVariableName, c.ninjaBuildDir.Value(c.pkgNames))
 		if err != nil {
 			return err
 		}
@@ -3360,38 +6034,40 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter) error {
 			continue
 		}
 
-		buf.Reset()
+		if c.emitModuleComments {
+			buf.Reset()
 
-		// In order to make the bootstrap build manifest independent of the
-		// build dir we need to output the Blueprints file locations in the
-		// comments as paths relative to the source directory.
-		relPos := module.pos
-		relPos.Filename = module.relBlueprintsFile
+			// In order to make the bootstrap build manifest independent of the
+			// build dir we need to output the Blueprints file locations in the
+			// comments as paths relative to the source directory.
+			relPos := module.pos
+			relPos.Filename = module.relBlueprintsFile
 
-		// Get the name and location of the factory function for the module.
-		factoryFunc := runtime.FuncForPC(reflect.ValueOf(module.factory).Pointer())
-		factoryName := factoryFunc.Name()
+			// Get the name and location of the factory function for the module.
+			factoryFunc := runtime.FuncForPC(reflect.ValueOf(module.factory).Pointer())
+			factoryName := factoryFunc.Name()
 
-		infoMap := map[string]interface{}{
-			"name":      module.Name(),
-			"typeName":  module.typeName,
-			"goFactory": factoryName,
-			"pos":       relPos,
-			"variant":   module.variantName,
-		}
-		err = headerTemplate.Execute(buf, infoMap)
-		if err != nil {
-			return err
-		}
+			infoMap := map[string]interface{}{
+				"name":      module.Name(),
+				"typeName":  module.typeName,
+				"goFactory": factoryName,
+				"pos":       relPos,
+				"variant":   module.variantName,
+			}
+			err = headerTemplate.Execute(buf, infoMap)
+			if err != nil {
+				return err
+			}
 
-		err = nw.Comment(buf.String())
-		if err != nil {
-			return err
-		}
+			err = nw.Comment(buf.String())
+			if err != nil {
+				return err
+			}
 
-		err = nw.BlankLine()
-		if err != nil {
-			return err
+			err = nw.BlankLine()
+			if err != nil {
+				return err
+			}
 		}
 
 		err = c.writeLocalBuildActions(nw, &module.actionDefs)
@@ -3408,6 +6084,39 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter) error {
 	return nil
 }
 
+func (c *Context) writeNinjaDefaultTargets(nw *ninjaWriter) error {
+	targetSet := make(map[string]bool)
+	for _, target := range c.ninjaDefaultTargets {
+		targetSet[outputEscaper.Replace(target)] = true
+	}
+
+	for _, group := range c.moduleGroups {
+		for _, module := range group.modules {
+			if module.defaultTarget {
+				primaryOutput := module.actionDefs.buildDefs[0].Outputs[0]
+				targetSet[primaryOutput.ValueWithEscaper(c.pkgNames, outputEscaper)] = true
+			}
+		}
+	}
+
+	if len(targetSet) == 0 {
+		return nil
+	}
+
+	targets := make([]string, 0, len(targetSet))
+	for target := range targetSet {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	err := nw.Default(targets...)
+	if err != nil {
+		return err
+	}
+
+	return nw.BlankLine()
+}
+
 func (c *Context) writeAllSingletonActions(nw *ninjaWriter) error {
 	headerTemplate := template.New("singletonHeader")
 	_, err := headerTemplate.Parse(singletonHeaderTemplate)
@@ -3423,29 +6132,31 @@ func (c *Context) writeAllSingletonActions(nw *ninjaWriter) error {
 			continue
 		}
 
-		// Get the name of the factory function for the module.
-		factory := info.factory
-		factoryFunc := runtime.FuncForPC(reflect.ValueOf(factory).Pointer())
-		factoryName := factoryFunc.Name()
+		if c.emitModuleComments {
+			// Get the name of the factory function for the module.
+			factory := info.factory
+			factoryFunc := runtime.FuncForPC(reflect.ValueOf(factory).Pointer())
+			factoryName := factoryFunc.Name()
 
-		buf.Reset()
-		infoMap := map[string]interface{}{
-			"name":      info.name,
-			"goFactory": factoryName,
-		}
-		err = headerTemplate.Execute(buf, infoMap)
-		if err != nil {
-			return err
-		}
+			buf.Reset()
+			infoMap := map[string]interface{}{
+				"name":      info.name,
+				"goFactory": factoryName,
+			}
+			err = headerTemplate.Execute(buf, infoMap)
+			if err != nil {
+				return err
+			}
 
-		err = nw.Comment(buf.String())
-		if err != nil {
-			return err
-		}
+			err = nw.Comment(buf.String())
+			if err != nil {
+				return err
+			}
 
-		err = nw.BlankLine()
-		if err != nil {
-			return err
+			err = nw.BlankLine()
+			if err != nil {
+				return err
+			}
 		}
 
 		err = c.writeLocalBuildActions(nw, &info.actionDefs)
@@ -3487,6 +6198,25 @@ func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
 		}
 	}
 
+	// Write the local pools.
+	for _, p := range defs.pools {
+		// A localPool doesn't need the config to determine its definition.
+		def, err := p.def(nil)
+		if err != nil {
+			panic(err)
+		}
+
+		err = def.WriteTo(nw, p.fullName(nil))
+		if err != nil {
+			return err
+		}
+
+		err = nw.BlankLine()
+		if err != nil {
+			return err
+		}
+	}
+
 	// Write the local rules.
 	for _, r := range defs.rules {
 		// A localRule doesn't need the package names or config to determine
@@ -16,9 +16,14 @@ package blueprint
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -378,6 +383,98 @@ func createTestMutator(ctx TopDownMutatorContext) {
 	})
 }
 
+func TestPropertyProvenance(t *testing.T) {
+	run := func(t *testing.T, track bool) *Context {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				foo_module {
+				    name: "A",
+				    deps: ["D"],
+				}
+
+				foo_module {
+				    name: "D",
+				}
+			`),
+		})
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+		ctx.RegisterTopDownMutator("create", func(mctx TopDownMutatorContext) {
+			if mctx.ModuleName() == "A" {
+				type props struct {
+					Name string
+					Deps []string
+					Foo  string
+				}
+				d, _ := mctx.GetDirectDep("D")
+				mctx.CreateModule(newFooModule, &props{
+					Name: "B",
+					Deps: []string{"A"},
+				}, PropertiesFrom{
+					Source: d,
+					Properties: &props{
+						Deps: []string{"D"},
+						Foo:  "bar",
+					},
+				})
+			}
+		})
+		ctx.SetPropertyProvenanceTracking(track)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+		if len(errs) > 0 {
+			t.Errorf("unexpected parse errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		_, errs = ctx.ResolveDependencies(nil)
+		if len(errs) > 0 {
+			t.Errorf("unexpected dep errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		return ctx
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		ctx := run(t, true)
+		a := ctx.modulesFromName("A", nil)[0].logicModule
+		b := ctx.modulesFromName("B", nil)[0].logicModule
+		d := ctx.modulesFromName("D", nil)[0].logicModule
+
+		if got := ctx.PropertyProvenance(b, "name"); got != a {
+			t.Errorf("expected \"name\" of B to be set by A, got %v", got)
+		}
+		for _, property := range []string{"deps", "foo"} {
+			if got := ctx.PropertyProvenance(b, property); got != d {
+				t.Errorf("expected %q of B to be set by D, got %v", property, got)
+			}
+		}
+		if got := ctx.PropertyProvenance(b, "bar"); got != nil {
+			t.Errorf("expected unset \"bar\" of B to have no provenance, got %v", got)
+		}
+		if got := ctx.PropertyProvenance(a, "name"); got != nil {
+			t.Errorf("expected \"name\" of A to have no provenance, got %v", got)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		ctx := run(t, false)
+		b := ctx.modulesFromName("B", nil)[0].logicModule
+
+		if got := ctx.PropertyProvenance(b, "name"); got != nil {
+			t.Errorf("expected no provenance without tracking, got %v", got)
+		}
+	})
+}
+
 func TestWalkFileOrder(t *testing.T) {
 	// Run the test once to see how long it normally takes
 	start := time.Now()
@@ -508,3 +605,5243 @@ func TestParseFailsForModuleWithoutName(t *testing.T) {
 		t.Errorf("Incorrect errors; expected:\n%s\ngot:\n%s", expectedErrs, errs)
 	}
 }
+
+func TestParseErrorsAreSorted(t *testing.T) {
+	parse := func() []error {
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				foo_module {
+				    name: "A",
+				}
+			`),
+			"dir1/Blueprints": []byte(`
+				baz_module {
+				    name: "B",
+				}
+			`),
+			"dir2/Blueprints": []byte(`
+				foo_module {
+				    name: "C",
+				    bar: "x",
+				}
+			`),
+			"dir3/Blueprints": []byte(`
+				qux_module {
+				    name: "D",
+				}
+			`),
+		})
+		ctx.RegisterModuleType("foo_module", newFooModule)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+		return errs
+	}
+
+	expectedErrs := []error{
+		errors.New(`dir1/Blueprints:2:5: unrecognized module type "baz_module"`),
+		errors.New(`dir2/Blueprints:4:12: unrecognized property "bar"`),
+		errors.New(`dir3/Blueprints:2:5: unrecognized module type "qux_module"`),
+	}
+
+	for i := 0; i < 2; i++ {
+		errs := parse()
+		if fmt.Sprintf("%s", expectedErrs) != fmt.Sprintf("%s", errs) {
+			t.Errorf("run %d: incorrect errors; expected:\n%s\ngot:\n%s", i, expectedErrs, errs)
+		}
+	}
+}
+
+func TestSetMaxErrors(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+		"dir1/Blueprints": []byte(`
+			baz_module {
+			    name: "B",
+			}
+		`),
+		"dir2/Blueprints": []byte(`
+			baz_module {
+			    name: "C",
+			}
+		`),
+		"dir3/Blueprints": []byte(`
+			baz_module {
+			    name: "D",
+			}
+		`),
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.SetMaxErrors(1)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) != 1 {
+		t.Errorf("expected exactly 1 error, got %d:\n%s", len(errs), errs)
+	}
+}
+
+func TestDefaultMaxErrors(t *testing.T) {
+	files := map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+	}
+	for i := 0; i < 11; i++ {
+		files[fmt.Sprintf("dir%d/Blueprints", i)] = []byte(fmt.Sprintf(`
+			baz_module {
+			    name: "B%d",
+			}
+		`, i))
+	}
+
+	ctx := NewContext()
+	ctx.MockFileSystem(files)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) != 11 {
+		t.Errorf("expected 11 errors, got %d:\n%s", len(errs), errs)
+	}
+}
+
+func TestPreParseHook(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "@NAME@",
+			}
+		`),
+		"dir1/Blueprints": []byte(`
+			@FAIL@
+		`),
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	var hookFiles []string
+	var hookLock sync.Mutex
+	ctx.SetPreParseHook(func(filename string, contents []byte) ([]byte, error) {
+		hookLock.Lock()
+		hookFiles = append(hookFiles, filename)
+		hookLock.Unlock()
+
+		if bytes.Contains(contents, []byte("@FAIL@")) {
+			return nil, errors.New("macro expansion failed")
+		}
+		return bytes.Replace(contents, []byte("@NAME@"), []byte("A"), -1), nil
+	})
+
+	deps, errs := ctx.ParseBlueprintsFiles("Blueprints")
+
+	expectedErrs := []error{
+		errors.New(`dir1/Blueprints:1:1: macro expansion failed`),
+	}
+	if fmt.Sprintf("%s", expectedErrs) != fmt.Sprintf("%s", errs) {
+		t.Errorf("Incorrect errors; expected:\n%s\ngot:\n%s", expectedErrs, errs)
+	}
+
+	if ctx.modulesFromName("A", nil) == nil {
+		t.Errorf("expected module A to be defined after macro expansion")
+	}
+
+	sort.Strings(hookFiles)
+	if !reflect.DeepEqual(hookFiles, []string{"Blueprints", "dir1/Blueprints"}) {
+		t.Errorf("unexpected files passed to hook: %q", hookFiles)
+	}
+
+	if !reflect.DeepEqual(deps, []string{"Blueprints", "dir1/Blueprints"}) {
+		t.Errorf("unexpected deps: %q", deps)
+	}
+}
+
+func TestCreateAlias(t *testing.T) {
+	run := func(bp string, nameInterface NameInterface) (*Context, []error) {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(bp),
+		})
+		if nameInterface != nil {
+			ctx.SetNameInterface(nameInterface)
+		}
+
+		ctx.RegisterBottomUpMutator("alias", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "B" {
+				ctx.CreateAlias("B_alias")
+			}
+		})
+		ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterModuleType("bar_module", newBarModule)
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+		if len(errs) > 0 {
+			t.Errorf("unexpected parse errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	t.Run("dependency", func(t *testing.T) {
+		ctx, errs := run(`
+			foo_module {
+			    name: "A",
+			    deps: ["B_alias"],
+			}
+
+			bar_module {
+			    name: "B",
+			}
+		`, nil)
+		if len(errs) > 0 {
+			t.Errorf("unexpected dep errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		a := ctx.modulesFromName("A", nil)[0].logicModule
+		var deps []string
+		ctx.VisitDirectDeps(a, func(m Module) {
+			deps = append(deps, ctx.ModuleName(m))
+		})
+		if !reflect.DeepEqual(deps, []string{"B"}) {
+			t.Errorf("expected A to depend on B through its alias, got %q", deps)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		_, errs := run(`
+			bar_module {
+			    name: "B",
+			}
+
+			bar_module {
+			    name: "B_alias",
+			}
+		`, nil)
+
+		expectedErrs := []error{
+			errors.New(`Blueprints:2:4: alias "B_alias" for module "B" conflicts with existing module
+       Blueprints:6:4 <-- existing module defined here`),
+		}
+		if fmt.Sprintf("%s", expectedErrs) != fmt.Sprintf("%s", errs) {
+			t.Errorf("Incorrect errors; expected:\n%s\ngot:\n%s", expectedErrs, errs)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		// A NameInterface that doesn't implement Alias.
+		_, errs := run(`
+			bar_module {
+			    name: "B",
+			}
+		`, struct{ NameInterface }{NewSimpleNameInterface()})
+
+		expectedErrs := []error{
+			errors.New(`Blueprints:2:4: alias "B_alias" for module "B" can't be created with a NameInterface that doesn't support aliases`),
+		}
+		if fmt.Sprintf("%s", expectedErrs) != fmt.Sprintf("%s", errs) {
+			t.Errorf("Incorrect errors; expected:\n%s\ngot:\n%s", expectedErrs, errs)
+		}
+	})
+}
+
+func TestCreatedBy(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	createdBy := make(map[string]string)
+
+	ctx.RegisterTopDownMutator("create", createTestMutator)
+	ctx.RegisterBottomUpMutator("check", func(mctx BottomUpMutatorContext) {
+		creator := ""
+		if mctx.IsAutoGenerated() {
+			creator = ctx.ModuleName(mctx.CreatedBy())
+		} else if mctx.CreatedBy() != nil {
+			t.Errorf("expected nil CreatedBy for %q", mctx.ModuleName())
+		}
+		createdBy[mctx.ModuleName()] = creator
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expected := map[string]string{
+		"A": "",
+		"B": "A",
+		"C": "A",
+		"D": "A",
+	}
+	if !reflect.DeepEqual(createdBy, expected) {
+		t.Errorf("unexpected creators, expected %q got %q", expected, createdBy)
+	}
+}
+
+type dirNamespace struct {
+	NamespaceMarker
+	dir string
+}
+
+func (d *dirNamespace) NamespaceName() string {
+	return d.dir
+}
+
+// dirNameInterface is a SimpleNameInterface that puts each module in a namespace for the
+// directory containing its Blueprints file, except for modules in the top level directory which
+// are in the default nil namespace.
+type dirNameInterface struct {
+	*SimpleNameInterface
+	namespaces map[string]*dirNamespace
+}
+
+func newDirNameInterface() *dirNameInterface {
+	return &dirNameInterface{
+		SimpleNameInterface: NewSimpleNameInterface(),
+		namespaces:          make(map[string]*dirNamespace),
+	}
+}
+
+func (d *dirNameInterface) NewModule(ctx NamespaceContext, group ModuleGroup, module Module) (Namespace, []error) {
+	if _, errs := d.SimpleNameInterface.NewModule(ctx, group, module); len(errs) > 0 {
+		return nil, errs
+	}
+	return d.GetNamespace(ctx), nil
+}
+
+func (d *dirNameInterface) GetNamespace(ctx NamespaceContext) Namespace {
+	dir := filepath.Dir(ctx.ModulePath())
+	if dir == "." {
+		return nil
+	}
+	if d.namespaces[dir] == nil {
+		d.namespaces[dir] = &dirNamespace{dir: dir}
+	}
+	return d.namespaces[dir]
+}
+
+func TestVisitModulesInNamespace(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "B",
+			}
+
+			foo_module {
+			    name: "A",
+			}
+		`),
+		"dir1/Blueprints": []byte(`
+			foo_module {
+			    name: "D",
+			}
+
+			foo_module {
+			    name: "C",
+			}
+		`),
+		"dir2/Blueprints": []byte(`
+			foo_module {
+			    name: "E",
+			}
+		`),
+	})
+	nameInterface := newDirNameInterface()
+	ctx.SetNameInterface(nameInterface)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	visit := func(namespace Namespace) []string {
+		var modules []string
+		ctx.VisitModulesInNamespace(namespace, func(m Module) {
+			modules = append(modules, ctx.ModuleName(m))
+		})
+		return modules
+	}
+
+	if got, expected := visit(nil), []string{"A", "B"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("default namespace: expected %q, got %q", expected, got)
+	}
+	if got, expected := visit(nameInterface.namespaces["dir1"]), []string{"C", "D"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("dir1 namespace: expected %q, got %q", expected, got)
+	}
+	if got, expected := visit(nameInterface.namespaces["dir2"]), []string{"E"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("dir2 namespace: expected %q, got %q", expected, got)
+	}
+}
+
+func TestVisitAllModulesUntil(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "C",
+			}
+
+			foo_module {
+			    name: "A",
+			}
+
+			foo_module {
+			    name: "B",
+			}
+		`),
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	visitUntil := func(stop string) []string {
+		var modules []string
+		ctx.VisitAllModulesUntil(func(m Module) bool {
+			modules = append(modules, ctx.ModuleName(m))
+			return ctx.ModuleName(m) == stop
+		})
+		return modules
+	}
+
+	if got, expected := visitUntil("B"), []string{"A", "B"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("stop at B: expected %q, got %q", expected, got)
+	}
+	if got, expected := visitUntil("D"), []string{"A", "B", "C"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("no stop: expected %q, got %q", expected, got)
+	}
+}
+
+func TestModuleNamespaceName(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+		"dir1/Blueprints": []byte(`
+			foo_module {
+			    name: "B",
+			}
+		`),
+	})
+	ctx.SetNameInterface(newDirNameInterface())
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	namespaces := map[string]string{}
+	ctx.VisitAllModules(func(m Module) {
+		namespaces[ctx.ModuleName(m)] = ctx.ModuleNamespaceName(m)
+	})
+
+	if expected := map[string]string{"A": "", "B": "dir1"}; !reflect.DeepEqual(namespaces, expected) {
+		t.Errorf("expected namespaces %q, got %q", expected, namespaces)
+	}
+
+	if got := ctx.ModuleNamespaceName(&fooModule{}); got != "" {
+		t.Errorf("expected an empty namespace for an unknown module, got %q", got)
+	}
+}
+
+func TestWholeGraphMutator(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+
+			bar_module {
+			    name: "B",
+			}
+		`),
+	})
+
+	var seen []string
+	ctx.RegisterWholeGraphMutator("create", func(mctx WholeGraphMutatorContext) {
+		mctx.VisitAllModules(func(m Module) {
+			seen = append(seen, mctx.ModuleName(m))
+		})
+
+		type props struct {
+			Name string
+		}
+		var b Module
+		mctx.VisitAllModulesIf(func(m Module) bool { return mctx.ModuleName(m) == "B" },
+			func(m Module) { b = m })
+		mctx.CreateModule(b, newBarModule, &props{
+			Name: "C",
+		})
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if expected := []string{"A", "B"}; !reflect.DeepEqual(seen, expected) {
+		t.Errorf("unexpected visited modules, expected %q got %q", expected, seen)
+	}
+
+	var all []string
+	ctx.VisitAllModules(func(m Module) {
+		all = append(all, ctx.ModuleName(m))
+	})
+	if expected := []string{"A", "B", "C"}; !reflect.DeepEqual(all, expected) {
+		t.Errorf("unexpected modules, expected %q got %q", expected, all)
+	}
+
+	b := ctx.modulesFromName("B", nil)[0]
+	c := ctx.modulesFromName("C", nil)[0]
+	if c.createdBy != b {
+		t.Errorf("expected C to be created by B, got %v", c.createdBy)
+	}
+	if c.relBlueprintsFile != b.relBlueprintsFile || c.pos != b.pos {
+		t.Errorf("expected C to be at %s %s, got %s %s", b.relBlueprintsFile, b.pos,
+			c.relBlueprintsFile, c.pos)
+	}
+}
+
+type warningModule struct {
+	fooModule
+}
+
+func newWarningModule() (Module, []interface{}) {
+	m := &warningModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (w *warningModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.PropertyWarningf("foo", "foo is deprecated")
+}
+
+func TestWarnings(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			warning_module {
+			    name: "B",
+			    foo: "b",
+			}
+
+			warning_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("warn", func(mctx BottomUpMutatorContext) {
+		mctx.ModuleWarningf("mutator warning")
+	})
+
+	ctx.RegisterModuleType("warning_module", newWarningModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expectedWarnings := []string{
+		`Blueprints:2:4: module "B": mutator warning`,
+		`Blueprints:4:11: module "B": foo: foo is deprecated`,
+		`Blueprints:7:4: module "A": mutator warning`,
+		`Blueprints:7:4: module "A": foo: foo is deprecated`,
+	}
+
+	var warnings []string
+	for _, warning := range ctx.Warnings() {
+		warnings = append(warnings, warning.Error())
+	}
+
+	if !reflect.DeepEqual(warnings, expectedWarnings) {
+		t.Errorf("incorrect warnings:\nwant: %q\n got: %q", expectedWarnings, warnings)
+	}
+}
+
+type nestedPropertiesModule struct {
+	SimpleName
+	properties struct {
+		Nested struct {
+			A string
+			B string
+		}
+	}
+}
+
+func newNestedPropertiesModule() (Module, []interface{}) {
+	m := &nestedPropertiesModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *nestedPropertiesModule) GenerateBuildActions(ModuleContext) {}
+
+func TestDuplicateProperties(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B"],
+			    foo: "x",
+			    deps: ["C"],
+			}
+
+			nested_module {
+			    name: "B",
+			    nested: {
+			        a: "x",
+			        b: "y",
+			        a: "z",
+			    },
+			}
+
+			nested_module {
+			    name: "C",
+			    nested: {
+			        a: "x",
+			    },
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("nested_module", newNestedPropertiesModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+
+	expectedErrs := []error{
+		errors.New(`Blueprints:6:12: property "deps" already defined`),
+		errors.New(`Blueprints:4:12: <-- previous definition here`),
+		errors.New(`Blueprints:14:13: property "nested.a" already defined`),
+		errors.New(`Blueprints:12:13: <-- previous definition here`),
+	}
+	if fmt.Sprintf("%s", expectedErrs) != fmt.Sprintf("%s", errs) {
+		t.Errorf("incorrect errors; expected:\n%s\ngot:\n%s", expectedErrs, errs)
+	}
+	for _, err := range errs {
+		if _, ok := err.(*BlueprintError); !ok {
+			t.Errorf("expected *BlueprintError, got %T", err)
+		}
+	}
+
+	// The errors in A and B don't prevent the other modules in the file from being added.
+	if ctx.modulesFromName("C", nil) == nil {
+		t.Errorf("expected module C to be defined")
+	}
+}
+
+func TestDuplicateNestedPropertiesLimit(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			nested_module {
+			    name: "A",
+			    nested: {a: "1", a: "2", a: "3", a: "4", a: "5", a: "6", b: "y"},
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("nested_module", newNestedPropertiesModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+
+	if len(errs) != maxErrors {
+		t.Errorf("expected %d errors, got %d:\n%s", maxErrors, len(errs), errs)
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), `property "nested.a" already defined`) &&
+			!strings.Contains(err.Error(), "<-- previous definition here") {
+			t.Errorf("unexpected error %s", err)
+		}
+	}
+}
+
+func TestParseAndValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		src     string
+		modules []string
+		errs    bool
+	}{
+		{
+			name: "empty",
+			src:  "",
+		},
+		{
+			name: "valid",
+			src: `
+				foo_module {
+				    name: "A",
+				    deps: ["B"],
+				}
+				bar_module {
+				    name: "B",
+				}
+			`,
+			modules: []string{"A", "B"},
+		},
+		{
+			name: "syntax error",
+			src:  `foo_module {`,
+			errs: true,
+		},
+		{
+			name: "missing value",
+			src:  `foo_module { name: }`,
+			errs: true,
+		},
+		{
+			name: "unknown module type",
+			src:  `baz_module { name: "A" }`,
+			errs: true,
+		},
+		{
+			name: "unknown property",
+			src:  `foo_module { name: "A", baz: "x" }`,
+			errs: true,
+		},
+		{
+			name: "wrong property type",
+			src:  `foo_module { name: "A", deps: "B" }`,
+			errs: true,
+		},
+		{
+			name: "duplicate property",
+			src:  `foo_module { name: "A", name: "B" }`,
+			errs: true,
+		},
+		{
+			name: "panicking factory",
+			src:  `panic_module { name: "A" }`,
+			errs: true,
+		},
+		{
+			name: "garbage",
+			src:  "\x00\xff{{]]\"",
+			errs: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := newContext()
+			ctx.RegisterModuleType("foo_module", newFooModule)
+			ctx.RegisterModuleType("bar_module", newBarModule)
+			ctx.RegisterModuleType("panic_module", func() (Module, []interface{}) {
+				panic("factory panic")
+			})
+
+			modules, errs := ctx.ParseAndValidate("Blueprints", []byte(testCase.src), nil)
+			if testCase.errs != (len(errs) > 0) {
+				t.Fatalf("expected errors %v, got %v", testCase.errs, errs)
+			}
+
+			var names []string
+			for _, m := range modules {
+				names = append(names, m.Name())
+			}
+			if !reflect.DeepEqual(names, testCase.modules) {
+				t.Errorf("expected modules %q, got %q", testCase.modules, names)
+			}
+
+			if len(ctx.moduleInfo) != 0 {
+				t.Errorf("expected no modules to be added to the context")
+			}
+		})
+	}
+}
+
+var pctx = NewPackageContext("github.com/google/blueprint")
+
+type buildDirSingleton struct{}
+
+func newBuildDirSingleton() Singleton {
+	return &buildDirSingleton{}
+}
+
+func (s *buildDirSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.SetNinjaBuildDir(pctx, "out")
+}
+
+func TestSetOutDirVariableName(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": nil,
+	})
+
+	ctx.SetOutDirVariableName("outdir")
+	ctx.RegisterSingletonType("build_dir", newBuildDirSingleton)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(buf.String(), "\noutdir = out\n") {
+		t.Errorf("missing outdir assignment in:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "builddir") {
+		t.Errorf("unexpected builddir in:\n%s", buf.String())
+	}
+
+	dir, err := ctx.NinjaBuildDir()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if dir != "out" {
+		t.Errorf("expected NinjaBuildDir %q, got %q", "out", dir)
+	}
+}
+
+var touchRule = pctx.StaticRule("touch", RuleParams{
+	Command: "touch $out",
+})
+
+type defaultTargetModule struct {
+	fooModule
+}
+
+func newDefaultTargetModule() (Module, []interface{}) {
+	m := &defaultTargetModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (d *defaultTargetModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(pctx, BuildParams{
+		Rule:     touchRule,
+		Outputs:  []string{ctx.ModuleName() + ".out", ctx.ModuleName() + ".extra"},
+		Optional: true,
+	})
+	if d.properties.Foo == "default" {
+		ctx.SetAsDefaultTarget()
+	}
+}
+
+func TestNinjaDefaultTargets(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			default_target_module {
+			    name: "B",
+			    foo: "default",
+			}
+
+			default_target_module {
+			    name: "A",
+			    foo: "default",
+			}
+
+			default_target_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("default_target_module", newDefaultTargetModule)
+	ctx.AddNinjaDefaultTargets("extra", "A.out")
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var defaults []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "default ") {
+			defaults = append(defaults, line)
+		}
+	}
+
+	expected := []string{"default A.out B.out extra"}
+	if !reflect.DeepEqual(defaults, expected) {
+		t.Errorf("incorrect default statements:\nwant: %q\n got: %q", expected, defaults)
+	}
+}
+
+var validationsDir = pctx.StaticVariable("validationsDir", "validations")
+
+type validationsModule struct {
+	fooModule
+}
+
+func newValidationsModule() (Module, []interface{}) {
+	m := &validationsModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (v *validationsModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(pctx, BuildParams{
+		Rule:        touchRule,
+		Outputs:     []string{ctx.ModuleName() + ".out"},
+		Validations: []string{"${validationsDir}/" + ctx.ModuleName() + ".check"},
+	})
+}
+
+func TestBuildValidations(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			validations_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("validations_module", newValidationsModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	out := buf.String()
+
+	expectedVersion := "ninja_required_version = 1.10.0\n"
+	if !strings.Contains(out, expectedVersion) {
+		t.Errorf("expected %q in:\n%s", expectedVersion, out)
+	}
+
+	expectedBuild := "build A.out: g.blueprint.touch |@ ${g.blueprint.validationsDir}/A.check\n"
+	if !strings.Contains(out, expectedBuild) {
+		t.Errorf("expected build statement %q in:\n%s", expectedBuild, out)
+	}
+
+	// The variable is only referenced by the validation, so it is only defined if validations
+	// are tracked as live.
+	expectedVariable := "g.blueprint.validationsDir = validations\n"
+	if !strings.Contains(out, expectedVariable) {
+		t.Errorf("expected variable definition %q in:\n%s", expectedVariable, out)
+	}
+}
+
+func TestMutatorTimings(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+
+			foo_module {
+			    name: "B",
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("fast", func(BottomUpMutatorContext) {})
+	ctx.RegisterBottomUpMutator("slow", func(BottomUpMutatorContext) {
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	timings := ctx.MutatorTimings()
+	if len(timings) != 2 {
+		t.Fatalf("expected 2 mutator timings, got %v", timings)
+	}
+
+	if timings[0].Name != "slow" || timings[1].Name != "fast" {
+		t.Errorf("expected slow mutator before fast mutator, got %v", timings)
+	}
+	if timings[0].Duration < 10*time.Millisecond {
+		t.Errorf("expected slow mutator to take at least 10ms, got %s", timings[0].Duration)
+	}
+	for _, timing := range timings {
+		if timing.ModulesVisited != 2 {
+			t.Errorf("expected mutator %q to visit 2 modules, got %d", timing.Name, timing.ModulesVisited)
+		}
+	}
+}
+
+func TestResolveDependenciesUntil(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	var ran []string
+	for _, name := range []string{"first", "second", "third"} {
+		name := name
+		ctx.RegisterBottomUpMutator(name, func(BottomUpMutatorContext) {
+			ran = append(ran, name)
+		})
+	}
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependenciesUntil(nil, "fourth")
+	if expected := `[unknown mutator "fourth"]`; fmt.Sprintf("%s", errs) != expected {
+		t.Errorf("expected errors %s, got %s", expected, errs)
+	}
+	if len(ran) != 0 {
+		t.Errorf("expected no mutators to run, got %q", ran)
+	}
+
+	_, errs = ctx.ResolveDependenciesUntil(nil, "second")
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if expected := []string{"first", "second"}; !reflect.DeepEqual(ran, expected) {
+		t.Errorf("expected mutators %q to run, got %q", expected, ran)
+	}
+	if ctx.dependenciesReady {
+		t.Errorf("expected dependencies not to be ready")
+	}
+
+	ran = nil
+	_, errs = ctx.PrepareBuildActions(nil)
+	if expected := "[PrepareBuildActions called after ResolveDependenciesUntil]"; fmt.Sprintf("%s", errs) != expected {
+		t.Errorf("expected errors %s, got %s", expected, errs)
+	}
+	if len(ran) != 0 {
+		t.Errorf("expected no mutators to run, got %q", ran)
+	}
+	if ctx.buildActionsReady {
+		t.Errorf("expected build actions not to be ready")
+	}
+
+	// A full resolve afterwards allows PrepareBuildActions to run again.
+	ran = nil
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+	if expected := []string{"first", "second", "third"}; !reflect.DeepEqual(ran, expected) {
+		t.Errorf("expected mutators %q to run, got %q", expected, ran)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+	if !ctx.buildActionsReady {
+		t.Errorf("expected build actions to be ready")
+	}
+}
+
+type generateCallbackModule struct {
+	fooModule
+	generate func(ModuleContext)
+}
+
+func (m *generateCallbackModule) GenerateBuildActions(ctx ModuleContext) {
+	m.generate(ctx)
+}
+
+func TestHasGeneratedBuildActions(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			callback_module {
+			    name: "A",
+			    deps: ["B"],
+			}
+
+			callback_module {
+			    name: "B",
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("blueprint_deps", blueprintDepsMutator)
+
+	var checked bool
+	modules := map[string]Module{}
+	ctx.RegisterModuleType("callback_module", func() (Module, []interface{}) {
+		m := &generateCallbackModule{}
+		m.generate = func(mctx ModuleContext) {
+			if mctx.ModuleName() != "A" {
+				return
+			}
+			checked = true
+			if !ctx.HasStartedBuildActions(m) || ctx.HasGeneratedBuildActions(m) {
+				t.Errorf("expected A to have started but not finished generating build actions")
+			}
+			if !ctx.HasGeneratedBuildActions(modules["B"]) {
+				t.Errorf("expected dependency B to have generated build actions before A")
+			}
+		}
+		return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+	})
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	ctx.VisitAllModules(func(m Module) {
+		modules[ctx.ModuleName(m)] = m
+		if ctx.HasStartedBuildActions(m) || ctx.HasGeneratedBuildActions(m) {
+			t.Errorf("expected %s not to have started generating build actions", ctx.ModuleName(m))
+		}
+	})
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected build action errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if !checked {
+		t.Errorf("expected GenerateBuildActions to be called for A")
+	}
+	for name, m := range modules {
+		if !ctx.HasStartedBuildActions(m) || !ctx.HasGeneratedBuildActions(m) {
+			t.Errorf("expected %s to have generated build actions", name)
+		}
+	}
+}
+
+func TestHasGeneratedBuildActionsFailed(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			callback_module {
+			    name: "A",
+			}
+
+			callback_module {
+			    name: "B",
+			}
+
+			callback_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("callback_module", func() (Module, []interface{}) {
+		m := &generateCallbackModule{}
+		m.generate = func(mctx ModuleContext) {
+			switch mctx.ModuleName() {
+			case "A":
+				panic("failed")
+			case "B":
+				mctx.ModuleErrorf("failed")
+			}
+		}
+		return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+	})
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) != 2 {
+		t.Errorf("expected 2 errors, got %q", errs)
+	}
+
+	ctx.VisitAllModules(func(m Module) {
+		name := ctx.ModuleName(m)
+		if !ctx.HasStartedBuildActions(m) {
+			t.Errorf("expected %s to have started generating build actions", name)
+		}
+		if expected := name == "C"; ctx.HasGeneratedBuildActions(m) != expected {
+			t.Errorf("expected HasGeneratedBuildActions(%s) to be %t", name, expected)
+		}
+	})
+
+	unknown := &fooModule{}
+	if ctx.HasStartedBuildActions(unknown) || ctx.HasGeneratedBuildActions(unknown) {
+		t.Errorf("expected an unknown module not to have started or generated build actions")
+	}
+}
+
+var buildActionsOutDir = pctx.StaticVariable("buildActionsOutDir", "out")
+
+type buildActionsModule struct {
+	fooModule
+}
+
+func newBuildActionsModule() (Module, []interface{}) {
+	m := &buildActionsModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (b *buildActionsModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Variable(pctx, "name", ctx.ModuleName())
+	ctx.Build(pctx, BuildParams{
+		Rule:        touchRule,
+		Description: "touch ${name}",
+		Outputs:     []string{"${buildActionsOutDir}/${name}.out"},
+		Inputs:      []string{"${name}.in"},
+		Implicits:   []string{"implicit"},
+	})
+	ctx.Build(pctx, BuildParams{
+		Rule:            Phony,
+		Outputs:         []string{ctx.ModuleName()},
+		ImplicitOutputs: []string{ctx.ModuleName() + ".phony"},
+		Inputs:          []string{"${buildActionsOutDir}/${name}.out"},
+	})
+}
+
+func TestModuleBuildActions(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			build_actions_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("build_actions_module", newBuildActionsModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	var module Module
+	ctx.VisitAllModules(func(m Module) {
+		module = m
+	})
+
+	if _, err := ctx.ModuleBuildActions(module); err != ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady, got %v", err)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	actions, err := ctx.ModuleBuildActions(module)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []BuildAction{
+		{
+			Rule:        "g.blueprint.touch",
+			Inputs:      []string{"A.in", "implicit"},
+			Outputs:     []string{"out/A.out"},
+			Description: "touch A",
+		},
+		{
+			Rule:    "phony",
+			Inputs:  []string{"out/A.out"},
+			Outputs: []string{"A", "A.phony"},
+		},
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("incorrect build actions:\nwant: %q\n got: %q", expected, actions)
+	}
+
+	if _, err := ctx.ModuleBuildActions(&fooModule{}); err == nil {
+		t.Errorf("expected an error for a module that is not in the Context")
+	}
+}
+
+func TestBuildFileHeaderAndFooter(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": nil,
+	})
+
+	ctx.SetBuildFileHeader("Build ID: 1234\nDo not copy")
+	ctx.SetBuildFileFooter("end of build.ninja")
+	ctx.RegisterSingletonType("build_dir", newBuildDirSingleton)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectedHeader := `# ******************************************************************************
+# ***            This file is generated and should not be edited             ***
+# ******************************************************************************
+#
+#
+# Build ID: 1234
+# Do not copy
+
+ninja_required_version = `
+	if !strings.HasPrefix(buf.String(), expectedHeader) {
+		t.Errorf("incorrect header, want prefix:\n%s\ngot:\n%s", expectedHeader, buf.String())
+	}
+
+	expectedFooter := "\n\n# end of build.ninja\n"
+	if !strings.HasSuffix(buf.String(), expectedFooter) {
+		t.Errorf("incorrect footer, want suffix:\n%s\ngot:\n%s", expectedFooter, buf.String())
+	}
+}
+
+func TestEmitModuleComments(t *testing.T) {
+	writeBuildFile := func(emitModuleComments bool) string {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				build_actions_module {
+				    name: "A",
+				}
+
+				build_actions_module {
+				    name: "B",
+				}
+			`),
+		})
+
+		ctx.SetEmitModuleComments(emitModuleComments)
+		ctx.RegisterModuleType("build_actions_module", newBuildActionsModule)
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+		if len(errs) > 0 {
+			t.Errorf("unexpected parse errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		_, errs = ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteBuildFile(buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return buf.String()
+	}
+
+	stripComments := func(s string) []string {
+		var lines []string
+		for _, line := range strings.Split(s, "\n") {
+			if line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	withComments := writeBuildFile(true)
+	withoutComments := writeBuildFile(false)
+
+	if !strings.Contains(withComments, "# Module:") {
+		t.Errorf("expected Module comments in:\n%s", withComments)
+	}
+	if strings.Contains(withoutComments, "# Module:") {
+		t.Errorf("unexpected Module comments in:\n%s", withoutComments)
+	}
+
+	if a, b := stripComments(withComments), stripComments(withoutComments); !reflect.DeepEqual(a, b) {
+		t.Errorf("build statements differ:\nwith comments:\n%s\nwithout comments:\n%s",
+			strings.Join(a, "\n"), strings.Join(b, "\n"))
+	}
+}
+
+func TestModuleDirMismatches(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["*"]
+
+			foo_module {
+			    name: "A",
+			    foo: ".",
+			}
+
+			foo_module {
+			    name: "B",
+			    foo: "dir2",
+			}
+		`),
+		"dir1/Blueprints": []byte(`
+			foo_module {
+			    name: "C",
+			    foo: "dir1",
+			}
+
+			foo_module {
+			    name: "D",
+			    foo: "dir2",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	errs = ctx.ModuleDirMismatches(func(module Module, dir string) (bool, string) {
+		expected := module.(*fooModule).Foo()
+		if dir != expected {
+			return false, fmt.Sprintf("expected to be in %q", expected)
+		}
+		return true, ""
+	})
+
+	expectedErrs := []error{
+		errors.New(`Blueprints:9:4: module "B" in ".": expected to be in "dir2"`),
+		errors.New(`dir1/Blueprints:7:4: module "D" in "dir1": expected to be in "dir2"`),
+	}
+	if fmt.Sprintf("%s", expectedErrs) != fmt.Sprintf("%s", errs) {
+		t.Errorf("incorrect errors; expected:\n%s\ngot:\n%s", expectedErrs, errs)
+	}
+	for _, err := range errs {
+		if _, ok := err.(*BlueprintError); !ok {
+			t.Errorf("expected *BlueprintError, got %T", err)
+		}
+	}
+}
+
+type localPoolModule struct {
+	fooModule
+}
+
+func newLocalPoolModule() (Module, []interface{}) {
+	m := &localPoolModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (l *localPoolModule) GenerateBuildActions(ctx ModuleContext) {
+	pool := ctx.Pool(pctx, "heavy", 2)
+	ctx.Pool(pctx, "unused", 1)
+	rule := ctx.Rule(pctx, "heavy_touch", RuleParams{
+		Command: "touch $out",
+		Pool:    pool,
+	})
+	ctx.Build(pctx, BuildParams{
+		Rule:    rule,
+		Outputs: []string{ctx.ModuleName() + ".out"},
+	})
+}
+
+func TestModuleLocalPool(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			local_pool_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.SetEmitModuleComments(false)
+	ctx.RegisterModuleType("local_pool_module", newLocalPoolModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `pool m.A_.heavy
+    depth = 2
+
+rule m.A_.heavy_touch
+    pool = m.A_.heavy
+    command = touch ${out}
+
+build A.out: m.A_.heavy_touch
+`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("missing module pool, expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if strings.Contains(buf.String(), "unused") {
+		t.Errorf("unexpected unused pool in:\n%s", buf.String())
+	}
+}
+
+func TestValidateNinjaReferences(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			build_actions_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.SetValidateNinjaReferences(true)
+	ctx.RegisterModuleType("build_actions_module", newBuildActionsModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if err := ctx.WriteBuildFile(&bytes.Buffer{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Simulate a bug that causes a referenced variable not to be written.
+	delete(ctx.globalVariables, buildActionsOutDir)
+
+	err := ctx.WriteBuildFile(&bytes.Buffer{})
+	expected := `Blueprints:2:4: module "A": build ${g.blueprint.buildActionsOutDir}/${m.A_.name}.out references undefined variable g.blueprint.buildActionsOutDir`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+
+	ctx.SetValidateNinjaReferences(false)
+	if err := ctx.WriteBuildFile(&bytes.Buffer{}); err != nil {
+		t.Errorf("unexpected error with validation disabled: %s", err)
+	}
+}
+
+type buildActionsSingleton struct{}
+
+func newBuildActionsSingleton() Singleton {
+	return &buildActionsSingleton{}
+}
+
+func (s *buildActionsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.Build(pctx, BuildParams{
+		Rule:    touchRule,
+		Outputs: []string{"${buildActionsOutDir}/pre.out"},
+	})
+}
+
+func TestValidateNinjaReferencesPreSingleton(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.SetValidateNinjaReferences(true)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterPreSingletonType("pre", newBuildActionsSingleton)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if err := ctx.WriteBuildFile(&bytes.Buffer{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Simulate a bug that causes a referenced variable not to be written.
+	delete(ctx.globalVariables, buildActionsOutDir)
+
+	err := ctx.WriteBuildFile(&bytes.Buffer{})
+	expected := `pre-singleton pre: build ${g.blueprint.buildActionsOutDir}/pre.out references undefined variable g.blueprint.buildActionsOutDir`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestMaxVariantsPerModule(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+
+			foo_module {
+			    name: "B",
+			}
+		`),
+	})
+
+	ctx.SetMaxVariantsPerModule(3)
+	ctx.RegisterBottomUpMutator("arch", func(mctx BottomUpMutatorContext) {
+		mctx.CreateVariations("arm", "x86")
+	})
+	ctx.RegisterBottomUpMutator("link", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "A" {
+			mctx.CreateVariations("shared", "static")
+		}
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+
+	expectedErrs := []error{
+		errors.New(`Blueprints:2:4: module "A" has 4 variants, more than the maximum of 3, created by mutators: arch, link`),
+	}
+	if fmt.Sprintf("%s", expectedErrs) != fmt.Sprintf("%s", errs) {
+		t.Errorf("incorrect errors; expected:\n%s\ngot:\n%s", expectedErrs, errs)
+	}
+}
+
+func TestExcludeDirPatterns(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["dir1", "dir2", "testdata"]
+
+			foo_module {
+			    name: "A",
+			}
+		`),
+		"dir1/Blueprints": []byte(`
+			subdirs = ["testdata"]
+
+			foo_module {
+			    name: "B",
+			}
+		`),
+		"dir1/testdata/Blueprints": []byte(`
+			foo_module {
+			    name: "C",
+			}
+		`),
+		"dir2/Blueprints": []byte(`
+			build = ["other.bp"]
+
+			foo_module {
+			    name: "D",
+			}
+		`),
+		"dir2/other.bp": []byte(`
+			foo_module {
+			    name: "G",
+			}
+		`),
+		"dir2/sub/Blueprints": []byte(`
+			foo_module {
+			    name: "F",
+			}
+		`),
+		"testdata/Blueprints": []byte(`
+			foo_module {
+			    name: "E",
+			    syntax error
+		`),
+	})
+
+	ctx.AddExcludeDirPatterns("**/testdata/**", "dir2")
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	// F is not excluded by the "dir2" pattern, and is still parsed although the Blueprints file
+	// in its parent directory is excluded.
+	for _, name := range []string{"A", "B", "F"} {
+		if ctx.modulesFromName(name, nil) == nil {
+			t.Errorf("expected module %s to be defined", name)
+		}
+	}
+
+	// dir2/Blueprints is never read, so dir2/other.bp is not added to the files to parse, and
+	// the syntax error in testdata/Blueprints is not reported.
+	for _, name := range []string{"C", "D", "E", "G"} {
+		if ctx.modulesFromName(name, nil) != nil {
+			t.Errorf("expected module %s to be excluded", name)
+		}
+	}
+
+	expected := []SkippedModule{
+		{"dir1/testdata/Blueprints", `directory excluded by pattern "**/testdata/**"`},
+		{"dir2/Blueprints", `directory excluded by pattern "dir2"`},
+		{"testdata/Blueprints", `directory excluded by pattern "**/testdata/**"`},
+	}
+	if skipped := ctx.SkippedModules(); !reflect.DeepEqual(skipped, expected) {
+		t.Errorf("incorrect skipped modules:\nwant: %q\n got: %q", expected, skipped)
+	}
+}
+
+func TestUpdateDependencies(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+
+			foo_module {
+			    name: "B",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	a := ctx.modulesFromName("A", nil)[0]
+	b := ctx.modulesFromName("B", nil)[0]
+
+	if len(b.reverseDeps) != 0 {
+		t.Fatalf("expected no reverse dependencies of B, got %v", b.reverseDeps)
+	}
+
+	a.directDeps = append(a.directDeps, depInfo{module: b})
+	if errs := ctx.UpdateDependencies(); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+
+	if !reflect.DeepEqual(b.reverseDeps, []*moduleInfo{a}) {
+		t.Errorf("expected A to be a reverse dependency of B, got %v", b.reverseDeps)
+	}
+	if !reflect.DeepEqual(a.forwardDeps, []*moduleInfo{b}) {
+		t.Errorf("expected B to be a forward dependency of A, got %v", a.forwardDeps)
+	}
+	if ctx.modulesSorted[0] != b || ctx.modulesSorted[1] != a {
+		t.Errorf("expected B to be sorted before A, got %v", ctx.modulesSorted)
+	}
+
+	b.directDeps = append(b.directDeps, depInfo{module: a})
+	errs = ctx.UpdateDependencies()
+	if len(errs) == 0 || !strings.Contains(errs[0].Error(), "encountered dependency cycle") {
+		t.Errorf("expected dependency cycle error, got %v", errs)
+	}
+}
+
+type propertyDefaultsModule struct {
+	fooModule
+	defaultsProperties struct {
+		Defaults []string
+	}
+}
+
+func newPropertyDefaultsModule() (Module, []interface{}) {
+	m := &propertyDefaultsModule{}
+	m.properties.Foo = "default"
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.defaultsProperties}
+}
+
+func (p *propertyDefaultsModule) PropertyDefaults() map[string]PropertyDefault {
+	return map[string]PropertyDefault{
+		"foo": {Source: "newPropertyDefaultsModule", Property: "defaults"},
+	}
+}
+
+func TestPropertyDefaults(t *testing.T) {
+	type props struct {
+		Name string
+		Foo  string
+	}
+
+	testCases := []struct {
+		name        string
+		bp          string
+		create      *props
+		expectedErr string
+	}{
+		{
+			name: "default",
+			bp: `
+				property_defaults_module {
+				    name: "A",
+				}
+			`,
+			expectedErr: `Blueprints:2:5: module "A": foo: bad foo "default" (default from newPropertyDefaultsModule)`,
+		},
+		{
+			name: "set",
+			bp: `
+				property_defaults_module {
+				    name: "A",
+				    foo: "a",
+				}
+			`,
+			expectedErr: `Blueprints:4:12: module "A": foo: bad foo "defaulta"`,
+		},
+		{
+			name: "defaults property",
+			bp: `
+				property_defaults_module {
+				    name: "A",
+				    defaults: ["X"],
+				}
+			`,
+			expectedErr: `Blueprints:4:17: module "A": foo: bad foo "default" (default from newPropertyDefaultsModule)`,
+		},
+		{
+			name: "created default",
+			bp: `
+				foo_module {
+				    name: "A",
+				}
+			`,
+			create:      &props{Name: "B"},
+			expectedErr: `Blueprints:2:5: module "B": foo: bad foo "default" (default from newPropertyDefaultsModule)`,
+		},
+		{
+			name: "created set",
+			bp: `
+				foo_module {
+				    name: "A",
+				}
+			`,
+			create:      &props{Name: "B", Foo: "b"},
+			expectedErr: `Blueprints:2:5: module "B": foo: bad foo "defaultb"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := newContext()
+			ctx.MockFileSystem(map[string][]byte{
+				"Blueprints": []byte(testCase.bp),
+			})
+
+			ctx.RegisterTopDownMutator("create", func(mctx TopDownMutatorContext) {
+				if testCase.create != nil && mctx.ModuleName() == "A" {
+					mctx.CreateModuleWithType(newPropertyDefaultsModule, "property_defaults_module",
+						testCase.create)
+				}
+			})
+			ctx.RegisterBottomUpMutator("check", func(mctx BottomUpMutatorContext) {
+				if m, ok := mctx.Module().(*propertyDefaultsModule); ok {
+					mctx.PropertyErrorf("foo", "bad foo %q", m.Foo())
+				}
+			})
+
+			ctx.RegisterModuleType("foo_module", newFooModule)
+			ctx.RegisterModuleType("property_defaults_module", newPropertyDefaultsModule)
+			_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+			if len(errs) > 0 {
+				t.Errorf("unexpected parse errors:")
+				for _, err := range errs {
+					t.Errorf("  %s", err)
+				}
+				t.FailNow()
+			}
+
+			_, errs = ctx.ResolveDependencies(nil)
+			if len(errs) != 1 || errs[0].Error() != testCase.expectedErr {
+				t.Errorf("incorrect errors; expected:\n%s\ngot:\n%s", testCase.expectedErr, errs)
+			}
+		})
+	}
+}
+
+func TestParseSingleFileOnly(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			build = ["missing.bp", "other.bp"]
+
+			foo_module {
+			    name: "A",
+			}
+		`),
+		"other.bp": []byte(`
+			foo_module {
+			    name: "B",
+			}
+		`),
+		"dir1/Blueprints": []byte(`
+			foo_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	ctx.SetParseSingleFileOnly(true)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if ctx.modulesFromName("A", nil) == nil {
+		t.Errorf("expected module A to be defined")
+	}
+	for _, name := range []string{"B", "C"} {
+		if ctx.modulesFromName(name, nil) != nil {
+			t.Errorf("expected module %s not to be parsed", name)
+		}
+	}
+}
+
+func TestVariantOutDir(t *testing.T) {
+	run := func(pattern string) []string {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				subdirs = ["dir1"]
+			`),
+			"dir1/Blueprints": []byte(`
+				callback_module {
+				    name: "A",
+				}
+			`),
+		})
+
+		if pattern != "" {
+			ctx.SetVariantOutDirPattern(pattern)
+		}
+		ctx.RegisterBottomUpMutator("arch", func(mctx BottomUpMutatorContext) {
+			mctx.CreateVariations("arm", "x86")
+		})
+
+		var lock sync.Mutex
+		var dirs []string
+		ctx.RegisterModuleType("callback_module", func() (Module, []interface{}) {
+			m := &generateCallbackModule{}
+			m.generate = func(mctx ModuleContext) {
+				lock.Lock()
+				defer lock.Unlock()
+				dirs = append(dirs, mctx.VariantOutDir())
+			}
+			return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+		if len(errs) > 0 {
+			t.Errorf("unexpected parse errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		_, errs = ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		sort.Strings(dirs)
+		return dirs
+	}
+
+	if got, expected := run(""), []string{"dir1/arm", "dir1/x86"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("default pattern: expected %q, got %q", expected, got)
+	}
+
+	pattern := "out/{type}/{dir}/{name}/{variant}"
+	expected := []string{"out/callback_module/dir1/A/arm", "out/callback_module/dir1/A/x86"}
+	if got := run(pattern); !reflect.DeepEqual(got, expected) {
+		t.Errorf("pattern %q: expected %q, got %q", pattern, expected, got)
+	}
+}
+
+func TestValidatePropertyStructs(t *testing.T) {
+	badModuleFactory := func() (Module, []interface{}) {
+		m := &fooModule{}
+		return m, []interface{}{&m.properties, &m.SimpleName.Properties, &struct {
+			Nested struct {
+				Foo chan int
+			}
+		}{}}
+	}
+
+	ctx := newContext()
+	ctx.RegisterModuleType("unvalidated_module", badModuleFactory)
+
+	ctx.SetValidatePropertyStructs(true)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatalf("expected RegisterModuleType to panic")
+		}
+		expected := "bad_module.properties.Nested.Foo: unsupported type chan int"
+		if got := fmt.Sprint(r); got != expected {
+			t.Errorf("expected panic %q, got %q", expected, got)
+		}
+	}()
+	ctx.RegisterModuleType("bad_module", badModuleFactory)
+}
+
+type EmbeddedChan chan int
+
+func TestValidatePropertyStructsAnonymous(t *testing.T) {
+	ctx := newContext()
+	ctx.SetValidatePropertyStructs(true)
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatalf("expected RegisterModuleType to panic")
+		}
+		expected := "bad_module.properties: unsupported type blueprint.EmbeddedChan"
+		if got := fmt.Sprint(r); got != expected {
+			t.Errorf("expected panic %q, got %q", expected, got)
+		}
+	}()
+	ctx.RegisterModuleType("bad_module", func() (Module, []interface{}) {
+		m := &fooModule{}
+		return m, []interface{}{&m.properties, &m.SimpleName.Properties, &struct {
+			EmbeddedChan
+		}{}}
+	})
+}
+
+func TestGlobalParseVariables(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["dir1"]
+
+			foo_module {
+			    name: "A",
+			    foo: "version-" + my_version,
+			}
+		`),
+		"dir1/Blueprints": []byte(`
+			foo_module {
+			    name: "B",
+			    foo: my_version,
+			}
+		`),
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.SetGlobalParseVariables(map[string]string{"my_version": "1.2"})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	for name, expected := range map[string]string{"A": "version-1.2", "B": "1.2"} {
+		m := ctx.modulesFromName(name, nil)[0].logicModule.(*fooModule)
+		if m.properties.Foo != expected {
+			t.Errorf("expected module %q foo to be %q, got %q", name, expected, m.properties.Foo)
+		}
+	}
+
+	ctx = newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    foo: other_version,
+			}
+		`),
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.SetGlobalParseVariables(map[string]string{"my_version": "1.2"})
+
+	_, errs = ctx.ParseBlueprintsFiles("Blueprints")
+	expected := []string{`Blueprints:4:13: variable "other_version" is not set`}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected errors %q, got %q", expected, got)
+	}
+}
+
+func TestConfigResolver(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			callback_module {
+			    name: "A",
+			}
+
+			callback_module {
+			    name: "B",
+			}
+		`),
+	})
+
+	var lock sync.Mutex
+	mutatorConfigs := make(map[string]interface{})
+	generateConfigs := make(map[string]interface{})
+
+	ctx.RegisterModuleType("callback_module", func() (Module, []interface{}) {
+		m := &generateCallbackModule{}
+		m.generate = func(mctx ModuleContext) {
+			lock.Lock()
+			defer lock.Unlock()
+			generateConfigs[mctx.ModuleName()] = mctx.Config()
+		}
+		return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+	})
+	ctx.RegisterBottomUpMutator("config", func(mctx BottomUpMutatorContext) {
+		mutatorConfigs[mctx.ModuleName()] = mctx.Config()
+	})
+	ctx.SetConfigResolver(func(module Module) interface{} {
+		if module.Name() == "A" {
+			return "configA"
+		}
+		return nil
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies("default")
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions("default")
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expected := map[string]interface{}{"A": "configA", "B": "default"}
+	if !reflect.DeepEqual(mutatorConfigs, expected) {
+		t.Errorf("expected mutator configs %v, got %v", expected, mutatorConfigs)
+	}
+	if !reflect.DeepEqual(generateConfigs, expected) {
+		t.Errorf("expected GenerateBuildActions configs %v, got %v", expected, generateConfigs)
+	}
+}
+
+func TestRuleRspfile(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			callback_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.SetEmitModuleComments(false)
+	ctx.RegisterModuleType("callback_module", func() (Module, []interface{}) {
+		m := &generateCallbackModule{}
+		m.generate = func(mctx ModuleContext) {
+			mctx.Variable(pctx, "name", mctx.ModuleName())
+			rule := mctx.Rule(pctx, "link", RuleParams{
+				Command:        "link @$out.rsp -o $out",
+				Rspfile:        "$out.rsp",
+				RspfileContent: "${name} $in",
+			})
+			mctx.Build(pctx, BuildParams{
+				Rule:    rule,
+				Outputs: []string{mctx.ModuleName() + ".out"},
+				Inputs:  []string{"a.o", "b.o"},
+			})
+		}
+		return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `rule m.A_.link
+    command = link @${out}.rsp -o ${out}
+    rspfile = ${out}.rsp
+    rspfile_content = ${m.A_.name} ${in}
+`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("missing rspfile attributes, expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	_, err := parseRuleParams(newLocalScope(nil, ""), &RuleParams{
+		Command: "link",
+		Rspfile: "$out.rsp",
+	})
+	if err == nil {
+		t.Errorf("expected an error for Rspfile without RspfileContent")
+	}
+}
+
+var regenRule = pctx.StaticRule("regen", RuleParams{
+	Command:   "regen -o $out",
+	Generator: true,
+})
+
+func TestRuleGenerator(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			callback_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.SetEmitModuleComments(false)
+	ctx.RegisterModuleType("callback_module", func() (Module, []interface{}) {
+		m := &generateCallbackModule{}
+		m.generate = func(mctx ModuleContext) {
+			rule := mctx.Rule(pctx, "local_regen", RuleParams{
+				Command:   "local_regen -o $out",
+				Generator: true,
+			})
+			mctx.Build(pctx, BuildParams{
+				Rule:    regenRule,
+				Outputs: []string{"build.ninja"},
+			})
+			mctx.Build(pctx, BuildParams{
+				Rule:    rule,
+				Outputs: []string{"local.ninja"},
+			})
+		}
+		return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, expected := range []string{
+		"rule g.blueprint.regen\n    command = regen -o ${out}\n    generator = true\n",
+		"rule m.A_.local_regen\n    command = local_regen -o ${out}\n    generator = true\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("missing generator attribute, expected:\n%s\ngot:\n%s", expected, buf.String())
+		}
+	}
+}
+
+type dependencyPathTag struct {
+	BaseDependencyTag
+	name string
+}
+
+func TestDependencyPath(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "C"],
+			}
+
+			foo_module {
+			    name: "B",
+			    deps: ["C"],
+			}
+
+			foo_module {
+			    name: "C",
+			    deps: ["D"],
+			}
+
+			foo_module {
+			    name: "D",
+			}
+
+			foo_module {
+			    name: "E",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", func(mctx BottomUpMutatorContext) {
+		for _, dep := range mctx.Module().(*fooModule).properties.Deps {
+			mctx.AddDependency(mctx.Module(), dependencyPathTag{name: mctx.ModuleName() + "->" + dep}, dep)
+		}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	module := func(name string) Module {
+		return ctx.modulesFromName(name, nil)[0].logicModule
+	}
+	names := func(modules []Module) []string {
+		var ret []string
+		for _, m := range modules {
+			ret = append(ret, m.Name())
+		}
+		return ret
+	}
+
+	path, tags, found := ctx.DependencyPathWithTags(module("A"), module("D"))
+	if !found {
+		t.Fatalf("expected a path from A to D")
+	}
+	if got, expected := names(path), []string{"A", "C", "D"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected path %q, got %q", expected, got)
+	}
+	expectedTags := []DependencyTag{dependencyPathTag{name: "A->C"}, dependencyPathTag{name: "C->D"}}
+	if !reflect.DeepEqual(tags, expectedTags) {
+		t.Errorf("expected tags %v, got %v", expectedTags, tags)
+	}
+
+	if path, found := ctx.DependencyPath(module("B"), module("D")); !found {
+		t.Errorf("expected a path from B to D")
+	} else if got, expected := names(path), []string{"B", "C", "D"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected path %q, got %q", expected, got)
+	}
+
+	if path, found := ctx.DependencyPath(module("D"), module("A")); found {
+		t.Errorf("expected no path from D to A, got %q", names(path))
+	}
+	if path, found := ctx.DependencyPath(module("A"), module("E")); found {
+		t.Errorf("expected no path from A to E, got %q", names(path))
+	}
+	if path, found := ctx.DependencyPath(module("A"), &fooModule{}); found {
+		t.Errorf("expected no path from A to an unknown module, got %q", names(path))
+	}
+	if path, found := ctx.DependencyPath(&fooModule{}, module("A")); found {
+		t.Errorf("expected no path from an unknown module to A, got %q", names(path))
+	}
+}
+
+func TestMissingDependencies(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "missing1", "missing2"],
+			}
+
+			foo_module {
+			    name: "B",
+			}
+		`),
+	})
+
+	ctx.SetAllowMissingDependencies(true)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("blueprint_deps", blueprintDepsMutator)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	a := ctx.modulesFromName("A", nil)[0].logicModule
+	b := ctx.modulesFromName("B", nil)[0].logicModule
+
+	if got, expected := ctx.MissingDependencies(a), []string{"missing1", "missing2"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected missing dependencies of A %q, got %q", expected, got)
+	}
+	if got := ctx.MissingDependencies(b); got == nil || len(got) != 0 {
+		t.Errorf("expected empty missing dependencies of B, got %#v", got)
+	}
+	if got := ctx.MissingDependencies(&fooModule{}); got != nil {
+		t.Errorf("expected nil missing dependencies for an unknown module, got %#v", got)
+	}
+}
+
+func TestLintPasses(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			build_actions_module {
+			    name: "A",
+			}
+
+			foo_module {
+			    name: "B",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("build_actions_module", newBuildActionsModule)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	ctx.RegisterLintPass("no_outputs", func(lctx LintContext) {
+		lctx.VisitAllModules(func(m Module) {
+			actions, err := lctx.ModuleBuildActions(m)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+			if len(actions) == 0 {
+				lctx.Reportf(m, "%s module has no build actions", lctx.ModuleType(m))
+			}
+		})
+	})
+	ctx.RegisterLintPass("panics", func(lctx LintContext) {
+		panic("oops")
+	})
+	ctx.RegisterLintPass("names", func(lctx LintContext) {
+		lctx.VisitAllModules(func(m Module) {
+			lctx.Report(m, "name "+lctx.ModuleName(m))
+		})
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if _, errs := ctx.RunLintPasses(nil); len(errs) != 1 || errs[0] != ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady before PrepareBuildActions, got %v", errs)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	findings, errs := ctx.RunLintPasses(nil)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "lint pass panics") {
+		t.Errorf("expected a panic error from the panics lint pass, got %v", errs)
+	}
+
+	var got []string
+	for _, finding := range findings {
+		got = append(got, finding.Pass+": "+finding.Error())
+	}
+	expected := []string{
+		`names: Blueprints:2:4: module "A": name A`,
+		`no_outputs: Blueprints:6:4: module "B": foo_module module has no build actions`,
+		`names: Blueprints:6:4: module "B": name B`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect findings:\nwant: %q\n got: %q", expected, got)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	files := make(map[string][]byte)
+	dir := ""
+	for i := 0; i < 10; i++ {
+		files[filepath.Join(dir, "Blueprints")] = []byte(fmt.Sprintf(`
+			foo_module {
+			    name: "M%d",
+			}
+		`, i))
+		dir = filepath.Join(dir, "d")
+	}
+
+	ctx := newContext()
+	ctx.MockFileSystem(files)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	ctx.Context = cancelCtx
+	ctx.SetPreParseHook(func(filename string, contents []byte) ([]byte, error) {
+		if filename == "d/d/d/Blueprints" {
+			cancel()
+		}
+		return contents, nil
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) == 0 || errs[len(errs)-1] != context.Canceled {
+		t.Errorf("expected context.Canceled error from parsing, got %v", errs)
+	}
+	if len(ctx.modulesFromName("M9", nil)) != 0 {
+		t.Errorf("expected parsing to stop before M9")
+	}
+
+	ctx = newContext()
+	ctx.MockFileSystem(files)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("blueprint_deps", blueprintDepsMutator).Parallel()
+
+	_, errs = ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	cancelCtx, cancel = context.WithCancel(context.Background())
+	ctx.Context = cancelCtx
+	cancel()
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) != 1 || errs[0] != context.Canceled {
+		t.Errorf("expected context.Canceled error from ResolveDependencies, got %v", errs)
+	}
+}
+
+func TestRegisterModuleTypesFromSchema(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			schema_module {
+			    name: "A",
+			    srcs: ["a.c", "b.c"],
+			    enabled: true,
+			    target: {
+			        arch: "arm",
+			    },
+			}
+		`),
+	})
+
+	err := ctx.RegisterModuleTypesFromSchema(strings.NewReader(`[
+		{
+			"name": "schema_module",
+			"properties": {
+				"srcs": "list",
+				"enabled": "bool",
+				"version": "int64",
+				"target": {
+					"arch": "string"
+				}
+			}
+		}
+	]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var generated []string
+	ctx.SetSchemaModuleHandler("schema_module", func(mctx ModuleContext, m *SchemaModule) {
+		generated = append(generated, mctx.ModuleName())
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	m := ctx.modulesFromName("A", nil)[0].logicModule.(*SchemaModule)
+	props := reflect.ValueOf(m.Properties()).Elem()
+	if got, expected := props.FieldByName("Srcs").Interface(), []string{"a.c", "b.c"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected srcs %q, got %q", expected, got)
+	}
+	if got := props.FieldByName("Enabled").Interface().(*bool); got == nil || !*got {
+		t.Errorf("expected enabled to be set to true, got %v", got)
+	}
+	if got := props.FieldByName("Version").Interface().(*int64); got != nil {
+		t.Errorf("expected version to be unset, got %d", *got)
+	}
+	if got := props.FieldByName("Target").FieldByName("Arch").Interface().(*string); got == nil || *got != "arm" {
+		t.Errorf("expected target.arch to be arm, got %v", got)
+	}
+	if expected := []string{"A"}; !reflect.DeepEqual(generated, expected) {
+		t.Errorf("expected handler to be called for %q, got %q", expected, generated)
+	}
+
+	for _, testCase := range []struct {
+		schema string
+		err    string
+	}{
+		{
+			schema: `[{"name": "schema_module"}]`,
+			err:    `module type "schema_module" is already registered`,
+		},
+		{
+			schema: `[{"name": "other", "properties": {"srcs": "map"}}]`,
+			err:    `module type "other": property "srcs" has unknown type "map"`,
+		},
+		{
+			schema: `[{"name": "other", "properties": {"target": {"Arch": "string"}}}]`,
+			err:    `module type "other": in property "target": invalid property name "Arch"`,
+		},
+		{
+			schema: `[{"name": "other", "properties": {"name": "string"}}]`,
+			err:    `module type "other": property "name" is built in`,
+		},
+	} {
+		err := ctx.RegisterModuleTypesFromSchema(strings.NewReader(testCase.schema))
+		if err == nil || err.Error() != testCase.err {
+			t.Errorf("schema %s: expected error %q, got %v", testCase.schema, testCase.err, err)
+		}
+	}
+}
+
+func TestUsedModuleTypes(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "C"],
+			}
+		`),
+	})
+
+	ctx.RegisterTopDownMutator("create", func(mctx TopDownMutatorContext) {
+		type props struct {
+			Name string
+			Deps []string
+		}
+
+		mctx.CreateModuleWithType(newBarModule, "bar_module", &props{
+			Name: "B",
+			Deps: []string{"D"},
+		})
+
+		mctx.CreateModuleWithType(newBarModule, "bar_module", &props{
+			Name: "C",
+			Deps: []string{"D"},
+		})
+
+		mctx.CreateModule(newFooModule, &props{
+			Name: "D",
+		})
+	})
+	ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+	ctx.RegisterBottomUpMutator("variants", func(mctx BottomUpMutatorContext) {
+		if _, ok := mctx.Module().(*fooModule); ok {
+			mctx.CreateVariations("a", "b")
+		}
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterModuleType("unused_module", newBuildActionsModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if got, expected := ctx.UsedModuleTypes(), map[string]int{"foo_module": 1}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected used module types after parsing %v, got %v", expected, got)
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expected := map[string]int{"foo_module": 1, "bar_module": 2, "": 1}
+	if got := ctx.UsedModuleTypes(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected used module types %v, got %v", expected, got)
+	}
+}
+
+func TestNinjaNameSanitizer(t *testing.T) {
+	run := func(sanitizer func(string) string) (string, []error) {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				build_actions_module {
+				    name: "a+b",
+				}
+
+				build_actions_module {
+				    name: "a=b",
+				}
+			`),
+		})
+		ctx.SetEmitModuleComments(false)
+		ctx.RegisterModuleType("build_actions_module", newBuildActionsModule)
+		if sanitizer != nil {
+			ctx.SetNinjaNameSanitizer(sanitizer)
+		}
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+		if len(errs) > 0 {
+			t.Errorf("unexpected parse errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		_, errs = ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			return "", errs
+		}
+
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteBuildFile(buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return buf.String(), nil
+	}
+
+	escape := func(name string) string {
+		return strings.NewReplacer("+", ".plus.", "=", ".eq.").Replace(name)
+	}
+	out, errs := run(escape)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+	for _, expected := range []string{"m.a.plus.b_.name = a+b", "m.a.eq.b_.name = a=b"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in:\n%s", expected, out)
+		}
+	}
+
+	_, errs = run(func(name string) string {
+		return strings.NewReplacer("+", "_", "=", "_").Replace(name)
+	})
+	expected := []string{`Blueprints:6:5: Ninja name "m.a_b_." for module "a=b" is already used by module "a+b"`}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect errors:\nwant: %q\n got: %q", expected, got)
+	}
+
+	_, errs = run(func(name string) string { return name })
+	if len(errs) != 2 || !strings.Contains(errs[0].Error(), "invalid Ninja name for module \"a") {
+		t.Errorf("expected invalid Ninja name errors, got %v", errs)
+	}
+}
+
+func TestWriteModuleOutputManifest(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			build_actions_module {
+			    name: "A",
+			}
+
+			build_actions_module {
+			    name: "B",
+			}
+
+			foo_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("build_actions_module", newBuildActionsModule)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if err := ctx.WriteModuleOutputManifest(&bytes.Buffer{}); err != ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady, got %v", err)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteModuleOutputManifest(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `{"A:":["out/A.out","A"],"B:":["out/B.out","B"]}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("incorrect manifest:\nwant: %s\n got: %s", expected, buf.String())
+	}
+}
+
+// dirScopedNameInterface is a dirNameInterface that looks up modules by name only in the
+// namespace of their directory, so modules in different directories can have the same name.
+type dirScopedNameInterface struct {
+	*dirNameInterface
+	modules map[Namespace]map[string]ModuleGroup
+	all     []ModuleGroup
+}
+
+func newDirScopedNameInterface() *dirScopedNameInterface {
+	return &dirScopedNameInterface{
+		dirNameInterface: newDirNameInterface(),
+		modules:          make(map[Namespace]map[string]ModuleGroup),
+	}
+}
+
+func (d *dirScopedNameInterface) NewModule(ctx NamespaceContext, group ModuleGroup, module Module) (Namespace, []error) {
+	namespace := d.GetNamespace(ctx)
+	if d.modules[namespace] == nil {
+		d.modules[namespace] = make(map[string]ModuleGroup)
+	}
+	name := group.String()
+	if _, exists := d.modules[namespace][name]; exists {
+		return nil, []error{fmt.Errorf("module %q already defined", name)}
+	}
+	d.modules[namespace][name] = group
+	d.all = append(d.all, group)
+	return namespace, nil
+}
+
+func (d *dirScopedNameInterface) ModuleFromName(name string, namespace Namespace) (ModuleGroup, bool) {
+	group, found := d.modules[namespace][name]
+	return group, found
+}
+
+func (d *dirScopedNameInterface) AllModules() []ModuleGroup {
+	return d.all
+}
+
+func (d *dirScopedNameInterface) UniqueName(ctx NamespaceContext, name string) string {
+	return filepath.Join(filepath.Dir(ctx.ModulePath()), name)
+}
+
+func TestWriteModuleOutputManifestNamespaces(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			build_actions_module {
+			    name: "A",
+			}
+		`),
+		"dir1/Blueprints": []byte(`
+			build_actions_module {
+			    name: "A",
+			}
+		`),
+	})
+	ctx.SetNameInterface(newDirScopedNameInterface())
+	ctx.RegisterModuleType("build_actions_module", newBuildActionsModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteModuleOutputManifest(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `{"A:":["out/A.out","A"],"dir1/A:":["out/A.out","A"]}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("incorrect manifest:\nwant: %s\n got: %s", expected, buf.String())
+	}
+}
+
+func TestFindDuplicateOutputs(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			callback_module {
+			    name: "A",
+			}
+
+			callback_module {
+			    name: "B",
+			}
+
+			callback_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("callback_module", func() (Module, []interface{}) {
+		m := &generateCallbackModule{}
+		m.generate = func(mctx ModuleContext) {
+			shared := "${buildActionsOutDir}/shared.o"
+			if mctx.ModuleName() == "C" {
+				shared = "${buildActionsOutDir}/C.o"
+			}
+			mctx.Build(pctx, BuildParams{
+				Rule:            touchRule,
+				Outputs:         []string{shared},
+				ImplicitOutputs: []string{mctx.ModuleName() + ".d"},
+			})
+			mctx.Build(pctx, BuildParams{
+				Rule:    Phony,
+				Outputs: []string{"all"},
+				Inputs:  []string{shared},
+			})
+		}
+		return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if _, err := ctx.FindDuplicateOutputs(); err != ErrBuildActionsNotReady {
+		t.Errorf("expected %q before PrepareBuildActions, got %v", ErrBuildActionsNotReady, err)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	duplicates, err := ctx.FindDuplicateOutputs()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := make(map[string][]string)
+	for output, modules := range duplicates {
+		for _, module := range modules {
+			got[output] = append(got[output], ctx.ModuleName(module))
+		}
+		sort.Strings(got[output])
+	}
+
+	expected := map[string][]string{
+		"out/shared.o": {"A", "B"},
+		"all":          {"A", "B", "C"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect duplicate outputs:\nwant: %v\n got: %v", expected, got)
+	}
+}
+
+func TestDefiningPackage(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			callback_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	var localRule Rule
+	ctx.RegisterModuleType("callback_module", func() (Module, []interface{}) {
+		m := &generateCallbackModule{}
+		m.generate = func(mctx ModuleContext) {
+			localRule = mctx.Rule(pctx, "local", RuleParams{
+				Command: "cp $in $out",
+			})
+			mctx.Build(pctx, BuildParams{
+				Rule:    localRule,
+				Outputs: []string{"${buildActionsOutDir}/A.out"},
+				Inputs:  []string{"A.in"},
+			})
+		}
+		return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected RuleDefiningPackage to panic before PrepareBuildActions")
+			}
+		}()
+		ctx.RuleDefiningPackage(touchRule)
+	}()
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	const pkgPath = "github.com/google/blueprint"
+	if got := ctx.RuleDefiningPackage(touchRule); got != pkgPath {
+		t.Errorf("expected touch rule to be defined by %q, got %q", pkgPath, got)
+	}
+	if got := ctx.RuleDefiningPackage(Phony); got != "" {
+		t.Errorf("expected no package for the phony rule, got %q", got)
+	}
+	if got := ctx.RuleDefiningPackage(localRule); got != "" {
+		t.Errorf("expected no package for a module's rule, got %q", got)
+	}
+	if got := ctx.PoolDefiningPackage(Console); got != "" {
+		t.Errorf("expected no package for the console pool, got %q", got)
+	}
+	if got := ctx.VariableDefiningPackage(buildActionsOutDir); got != pkgPath {
+		t.Errorf("expected buildActionsOutDir to be defined by %q, got %q", pkgPath, got)
+	}
+}
+
+func TestFarDepProperty(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B"],
+			    foo: "a",
+			}
+
+			foo_module {
+			    name: "B",
+			    foo: "b",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+
+	modules := map[string]Module{}
+	type result struct {
+		value interface{}
+		ok    bool
+	}
+	results := map[string]result{}
+	var resultsLock sync.Mutex
+	ctx.RegisterBottomUpMutator("far", func(mctx BottomUpMutatorContext) {
+		// Modify a property to check that the value after the dependency was visited is returned.
+		mctx.Module().(*fooModule).properties.Foo += "2"
+
+		resultsLock.Lock()
+		defer resultsLock.Unlock()
+
+		switch mctx.ModuleName() {
+		case "A":
+			value, ok := mctx.FarDepProperty(modules["B"], "foo")
+			results["B.foo"] = result{value, ok}
+			value, ok = mctx.FarDepProperty(modules["B"], "name")
+			results["B.name"] = result{value, ok}
+			value, ok = mctx.FarDepProperty(modules["B"], "missing")
+			results["B.missing"] = result{value, ok}
+			value, ok = mctx.FarDepProperty(mctx.Module(), "foo")
+			results["self"] = result{value, ok}
+		case "B":
+			value, ok := mctx.FarDepProperty(modules["A"], "foo")
+			results["A.foo"] = result{value, ok}
+		}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	modules["A"] = ctx.modulesFromName("A", nil)[0].logicModule
+	modules["B"] = ctx.modulesFromName("B", nil)[0].logicModule
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expected := map[string]result{
+		"B.foo":     {"b2", true},
+		"B.name":    {"B", true},
+		"B.missing": {nil, false},
+		"self":      {nil, false},
+		"A.foo":     {nil, false},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("incorrect results:\nwant: %v\n got: %v", expected, results)
+	}
+}
+
+func TestModuleTags(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+
+			foo_module {
+			    name: "B",
+			}
+
+			foo_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("tag", func(mctx BottomUpMutatorContext) {
+		switch mctx.ModuleName() {
+		case "A":
+			ctx.TagModule(mctx.Module(), "api", "public")
+		case "C":
+			ctx.TagModule(mctx.Module(), "api")
+			ctx.TagModule(mctx.Module(), "api")
+		}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	names := func(modules []Module) []string {
+		var ret []string
+		for _, module := range modules {
+			ret = append(ret, ctx.ModuleName(module))
+		}
+		return ret
+	}
+
+	if got, expected := names(ctx.ModulesWithTag("api")), []string{"A", "C"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect modules with tag api:\nwant: %q\n got: %q", expected, got)
+	}
+	if got, expected := names(ctx.ModulesWithTag("public")), []string{"A"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect modules with tag public:\nwant: %q\n got: %q", expected, got)
+	}
+	if got := ctx.ModulesWithTag("missing"); len(got) != 0 {
+		t.Errorf("expected no modules with tag missing, got %q", names(got))
+	}
+}
+
+func TestDependencyTagTypes(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "C", "D"],
+			}
+
+			foo_module {
+			    name: "B",
+			    deps: ["C"],
+			}
+
+			foo_module {
+			    name: "C",
+			}
+
+			foo_module {
+			    name: "D",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", func(mctx BottomUpMutatorContext) {
+		for _, dep := range mctx.Module().(*fooModule).properties.Deps {
+			switch dep {
+			case "B":
+				mctx.AddDependency(mctx.Module(), &dependencyPathTag{}, dep)
+			case "C":
+				mctx.AddDependency(mctx.Module(), dependencyPathTag{}, dep)
+			case "D":
+				mctx.AddDependency(mctx.Module(), nil, dep)
+			}
+		}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expected := []string{
+		"*github.com/google/blueprint.dependencyPathTag",
+		"<nil>",
+		"github.com/google/blueprint.dependencyPathTag",
+	}
+	if got := ctx.DependencyTagTypes(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect dependency tag types:\nwant: %q\n got: %q", expected, got)
+	}
+}
+
+func TestVisibilityChecker(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "C"],
+			}
+
+			foo_module {
+			    name: "B",
+			}
+
+			foo_module {
+			    name: "C",
+			    deps: ["B"],
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+
+	var edges []string
+	ctx.SetVisibilityChecker(func(from, to Module, tag DependencyTag) error {
+		edges = append(edges, ctx.ModuleName(from)+"->"+ctx.ModuleName(to))
+		if ctx.ModuleName(to) == "B" && ctx.ModuleName(from) != "C" {
+			return fmt.Errorf("B is only visible to C")
+		}
+		return nil
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	expected := []string{
+		`Blueprints:2:4: module "A": dependency on module "B" is not allowed: B is only visible to C`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect errors:\nwant: %q\n got: %q", expected, got)
+	}
+
+	sort.Strings(edges)
+	if expectedEdges := []string{"A->B", "A->C", "C->B"}; !reflect.DeepEqual(edges, expectedEdges) {
+		t.Errorf("incorrect edges checked:\nwant: %q\n got: %q", expectedEdges, edges)
+	}
+}
+
+func TestVisibilityCheckerMaxErrors(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "C"],
+			}
+
+			foo_module {
+			    name: "B",
+			}
+
+			foo_module {
+			    name: "C",
+			    deps: ["B"],
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+	ctx.SetVisibilityChecker(func(from, to Module, tag DependencyTag) error {
+		return fmt.Errorf("not visible")
+	})
+	ctx.SetMaxErrors(2)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) != 2 {
+		t.Errorf("expected exactly 2 errors, got %d:\n%s", len(errs), errs)
+	}
+}
+
+func TestReparseChangedFiles(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			x = "root"
+			build = ["root.bp"]
+
+			foo_module {
+			    name: "R",
+			}
+		`),
+		"root.bp": []byte(`
+			foo_module {
+			    name: "RB",
+			}
+		`),
+		"dir/Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["C"],
+			    foo: x,
+			}
+
+			foo_module {
+			    name: "B",
+			}
+		`),
+		"dir/sub/Blueprints": []byte(`
+			foo_module {
+			    name: "S",
+			    foo: x,
+			}
+		`),
+		"other/Blueprints": []byte(`
+			foo_module {
+			    name: "C",
+			}
+		`),
+		"gone/Blueprints": []byte(`
+			foo_module {
+			    name: "G",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	before := make(map[string]Module)
+	for _, name := range []string{"R", "RB", "C", "S"} {
+		before[name] = ctx.modulesFromName(name, nil)[0].logicModule
+	}
+
+	// The root Blueprints file is parsed again for its variables, but root.bp, which it lists in
+	// its build variable and which now has a syntax error, is not.
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			x = "root"
+			build = ["root.bp"]
+
+			foo_module {
+			    name: "R",
+			}
+		`),
+		"root.bp": []byte(`
+			foo_module {
+			    name: "RB",
+			    syntax error
+		`),
+		"dir/Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["D"],
+			    foo: x + "2",
+			}
+
+			foo_module {
+			    name: "D",
+			}
+		`),
+		"dir/sub/Blueprints": []byte(`
+			foo_module {
+			    name: "S",
+			    foo: x,
+			}
+		`),
+		"other/Blueprints": []byte(`
+			foo_module {
+			    name: "C",
+			}
+		`),
+		"new/Blueprints": []byte(`
+			foo_module {
+			    name: "E",
+			    deps: ["C"],
+			}
+		`),
+	})
+
+	_, errs = ctx.ReparseChangedFiles([]string{"dir/Blueprints", "gone/Blueprints", "new/Blueprints"}, nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected reparse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	var names []string
+	for _, group := range ctx.moduleGroups {
+		names = append(names, group.name)
+	}
+	sort.Strings(names)
+	if expected := []string{"A", "C", "D", "E", "R", "RB", "S"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("incorrect modules after reparse:\nwant: %q\n got: %q", expected, names)
+	}
+	if _, exists := ctx.nameInterface.ModuleFromName("B", nil); exists {
+		t.Errorf("expected removed module B to be removed from the name interface")
+	}
+
+	for _, name := range []string{"R", "RB", "C", "S"} {
+		if ctx.modulesFromName(name, nil)[0].logicModule != before[name] {
+			t.Errorf("expected module %s in an unchanged file to be kept", name)
+		}
+	}
+
+	for name, expected := range map[string]string{"A": "root2", "S": "root"} {
+		if got := ctx.modulesFromName(name, nil)[0].logicModule.(*fooModule).properties.Foo; got != expected {
+			t.Errorf("expected %s.foo to be %q, got %q", name, expected, got)
+		}
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	var deps []string
+	ctx.VisitDirectDeps(ctx.modulesFromName("A", nil)[0].logicModule, func(dep Module) {
+		deps = append(deps, ctx.ModuleName(dep))
+	})
+	if expected := []string{"D"}; !reflect.DeepEqual(deps, expected) {
+		t.Errorf("incorrect deps of A:\nwant: %q\n got: %q", expected, deps)
+	}
+
+	_, errs = ctx.ReparseChangedFiles([]string{"dir/Blueprints"}, nil)
+	if len(errs) != 1 || errs[0].Error() != "ReparseChangedFiles called after ResolveDependencies" {
+		t.Errorf("expected an error reparsing after ResolveDependencies, got %q", errs)
+	}
+}
+
+func TestReparseChangedFilesNameInterface(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+	})
+	// A NameInterface that doesn't implement RemoveModule.
+	ctx.SetNameInterface(struct{ NameInterface }{NewSimpleNameInterface()})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ReparseChangedFiles([]string{"Blueprints"}, nil)
+	expected := "ReparseChangedFiles called with a NameInterface that can't remove modules"
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, errs)
+	}
+	if ctx.modulesFromName("A", nil) == nil {
+		t.Errorf("expected module A to be kept")
+	}
+}
+
+func TestFileScope(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			x = "root"
+		`),
+		"dir/Blueprints": []byte(`
+			y = x + "2"
+			z = ["a"]
+			z += ["b"]
+
+			foo_module {
+			    name: "A",
+			    foo: y,
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	variables, ok := ctx.FileScope("dir/Blueprints")
+	if !ok {
+		t.Fatalf("expected a scope for dir/Blueprints")
+	}
+
+	got := make(map[string]string)
+	for name, variable := range variables {
+		var value string
+		switch v := variable.Value.(type) {
+		case *parser.String:
+			value = v.Value
+		case *parser.List:
+			for _, element := range v.Values {
+				value += element.(*parser.String).Value
+			}
+		}
+		got[name] = fmt.Sprintf("%s %t %d", value, variable.Inherited, variable.Pos.Line)
+	}
+	expected := map[string]string{
+		"x": "root true 2",
+		"y": "root2 false 2",
+		"z": "ab false 3",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect scope:\nwant: %q\n got: %q", expected, got)
+	}
+
+	if _, ok := ctx.FileScope("missing/Blueprints"); ok {
+		t.Errorf("expected no scope for missing/Blueprints")
+	}
+}
+
+func TestModuleNameValidator(t *testing.T) {
+	validator := func(name string) error {
+		if strings.Contains(name, "_") {
+			return fmt.Errorf("must not contain underscores")
+		}
+		return nil
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				foo_module {
+				    name: "a",
+				}
+
+				foo_module {
+				    name: "b_c",
+				}
+			`),
+		})
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.SetModuleNameValidator(validator)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		expected := []string{`Blueprints:6:5: invalid module name "b_c": must not contain underscores`}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("incorrect errors:\nwant: %q\n got: %q", expected, got)
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				foo_module {
+				    name: "a",
+				}
+			`),
+		})
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterTopDownMutator("create", func(mctx TopDownMutatorContext) {
+			if mctx.ModuleName() == "a" {
+				type props struct {
+					Name string
+				}
+				mctx.CreateModule(newFooModule, &props{Name: "created_module"})
+			}
+		})
+		ctx.SetModuleNameValidator(validator)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+		if len(errs) > 0 {
+			t.Errorf("unexpected parse errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		_, errs = ctx.ResolveDependencies(nil)
+
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		expected := []string{`Blueprints:2:5: invalid module name "created_module": must not contain underscores`}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("incorrect errors:\nwant: %q\n got: %q", expected, got)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				foo_module {
+				    name: "b",
+				}
+			`),
+		})
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterBottomUpMutator("rename", func(mctx BottomUpMutatorContext) {
+			mctx.Rename("renamed_b")
+		})
+		ctx.SetModuleNameValidator(validator)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+		if len(errs) > 0 {
+			t.Errorf("unexpected parse errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		_, errs = ctx.ResolveDependencies(nil)
+
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		expected := []string{`Blueprints:2:5: invalid module name "renamed_b": must not contain underscores`}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("incorrect errors:\nwant: %q\n got: %q", expected, got)
+		}
+	})
+}
+
+type durationModule struct {
+	SimpleName
+	properties struct {
+		Timeout *time.Duration
+		Retries *int64
+	}
+}
+
+func newDurationModule() (Module, []interface{}) {
+	m := &durationModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (d *durationModule) GenerateBuildActions(ModuleContext) {}
+
+func TestPropertyUnpackHook(t *testing.T) {
+	durationType := reflect.TypeOf((*time.Duration)(nil))
+	hook := func(field reflect.StructField, value parser.Expression) (interface{}, bool, error) {
+		if field.Type != durationType {
+			return nil, false, nil
+		}
+		s, ok := value.Eval().(*parser.String)
+		if !ok {
+			return nil, false, fmt.Errorf("expected a duration string")
+		}
+		d, err := time.ParseDuration(s.Value)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid duration %q", s.Value)
+		}
+		return &d, true, nil
+	}
+
+	t.Run("unpack", func(t *testing.T) {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				duration_module {
+				    name: "a",
+				    timeout: "1m30s",
+				    retries: 3,
+				}
+			`),
+		})
+		ctx.RegisterModuleType("duration_module", newDurationModule)
+		ctx.SetPropertyUnpackHook(hook)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+		if len(errs) > 0 {
+			t.Errorf("unexpected parse errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		_, errs = ctx.ResolveDependencies(nil)
+		if len(errs) > 0 {
+			t.Errorf("unexpected dep errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		a := ctx.modulesFromName("a", nil)[0].logicModule.(*durationModule)
+		if a.properties.Timeout == nil || *a.properties.Timeout != 90*time.Second {
+			t.Errorf("incorrect timeout: want %s, got %v", 90*time.Second, a.properties.Timeout)
+		}
+		if a.properties.Retries == nil || *a.properties.Retries != 3 {
+			t.Errorf("incorrect retries: want 3, got %v", a.properties.Retries)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				duration_module {
+				    name: "a",
+				    timeout: "soon",
+				}
+			`),
+		})
+		ctx.RegisterModuleType("duration_module", newDurationModule)
+		ctx.SetPropertyUnpackHook(hook)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		expected := []string{`Blueprints:4:18: can't unpack property "timeout": invalid duration "soon"`}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("incorrect errors:\nwant: %q\n got: %q", expected, got)
+		}
+	})
+}
+
+func TestBlueprintFiles(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			build = ["other.bp"]
+
+			foo_module {
+			    name: "A",
+			}
+		`),
+		"other.bp": []byte(`
+			foo_module {
+			    name: "O",
+			}
+		`),
+		"dir1/Blueprints": []byte(`
+			foo_module {
+			    name: "B",
+			}
+		`),
+		"dir2/Blueprints": []byte(`
+			foo_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expected := []string{"Blueprints", "dir1/Blueprints", "dir2/Blueprints", "other.bp"}
+	if got := ctx.BlueprintFiles(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect Blueprints files:\nwant: %q\n got: %q", expected, got)
+	}
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			build = ["other.bp"]
+
+			foo_module {
+			    name: "A",
+			}
+		`),
+		"other.bp": []byte(`
+			foo_module {
+			    name: "O",
+			}
+		`),
+		"dir1/Blueprints": []byte(`
+			foo_module {
+			    name: "B",
+			}
+		`),
+	})
+
+	_, errs = ctx.ReparseChangedFiles([]string{"dir2/Blueprints"}, nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expected = []string{"Blueprints", "dir1/Blueprints", "other.bp"}
+	if got := ctx.BlueprintFiles(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect Blueprints files after reparse:\nwant: %q\n got: %q", expected, got)
+	}
+}
+
+func TestSetBlueprintsFileName(t *testing.T) {
+	ctx := newContext()
+	ctx.SetBlueprintsFileName("Android.bp")
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+		"dir/Android.bp": []byte(`
+			foo_module {
+			    name: "B",
+			}
+		`),
+		"other/Blueprints": []byte(`
+			foo_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expected := []string{"Android.bp", "dir/Android.bp"}
+	if got := ctx.BlueprintFiles(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect Blueprints files:\nwant: %q\n got: %q", expected, got)
+	}
+	if ctx.modulesFromName("C", nil) != nil {
+		t.Errorf("module C in a file named Blueprints should not have been parsed")
+	}
+}
+
+func TestLongestDependencyChain(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "P",
+			    deps: ["Q"],
+			}
+
+			foo_module {
+			    name: "Q",
+			    deps: ["R"],
+			}
+
+			foo_module {
+			    name: "R",
+			    deps: ["S"],
+			}
+
+			foo_module {
+			    name: "S",
+			}
+
+			foo_module {
+			    name: "A",
+			    deps: ["C", "B"],
+			}
+
+			foo_module {
+			    name: "B",
+			    deps: ["C"],
+			}
+
+			foo_module {
+			    name: "C",
+			    deps: ["D"],
+			}
+
+			foo_module {
+			    name: "D",
+			}
+
+			foo_module {
+			    name: "E",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	var chain []string
+	for _, m := range ctx.LongestDependencyChain() {
+		chain = append(chain, m.Name())
+	}
+	if expected := []string{"A", "B", "C", "D"}; !reflect.DeepEqual(chain, expected) {
+		t.Errorf("expected chain %q, got %q", expected, chain)
+	}
+
+	histogram := ctx.DependencyDepthHistogram()
+	if expected := map[int]int{0: 3, 1: 2, 2: 2, 3: 2}; !reflect.DeepEqual(histogram, expected) {
+		t.Errorf("expected histogram %v, got %v", expected, histogram)
+	}
+}
+
+func TestLongestDependencyChainStable(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				foo_module {
+				    name: "A",
+				    deps: ["B", "C", "D", "E"],
+				}
+
+				foo_module {
+				    name: "B",
+				    deps: ["F"],
+				}
+
+				foo_module {
+				    name: "C",
+				    deps: ["F"],
+				}
+
+				foo_module {
+				    name: "D",
+				    deps: ["F"],
+				}
+
+				foo_module {
+				    name: "E",
+				    deps: ["F"],
+				}
+
+				foo_module {
+				    name: "F",
+				}
+
+				foo_module {
+				    name: "V",
+				}
+			`),
+		})
+
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+		ctx.RegisterBottomUpMutator("variants", func(mctx BottomUpMutatorContext) {
+			if mctx.ModuleName() == "V" {
+				mctx.CreateVariations("a", "b", "c", "d")
+			}
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+		if len(errs) > 0 {
+			t.Errorf("unexpected parse errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		_, errs = ctx.ResolveDependencies(nil)
+		if len(errs) > 0 {
+			t.Errorf("unexpected dep errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		var chain []string
+		for _, m := range ctx.LongestDependencyChain() {
+			chain = append(chain, m.Name())
+		}
+		if expected := []string{"A", "B", "F"}; !reflect.DeepEqual(chain, expected) {
+			t.Fatalf("run %d: expected chain %q, got %q", i, expected, chain)
+		}
+
+		// The variants of V don't depend on each other.
+		histogram := ctx.DependencyDepthHistogram()
+		if expected := map[int]int{0: 5, 1: 4, 2: 1}; !reflect.DeepEqual(histogram, expected) {
+			t.Fatalf("run %d: expected histogram %v, got %v", i, expected, histogram)
+		}
+	}
+}
+
+func TestLongestDependencyChainInterVariant(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B"],
+			}
+
+			foo_module {
+			    name: "B",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+	ctx.RegisterBottomUpMutator("arch", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "B" {
+			variants := mctx.CreateVariations("arm", "x86", "x86_64")
+			mctx.AddInterVariantDependency(nil, variants[2], variants[1])
+			mctx.AddInterVariantDependency(nil, variants[1], variants[0])
+		}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	// Dependencies added between variants of the same module are part of the chain, while A
+	// only depends on the first variant of B.
+	var chain []string
+	for _, m := range ctx.LongestDependencyChain() {
+		chain = append(chain, ctx.ModuleName(m)+":"+ctx.ModuleSubDir(m))
+	}
+	if expected := []string{"B:x86_64", "B:x86", "B:arm"}; !reflect.DeepEqual(chain, expected) {
+		t.Errorf("expected chain %q, got %q", expected, chain)
+	}
+
+	histogram := ctx.DependencyDepthHistogram()
+	if expected := map[int]int{0: 1, 1: 2, 2: 1}; !reflect.DeepEqual(histogram, expected) {
+		t.Errorf("expected histogram %v, got %v", expected, histogram)
+	}
+}
+
+func TestExplainVariant(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+
+			foo_module {
+			    name: "B",
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("arch", func(mctx BottomUpMutatorContext) {
+		mctx.CreateVariations("arm64", "x86")
+	})
+	ctx.RegisterBottomUpMutator("unused", func(mctx BottomUpMutatorContext) {})
+	ctx.RegisterBottomUpMutator("link", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "A" {
+			mctx.CreateLocalVariations("shared", "static")
+		}
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	module := func(name, variant string) Module {
+		for _, m := range ctx.modulesFromName(name, nil) {
+			if m.variantName == variant {
+				return m.logicModule
+			}
+		}
+		t.Fatalf("no variant %q of module %q", variant, name)
+		return nil
+	}
+
+	expected := []string{
+		"arch=arm64 (created by arch)",
+		"link=shared (created by link as a local variation)",
+	}
+	if got := ctx.ExplainVariant(module("A", "arm64_shared")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect explanation for A:\nwant: %q\n got: %q", expected, got)
+	}
+
+	expected = []string{"arch=x86 (created by arch)"}
+	if got := ctx.ExplainVariant(module("B", "x86")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect explanation for B:\nwant: %q\n got: %q", expected, got)
+	}
+}
+
+var (
+	compileRule = pctx.StaticRule("compile", RuleParams{
+		Command: "cc ${flags} -c ${in} -o ${out}",
+	}, "flags")
+	archiveRule = pctx.StaticRule("archive", RuleParams{
+		Command:        "ar ${out} @${out}.rsp",
+		Rspfile:        "${out}.rsp",
+		RspfileContent: "${in}",
+	})
+)
+
+type compileCommandsModule struct {
+	fooModule
+}
+
+func newCompileCommandsModule() (Module, []interface{}) {
+	m := &compileCommandsModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *compileCommandsModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Variable(pctx, "name", ctx.ModuleName())
+	ctx.Build(pctx, BuildParams{
+		Rule:    compileRule,
+		Outputs: []string{"${buildActionsOutDir}/${name}.o"},
+		Inputs:  []string{"${name}.c"},
+		Args: map[string]string{
+			"flags": "-DNAME=${name}",
+		},
+	})
+	ctx.Build(pctx, BuildParams{
+		Rule:    compileRule,
+		Outputs: []string{"${buildActionsOutDir}/${name}_noflags.o"},
+		Inputs:  []string{"${name}_noflags.c"},
+	})
+	ctx.Build(pctx, BuildParams{
+		Rule:    archiveRule,
+		Outputs: []string{"${buildActionsOutDir}/${name}.a"},
+		Inputs:  []string{"${buildActionsOutDir}/${name}.o", "${buildActionsOutDir}/${name}_noflags.o"},
+	})
+	ctx.Build(pctx, BuildParams{
+		Rule:    touchRule,
+		Outputs: []string{"${name}.stamp"},
+		Inputs:  []string{"${name}.c"},
+	})
+}
+
+func TestWriteCompileCommands(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			compile_commands_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("compile_commands_module", newCompileCommandsModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	ruleFilter := func(rule string) bool {
+		return rule == "g.blueprint.compile" || rule == "g.blueprint.archive"
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteCompileCommands(buf, ruleFilter); err != ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady, got %v", err)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if err := ctx.WriteCompileCommands(buf, ruleFilter); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var commands []CompileCommand
+	if err := json.Unmarshal(buf.Bytes(), &commands); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, buf.String())
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []CompileCommand{
+		{Directory: dir, Command: "cc -DNAME=A -c A.c -o out/A.o", File: "A.c"},
+		{Directory: dir, Command: "cc  -c A_noflags.c -o out/A_noflags.o", File: "A_noflags.c"},
+		{Directory: dir, Command: "ar out/A.a out/A.o out/A_noflags.o", File: "out/A.o"},
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("incorrect compile commands:\nwant: %+v\n got: %+v", expected, commands)
+	}
+}
+
+func TestRegisterFileValidator(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+		"dir/Blueprints": []byte(`
+			foo_module {
+			    name: "B",
+			}
+
+			bar_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterFileValidator(func(file *parser.File) []error {
+		var errs []error
+		for _, def := range file.Defs {
+			if module, ok := def.(*parser.Module); ok && module.Type == "bar_module" {
+				errs = append(errs, &BlueprintError{
+					Err: fmt.Errorf("module type %q is not allowed", module.Type),
+					Pos: module.TypePos,
+				})
+			}
+		}
+		return errs
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+
+	expectedErrs := []error{
+		errors.New(`dir/Blueprints:6:4: module type "bar_module" is not allowed`),
+	}
+	if fmt.Sprintf("%s", expectedErrs) != fmt.Sprintf("%s", errs) {
+		t.Errorf("incorrect errors; expected:\n%s\ngot:\n%s", expectedErrs, errs)
+	}
+
+	if ctx.modulesFromName("A", nil) == nil {
+		t.Errorf("expected module A in a valid file to be added")
+	}
+	for _, name := range []string{"B", "C"} {
+		if ctx.modulesFromName(name, nil) != nil {
+			t.Errorf("unexpected module %s in a rejected file", name)
+		}
+	}
+}
+
+type defaultBuildParamsModule struct {
+	fooModule
+}
+
+func newDefaultBuildParamsModule() (Module, []interface{}) {
+	m := &defaultBuildParamsModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *defaultBuildParamsModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.SetDefaultBuildParams(BuildParams{
+		Rule:        compileRule,
+		Description: "compile",
+		Implicits:   []string{"header.h"},
+		Args: map[string]string{
+			"flags": "-O2",
+		},
+	})
+	ctx.Build(pctx, BuildParams{
+		Outputs:   []string{"a.o"},
+		Inputs:    []string{"a.c"},
+		Implicits: []string{"a.h"},
+	})
+	ctx.Build(pctx, BuildParams{
+		Description: "compile b",
+		Outputs:     []string{"b.o"},
+		Inputs:      []string{"b.c"},
+		Args: map[string]string{
+			"flags": "-O0",
+		},
+	})
+	ctx.Build(pctx, BuildParams{
+		Rule:    touchRule,
+		Outputs: []string{"c.stamp"},
+		Inputs:  []string{"c.c"},
+	})
+}
+
+func TestSetDefaultBuildParams(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			default_build_params_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("default_build_params_module", newDefaultBuildParamsModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	actions, err := ctx.ModuleBuildActions(ctx.modulesFromName("A", nil)[0].logicModule)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []BuildAction{
+		{
+			Rule:        "g.blueprint.compile",
+			Inputs:      []string{"a.c", "a.h", "header.h"},
+			Outputs:     []string{"a.o"},
+			Description: "compile",
+		},
+		{
+			Rule:        "g.blueprint.compile",
+			Inputs:      []string{"b.c", "header.h"},
+			Outputs:     []string{"b.o"},
+			Description: "compile b",
+		},
+		{
+			Rule:        "g.blueprint.touch",
+			Inputs:      []string{"c.c", "header.h"},
+			Outputs:     []string{"c.stamp"},
+			Description: "compile",
+		},
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("incorrect build actions:\nwant: %q\n got: %q", expected, actions)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteCompileCommands(buf, func(string) bool { return true }); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var commands []CompileCommand
+	if err := json.Unmarshal(buf.Bytes(), &commands); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, buf.String())
+	}
+	var got []string
+	for _, command := range commands {
+		got = append(got, command.Command)
+	}
+	expectedCommands := []string{
+		"cc -O2 -c a.c -o a.o",
+		"cc -O0 -c b.c -o b.o",
+		"touch c.stamp",
+	}
+	if !reflect.DeepEqual(got, expectedCommands) {
+		t.Errorf("incorrect commands:\nwant: %q\n got: %q", expectedCommands, got)
+	}
+}
+
+type depOrderModule struct {
+	fooModule
+	visited []string
+}
+
+func newDepOrderModule() (Module, []interface{}) {
+	m := &depOrderModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *depOrderModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.VisitDirectDeps(func(dep Module) {
+		m.visited = append(m.visited, ctx.OtherModuleName(dep))
+	})
+}
+
+func TestAddDependencyAtFront(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			dep_order_module {
+			    name: "A",
+			    deps: ["B"],
+			}
+
+			dep_order_module {
+			    name: "B",
+			}
+
+			dep_order_module {
+			    name: "C",
+			}
+
+			dep_order_module {
+			    name: "D",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("dep_order_module", newDepOrderModule)
+	ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+
+	var added []string
+	ctx.RegisterBottomUpMutator("front", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "A" {
+			for _, dep := range mctx.AddDependencyAtFront(mctx.Module(), nil, "C", "D") {
+				added = append(added, dep.Name())
+			}
+		}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if expected := []string{"C", "D"}; !reflect.DeepEqual(added, expected) {
+		t.Errorf("expected AddDependencyAtFront to return %q, got %q", expected, added)
+	}
+
+	a := ctx.modulesFromName("A", nil)[0].logicModule.(*depOrderModule)
+	if expected := []string{"C", "D", "B"}; !reflect.DeepEqual(a.visited, expected) {
+		t.Errorf("expected VisitDirectDeps to visit %q, got %q", expected, a.visited)
+	}
+}
+
+func TestAddOptionalDependency(t *testing.T) {
+	for _, allowMissing := range []bool{false, true} {
+		t.Run(fmt.Sprintf("allowMissing=%t", allowMissing), func(t *testing.T) {
+			ctx := newContext()
+			ctx.MockFileSystem(map[string][]byte{
+				"Blueprints": []byte(`
+					dep_order_module {
+					    name: "A",
+					}
+
+					dep_order_module {
+					    name: "B",
+					}
+				`),
+			})
+
+			ctx.RegisterModuleType("dep_order_module", newDepOrderModule)
+
+			var added []Module
+			ctx.RegisterBottomUpMutator("optional", func(mctx BottomUpMutatorContext) {
+				if mctx.ModuleName() == "A" {
+					added = mctx.AddOptionalDependency(mctx.Module(), nil, "nonexistent", "B")
+				}
+			})
+			ctx.SetAllowMissingDependencies(allowMissing)
+
+			_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+			if len(errs) > 0 {
+				t.Errorf("unexpected parse errors:")
+				for _, err := range errs {
+					t.Errorf("  %s", err)
+				}
+				t.FailNow()
+			}
+
+			_, errs = ctx.PrepareBuildActions(nil)
+			if len(errs) > 0 {
+				t.Errorf("unexpected errors:")
+				for _, err := range errs {
+					t.Errorf("  %s", err)
+				}
+				t.FailNow()
+			}
+
+			info := ctx.modulesFromName("A", nil)[0]
+			b := ctx.modulesFromName("B", nil)[0].logicModule
+			if expected := []Module{nil, b}; !reflect.DeepEqual(added, expected) {
+				t.Errorf("expected AddOptionalDependency to return %v, got %v", expected, added)
+			}
+
+			a := info.logicModule.(*depOrderModule)
+			if expected := []string{"B"}; !reflect.DeepEqual(a.visited, expected) {
+				t.Errorf("expected VisitDirectDeps to visit %q, got %q", expected, a.visited)
+			}
+			if len(info.missingDeps) > 0 {
+				t.Errorf("expected no missing dependencies, got %q", info.missingDeps)
+			}
+		})
+	}
+}
+
+func TestRootModules(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B"],
+			}
+
+			foo_module {
+			    name: "B",
+			}
+
+			foo_module {
+			    name: "C",
+			}
+
+			foo_module {
+			    name: "D",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+	ctx.RegisterBottomUpMutator("arch", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "C" || mctx.ModuleName() == "D" {
+			variants := mctx.CreateVariations("arm", "x86")
+			if mctx.ModuleName() == "D" {
+				mctx.AddInterVariantDependency(nil, variants[1], variants[0])
+			}
+		}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	var roots []string
+	for _, m := range ctx.RootModules() {
+		roots = append(roots, ctx.ModuleName(m)+":"+ctx.ModuleSubDir(m))
+	}
+	if expected := []string{"A:", "C:arm", "C:x86", "D:x86"}; !reflect.DeepEqual(roots, expected) {
+		t.Errorf("expected roots %q, got %q", expected, roots)
+	}
+}
+
+func TestHighFanoutWarningThreshold(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "C", "D"],
+			}
+
+			foo_module {
+			    name: "B",
+			    deps: ["C", "D"],
+			}
+
+			foo_module {
+			    name: "C",
+			}
+
+			foo_module {
+			    name: "D",
+			}
+
+			foo_module {
+			    name: "E",
+			}
+		`),
+	})
+
+	ctx.SetHighFanoutWarningThreshold(2)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+	ctx.RegisterBottomUpMutator("variants", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "E" {
+			mctx.CreateVariations("a", "b", "c", "d")
+		}
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	var warnings []string
+	for _, warning := range ctx.Warnings() {
+		warnings = append(warnings, warning.Error())
+	}
+
+	expectedWarnings := []string{
+		`Blueprints:2:4: module "A": has 3 direct dependencies, more than the threshold of 2`,
+	}
+	if !reflect.DeepEqual(warnings, expectedWarnings) {
+		t.Errorf("incorrect warnings:\nwant: %q\n got: %q", expectedWarnings, warnings)
+	}
+}
+
+func TestSortDependencies(t *testing.T) {
+	// writeBuildFile returns the Ninja file for a module A whose dependencies are added in the
+	// given order, as mutators running in a different order might add them.
+	writeBuildFile := func(t *testing.T, sortDeps bool, depOrder ...string) string {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				callback_module {
+				    name: "A",
+				}
+
+				callback_module {
+				    name: "B",
+				}
+
+				callback_module {
+				    name: "C",
+				}
+			`),
+		})
+
+		ctx.RegisterModuleType("callback_module", func() (Module, []interface{}) {
+			m := &generateCallbackModule{}
+			m.generate = func(mctx ModuleContext) {
+				var inputs []string
+				mctx.VisitDirectDeps(func(dep Module) {
+					inputs = append(inputs, mctx.OtherModuleName(dep))
+				})
+				mctx.Build(pctx, BuildParams{
+					Rule:    Phony,
+					Outputs: []string{mctx.ModuleName()},
+					Inputs:  inputs,
+				})
+			}
+			return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+		})
+		ctx.RegisterBottomUpMutator("deps", func(mctx BottomUpMutatorContext) {
+			if mctx.ModuleName() == "A" {
+				mctx.AddDependency(mctx.Module(), nil, depOrder...)
+			}
+		})
+		ctx.SetSortDependencies(sortDeps)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+		if len(errs) > 0 {
+			t.Errorf("unexpected parse errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		_, errs = ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteBuildFile(buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return buf.String()
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		first := writeBuildFile(t, true, "C", "B")
+		second := writeBuildFile(t, true, "B", "C")
+		if first != second {
+			t.Errorf("expected identical build files:\n%s\n---\n%s", first, second)
+		}
+		if expected := "build A: phony B C\n"; !strings.Contains(first, expected) {
+			t.Errorf("expected %q in:\n%s", expected, first)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		out := writeBuildFile(t, false, "C", "B")
+		if expected := "build A: phony C B\n"; !strings.Contains(out, expected) {
+			t.Errorf("expected %q in:\n%s", expected, out)
+		}
+	})
+}
@@ -97,6 +97,29 @@ func (c *Context) Globs() []GlobPath {
 	return globs
 }
 
+// GlobDependencies returns the deduplicated, sorted union of the directories
+// that were searched by every glob performed so far.  The results of the globs
+// may change if the contents of any of these directories change.
+func (c *Context) GlobDependencies() []string {
+	c.globLock.Lock()
+	defer c.globLock.Unlock()
+
+	depSet := make(map[string]bool)
+	for _, g := range c.globs {
+		for _, dep := range g.Deps {
+			depSet[dep] = true
+		}
+	}
+
+	deps := make([]string, 0, len(depSet))
+	for dep := range depSet {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+
+	return deps
+}
+
 func globToString(pattern string) string {
 	ret := ""
 	for _, c := range pattern {
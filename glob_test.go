@@ -14,7 +14,10 @@
 
 package blueprint
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestGlobCache(t *testing.T) {
 	ctx := NewContext()
@@ -53,3 +56,24 @@ func TestGlobCache(t *testing.T) {
 		t.Error(`expected ["a/a"], got`, matches)
 	}
 }
+
+func TestGlobDependencies(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": nil,
+		"a/a":        nil,
+		"a/b/c":      nil,
+		"d/e":        nil,
+	})
+
+	for _, pattern := range []string{"a/*", "a/**/*", "d/*"} {
+		if _, err := ctx.glob(pattern, nil); err != nil {
+			t.Error("unexpected error", err)
+		}
+	}
+
+	expected := []string{"a", "a/b", "d"}
+	if deps := ctx.GlobDependencies(); !reflect.DeepEqual(deps, expected) {
+		t.Errorf("expected %q, got %q", expected, deps)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import "fmt"
+
+// A GraphSnapshot is a copy of the module graph of a Context, created by SnapshotGraph, that can
+// be restored into the same Context with RestoreGraph.
+type GraphSnapshot struct {
+	groups        []*moduleGroup
+	savedGroups   []moduleGroup
+	savedModules  map[*moduleInfo]moduleInfo
+	modulesSorted []*moduleInfo
+
+	preSingletonActionDefs []localBuildActions
+	singletonActionDefs    []localBuildActions
+
+	liveVariables map[Variable]*ninjaString
+	livePools     map[Pool]*poolDef
+	liveRules     map[Rule]*ruleDef
+	liveConfig    interface{}
+
+	ninjaBuildDir      *ninjaString
+	requiredNinjaMajor int
+	requiredNinjaMinor int
+	requiredNinjaMicro int
+	subninjas          []string
+	warnings           []error
+}
+
+// SnapshotGraph returns a copy of the module graph, including a copy of the properties of every
+// module, that can later be passed to RestoreGraph to return the Context to its current state.
+// This is expensive, as every module is cloned.
+//
+// Snapshots are only supported between ResolveDependencies and PrepareBuildActions, so that
+// PrepareBuildActions can be run multiple times, for example with different configs, on the same
+// resolved graph.  SnapshotGraph panics if the dependencies have not been resolved.  Any state
+// that modules or singletons keep outside of their properties is not captured.
+func (c *Context) SnapshotGraph() *GraphSnapshot {
+	if !c.dependenciesReady {
+		panic(fmt.Errorf("SnapshotGraph called before ResolveDependencies"))
+	}
+
+	s := &GraphSnapshot{
+		groups:        append([]*moduleGroup(nil), c.moduleGroups...),
+		savedModules:  make(map[*moduleInfo]moduleInfo),
+		modulesSorted: append([]*moduleInfo(nil), c.modulesSorted...),
+
+		liveVariables: make(map[Variable]*ninjaString),
+		livePools:     make(map[Pool]*poolDef),
+		liveRules:     make(map[Rule]*ruleDef),
+		liveConfig:    c.liveGlobals.config,
+
+		ninjaBuildDir:      c.ninjaBuildDir,
+		requiredNinjaMajor: c.requiredNinjaMajor,
+		requiredNinjaMinor: c.requiredNinjaMinor,
+		requiredNinjaMicro: c.requiredNinjaMicro,
+		subninjas:          append([]string(nil), c.subninjas...),
+		warnings:           append([]error(nil), c.warnings...),
+	}
+
+	for _, group := range c.moduleGroups {
+		s.savedGroups = append(s.savedGroups, copyModuleGroup(group))
+		for _, module := range group.modules {
+			s.savedModules[module] = c.copyModuleInfo(module)
+		}
+	}
+
+	for _, info := range c.preSingletonInfo {
+		s.preSingletonActionDefs = append(s.preSingletonActionDefs, copyLocalBuildActions(&info.actionDefs))
+	}
+	for _, info := range c.singletonInfo {
+		s.singletonActionDefs = append(s.singletonActionDefs, copyLocalBuildActions(&info.actionDefs))
+	}
+
+	for k, v := range c.liveGlobals.variables {
+		s.liveVariables[k] = v
+	}
+	for k, v := range c.liveGlobals.pools {
+		s.livePools[k] = v
+	}
+	for k, v := range c.liveGlobals.rules {
+		s.liveRules[k] = v
+	}
+
+	return s
+}
+
+// RestoreGraph returns the module graph of the Context to the state it was in when SnapshotGraph
+// returned s, discarding any changes made since, including any build actions generated by
+// PrepareBuildActions.  The modules are cloned again from the snapshot, so s can be restored
+// multiple times.  Module objects obtained from the Context before RestoreGraph is called are no
+// longer part of the graph.
+func (c *Context) RestoreGraph(s *GraphSnapshot) {
+	c.moduleGroups = append([]*moduleGroup(nil), s.groups...)
+	for i, group := range s.groups {
+		*group = copyModuleGroup(&s.savedGroups[i])
+	}
+
+	c.moduleInfo = make(map[Module]*moduleInfo)
+	for module, saved := range s.savedModules {
+		*module = c.copyModuleInfo(&saved)
+		c.moduleInfo[module.logicModule] = module
+	}
+	c.modulesSorted = append([]*moduleInfo(nil), s.modulesSorted...)
+	c.cachedSortedModuleGroups = nil
+
+	for i, info := range c.preSingletonInfo {
+		info.actionDefs = copyLocalBuildActions(&s.preSingletonActionDefs[i])
+	}
+	for i, info := range c.singletonInfo {
+		info.actionDefs = copyLocalBuildActions(&s.singletonActionDefs[i])
+	}
+
+	c.liveGlobals = newLiveTracker(s.liveConfig)
+	for k, v := range s.liveVariables {
+		c.liveGlobals.variables[k] = v
+	}
+	for k, v := range s.livePools {
+		c.liveGlobals.pools[k] = v
+	}
+	for k, v := range s.liveRules {
+		c.liveGlobals.rules[k] = v
+	}
+
+	c.ninjaBuildDir = s.ninjaBuildDir
+	c.requiredNinjaMajor = s.requiredNinjaMajor
+	c.requiredNinjaMinor = s.requiredNinjaMinor
+	c.requiredNinjaMicro = s.requiredNinjaMicro
+	c.subninjas = append([]string(nil), s.subninjas...)
+	c.warnings = append([]error(nil), s.warnings...)
+
+	c.dependenciesReady = true
+	c.buildActionsReady = false
+}
+
+func copyModuleGroup(group *moduleGroup) moduleGroup {
+	ret := *group
+	ret.modules = append([]*moduleInfo(nil), group.modules...)
+	return ret
+}
+
+// copyModuleInfo returns a copy of module with a clone of its logic module and properties.  The
+// pointers to other modules in the copy still refer to the original moduleInfos.
+func (c *Context) copyModuleInfo(module *moduleInfo) moduleInfo {
+	ret := *module
+	ret.logicModule, ret.properties = c.cloneLogicModule(module)
+	if module.variant != nil {
+		ret.variant = module.variant.clone()
+	}
+	if module.dependencyVariant != nil {
+		ret.dependencyVariant = module.dependencyVariant.clone()
+	}
+	ret.directDeps = append([]depInfo(nil), module.directDeps...)
+	ret.missingDeps = append([]string(nil), module.missingDeps...)
+	ret.reverseDeps = append([]*moduleInfo(nil), module.reverseDeps...)
+	ret.forwardDeps = append([]*moduleInfo(nil), module.forwardDeps...)
+	ret.splitModules = append([]*moduleInfo(nil), module.splitModules...)
+	ret.actionDefs = copyLocalBuildActions(&module.actionDefs)
+	return ret
+}
+
+func copyLocalBuildActions(actionDefs *localBuildActions) localBuildActions {
+	return localBuildActions{
+		variables: append([]*localVariable(nil), actionDefs.variables...),
+		pools:     append([]*localPool(nil), actionDefs.pools...),
+		rules:     append([]*localRule(nil), actionDefs.rules...),
+		buildDefs: append([]*buildDef(nil), actionDefs.buildDefs...),
+	}
+}
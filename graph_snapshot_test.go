@@ -0,0 +1,139 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type snapshotModule struct {
+	fooModule
+}
+
+func newSnapshotModule() (Module, []interface{}) {
+	m := &snapshotModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (s *snapshotModule) GenerateBuildActions(ctx ModuleContext) {
+	s.properties.Foo += "-generated"
+	ctx.Build(pctx, BuildParams{
+		Rule:    touchRule,
+		Outputs: []string{ctx.ModuleName() + "." + ctx.Config().(string)},
+	})
+}
+
+func TestSnapshotGraph(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			snapshot_module {
+			    name: "A",
+			    foo: "a",
+			    deps: ["B"],
+			}
+
+			snapshot_module {
+			    name: "B",
+			    foo: "b",
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("deps", blueprintDepsMutator)
+	ctx.RegisterModuleType("snapshot_module", newSnapshotModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	snapshot := ctx.SnapshotGraph()
+
+	build := func(config string) string {
+		_, errs := ctx.PrepareBuildActions(config)
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteBuildFile(buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return buf.String()
+	}
+
+	foos := func() string {
+		var s []string
+		ctx.VisitAllModules(func(m Module) {
+			s = append(s, m.(*snapshotModule).properties.Foo)
+		})
+		return strings.Join(s, " ")
+	}
+
+	first := build("x")
+	if !strings.Contains(first, "build A.x:") || !strings.Contains(first, "build B.x:") {
+		t.Errorf("missing outputs in:\n%s", first)
+	}
+	if got := foos(); got != "a-generated b-generated" {
+		t.Errorf("unexpected properties after PrepareBuildActions: %q", got)
+	}
+
+	ctx.RestoreGraph(snapshot)
+	if got := foos(); got != "a b" {
+		t.Errorf("unexpected properties after RestoreGraph: %q", got)
+	}
+
+	second := build("y")
+	if !strings.Contains(second, "build A.y:") || strings.Contains(second, "A.x") {
+		t.Errorf("unexpected outputs in:\n%s", second)
+	}
+
+	var deps []string
+	ctx.VisitAllModules(func(m Module) {
+		if ctx.ModuleName(m) == "A" {
+			ctx.VisitDirectDeps(m, func(dep Module) {
+				deps = append(deps, ctx.ModuleName(dep))
+			})
+		}
+	})
+	if len(deps) != 1 || deps[0] != "B" {
+		t.Errorf("expected A to depend on B after RestoreGraph, got %q", deps)
+	}
+
+	ctx.RestoreGraph(snapshot)
+	if third := build("x"); third != first {
+		t.Errorf("expected restoring twice to produce the same build file:\n%s\ngot:\n%s", first, third)
+	}
+}
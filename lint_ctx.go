@@ -0,0 +1,133 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+)
+
+// A LintContext is passed to a lint pass registered with Context.RegisterLintPass.  It gives
+// read-only access to the modules and the build actions they generated, and collects the findings
+// reported by the pass.
+type LintContext interface {
+	Config() interface{}
+
+	// Name returns the name the lint pass was registered with.
+	Name() string
+
+	ModuleName(module Module) string
+	ModuleDir(module Module) string
+	ModuleSubDir(module Module) string
+	ModuleType(module Module) string
+	BlueprintFile(module Module) string
+
+	// ModuleBuildActions returns the build statements generated by a module, with all Ninja
+	// variables expanded.
+	ModuleBuildActions(module Module) ([]BuildAction, error)
+
+	VisitAllModules(visit func(Module))
+	VisitAllModulesIf(pred func(Module) bool, visit func(Module))
+	VisitDirectDeps(module Module, visit func(Module))
+	VisitDepsDepthFirst(module Module, visit func(Module))
+
+	// Report records a finding about module, positioned at its definition in its Blueprints file.
+	Report(module Module, msg string)
+
+	// Reportf is like Report, but formats the message with fmt.Sprintf.
+	Reportf(module Module, format string, args ...interface{})
+}
+
+// A LintFinding is a problem reported about a module by a lint pass.
+type LintFinding struct {
+	ModuleError
+
+	Pass string // the name the lint pass was registered with
+}
+
+var _ LintContext = (*lintContext)(nil)
+
+type lintContext struct {
+	name    string
+	context *Context
+	config  interface{}
+
+	findings []*LintFinding
+}
+
+func (l *lintContext) Config() interface{} {
+	return l.config
+}
+
+func (l *lintContext) Name() string {
+	return l.name
+}
+
+func (l *lintContext) ModuleName(logicModule Module) string {
+	return l.context.ModuleName(logicModule)
+}
+
+func (l *lintContext) ModuleDir(logicModule Module) string {
+	return l.context.ModuleDir(logicModule)
+}
+
+func (l *lintContext) ModuleSubDir(logicModule Module) string {
+	return l.context.ModuleSubDir(logicModule)
+}
+
+func (l *lintContext) ModuleType(logicModule Module) string {
+	return l.context.ModuleType(logicModule)
+}
+
+func (l *lintContext) BlueprintFile(logicModule Module) string {
+	return l.context.BlueprintFile(logicModule)
+}
+
+func (l *lintContext) ModuleBuildActions(logicModule Module) ([]BuildAction, error) {
+	return l.context.ModuleBuildActions(logicModule)
+}
+
+func (l *lintContext) VisitAllModules(visit func(Module)) {
+	l.context.VisitAllModules(visit)
+}
+
+func (l *lintContext) VisitAllModulesIf(pred func(Module) bool, visit func(Module)) {
+	l.context.VisitAllModulesIf(pred, visit)
+}
+
+func (l *lintContext) VisitDirectDeps(module Module, visit func(Module)) {
+	l.context.VisitDirectDeps(module, visit)
+}
+
+func (l *lintContext) VisitDepsDepthFirst(module Module, visit func(Module)) {
+	l.context.VisitDepsDepthFirst(module, visit)
+}
+
+func (l *lintContext) Report(logicModule Module, msg string) {
+	module := l.context.moduleInfo[logicModule]
+	l.findings = append(l.findings, &LintFinding{
+		ModuleError: ModuleError{
+			BlueprintError: BlueprintError{
+				Err: fmt.Errorf("%s", msg),
+				Pos: module.pos,
+			},
+			module: module,
+		},
+		Pass: l.name,
+	})
+}
+
+func (l *lintContext) Reportf(logicModule Module, format string, args ...interface{}) {
+	l.Report(logicModule, fmt.Sprintf(format, args...))
+}
@@ -27,6 +27,8 @@ type liveTracker struct {
 	variables map[Variable]*ninjaString
 	pools     map[Pool]*poolDef
 	rules     map[Rule]*ruleDef
+
+	hasValidations bool // Set if any build definition has validations.
 }
 
 func newLiveTracker(config interface{}) *liveTracker {
@@ -68,6 +70,14 @@ func (l *liveTracker) AddBuildDefDeps(def *buildDef) error {
 		return err
 	}
 
+	err = l.addNinjaStringListDeps(def.Validations)
+	if err != nil {
+		return err
+	}
+	if len(def.Validations) > 0 {
+		l.hasValidations = true
+	}
+
 	for _, value := range def.Variables {
 		err = l.addNinjaStringDeps(value)
 		if err != nil {
@@ -201,6 +211,17 @@ func (l *liveTracker) RemoveVariableIfLive(v Variable) bool {
 	return isLive
 }
 
+func (l *liveTracker) RemovePoolIfLive(p Pool) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	_, isLive := l.pools[p]
+	if isLive {
+		delete(l.pools, p)
+	}
+	return isLive
+}
+
 func (l *liveTracker) RemoveRuleIfLive(r Rule) bool {
 	l.Lock()
 	defer l.Unlock()
@@ -17,6 +17,9 @@ package blueprint
 import (
 	"fmt"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"text/scanner"
 
 	"github.com/google/blueprint/pathtools"
@@ -103,6 +106,35 @@ type Module interface {
 	GenerateBuildActions(ModuleContext)
 }
 
+// A PropertyDefaultsModule is a Module whose factory sets default values for
+// some of its properties.  When a property error is reported for a property
+// that was not set in the Blueprints file or by the property structs passed to
+// CreateModule but has a default, the error names the source of the default
+// instead of implying that the module definition set the value.
+type PropertyDefaultsModule interface {
+	Module
+
+	// PropertyDefaults is called once after the module's properties are read
+	// from its Blueprints file or set by CreateModule, and returns a map from
+	// the names of properties, as passed to PropertyErrorf, that have default
+	// values to where each default comes from.
+	PropertyDefaults() map[string]PropertyDefault
+}
+
+// A PropertyDefault describes where the default value of a property of a
+// PropertyDefaultsModule comes from.
+type PropertyDefault struct {
+	// Source is a short description of where the default comes from, for
+	// example the name of the factory or of a defaults module.
+	Source string
+
+	// Property optionally names the property of the module definition that
+	// applied the default, for example "defaults".  If it is set in the
+	// Blueprints file, errors for the defaulted property are reported at its
+	// position instead of at the module.
+	Property string
+}
+
 // A DynamicDependerModule is a Module that may add dependencies that do not
 // appear in its "deps" property.  Any Module that implements this interface
 // will have its DynamicDependencies method called by the Context that created
@@ -126,12 +158,28 @@ type BaseModuleContext interface {
 	ModuleType() string
 	Config() interface{}
 
+	// IsAutoGenerated returns true if the current module was created by a mutator calling
+	// CreateModule instead of being defined in a Blueprints file.
+	IsAutoGenerated() bool
+
+	// CreatedBy returns the module that was being mutated when the current module was created by
+	// a call to CreateModule, or the module passed to the CreateModule method of a whole graph
+	// mutator, or nil if the module was defined in a Blueprints file.
+	CreatedBy() Module
+
 	ContainsProperty(name string) bool
 	Errorf(pos scanner.Position, fmt string, args ...interface{})
 	ModuleErrorf(fmt string, args ...interface{})
 	PropertyErrorf(property, fmt string, args ...interface{})
 	Failed() bool
 
+	// Warningf, ModuleWarningf and PropertyWarningf report a diagnostic in the same way as the
+	// corresponding Errorf methods, but the diagnostic is collected in Context.Warnings and does
+	// not fail the build.
+	Warningf(pos scanner.Position, fmt string, args ...interface{})
+	ModuleWarningf(fmt string, args ...interface{})
+	PropertyWarningf(property, fmt string, args ...interface{})
+
 	// GlobWithDeps returns a list of files and directories that match the
 	// specified pattern but do not match any of the patterns in excludes.
 	// Any directories will have a '/' suffix.  It also adds efficient
@@ -172,15 +220,33 @@ type ModuleContext interface {
 
 	ModuleSubDir() string
 
+	// VariantOutDir returns the directory in which this variant of the module should write its
+	// intermediate files, built from the pattern passed to Context.SetVariantOutDirPattern.  By
+	// default it is ModuleDir() joined with ModuleSubDir().
+	VariantOutDir() string
+
 	Variable(pctx PackageContext, name, value string)
+	Pool(pctx PackageContext, name string, depth int) Pool
 	Rule(pctx PackageContext, name string, params RuleParams, argNames ...string) Rule
 	Build(pctx PackageContext, params BuildParams)
 
+	// SetDefaultBuildParams sets parameters that are merged into the parameters of every following
+	// call to Build.  The lists and args of the defaults are added to those passed to Build, with
+	// the args passed to Build taking precedence and default args that the rule does not accept
+	// ignored, and the other defaults are only used if the corresponding parameter passed to Build
+	// is empty.
+	SetDefaultBuildParams(params BuildParams)
+
 	PrimaryModule() Module
 	FinalModule() Module
 	VisitAllModuleVariants(visit func(Module))
 
 	GetMissingDependencies() []string
+
+	// SetAsDefaultTarget marks the primary output of the module, which is the first output of the
+	// first build statement it adds, as a target of the Ninja "default" statement written at the
+	// end of the build file.
+	SetAsDefaultTarget()
 }
 
 var _ BaseModuleContext = (*baseModuleContext)(nil)
@@ -190,6 +256,7 @@ type baseModuleContext struct {
 	config         interface{}
 	module         *moduleInfo
 	errs           []error
+	warnings       []error
 	visitingParent *moduleInfo
 	visitingDep    depInfo
 	ninjaFileDeps  []string
@@ -207,6 +274,25 @@ func (d *baseModuleContext) ModuleType() string {
 	return d.module.typeName
 }
 
+func (d *baseModuleContext) IsAutoGenerated() bool {
+	return d.module.createdBy != nil
+}
+
+func (d *baseModuleContext) CreatedBy() Module {
+	creator := d.module.createdBy
+	if creator == nil {
+		return nil
+	}
+
+	// The creating module may have been split into variants since, in which case use the
+	// first variant.
+	for creator.logicModule == nil && len(creator.splitModules) > 0 {
+		creator = creator.splitModules[0]
+	}
+
+	return creator.logicModule
+}
+
 func (d *baseModuleContext) ContainsProperty(name string) bool {
 	_, ok := d.module.propertyPos[name]
 	return ok
@@ -226,6 +312,12 @@ func (d *baseModuleContext) error(err error) {
 	}
 }
 
+func (d *baseModuleContext) warning(err error) {
+	if err != nil {
+		d.warnings = append(d.warnings, err)
+	}
+}
+
 func (d *baseModuleContext) Errorf(pos scanner.Position,
 	format string, args ...interface{}) {
 
@@ -238,34 +330,72 @@ func (d *baseModuleContext) Errorf(pos scanner.Position,
 func (d *baseModuleContext) ModuleErrorf(format string,
 	args ...interface{}) {
 
-	d.error(&ModuleError{
+	d.error(d.moduleError(format, args...))
+}
+
+func (d *baseModuleContext) PropertyErrorf(property, format string,
+	args ...interface{}) {
+
+	d.error(d.propertyError(property, format, args...))
+}
+
+func (d *baseModuleContext) Warningf(pos scanner.Position,
+	format string, args ...interface{}) {
+
+	d.warning(&BlueprintError{
+		Err: fmt.Errorf(format, args...),
+		Pos: pos,
+	})
+}
+
+func (d *baseModuleContext) ModuleWarningf(format string,
+	args ...interface{}) {
+
+	d.warning(d.moduleError(format, args...))
+}
+
+func (d *baseModuleContext) PropertyWarningf(property, format string,
+	args ...interface{}) {
+
+	d.warning(d.propertyError(property, format, args...))
+}
+
+func (d *baseModuleContext) moduleError(format string, args ...interface{}) *ModuleError {
+	return &ModuleError{
 		BlueprintError: BlueprintError{
 			Err: fmt.Errorf(format, args...),
 			Pos: d.module.pos,
 		},
 		module: d.module,
-	})
+	}
 }
 
-func (d *baseModuleContext) PropertyErrorf(property, format string,
-	args ...interface{}) {
+func (d *baseModuleContext) propertyError(property, format string,
+	args ...interface{}) *PropertyError {
 
 	pos := d.module.propertyPos[property]
+	err := fmt.Errorf(format, args...)
 
 	if !pos.IsValid() {
 		pos = d.module.pos
+		if def, ok := d.module.propertyDefaults[property]; ok {
+			err = fmt.Errorf("%s (default from %s)", err, def.Source)
+			if defPos, ok := d.module.propertyPos[def.Property]; ok {
+				pos = defPos
+			}
+		}
 	}
 
-	d.error(&PropertyError{
+	return &PropertyError{
 		ModuleError: ModuleError{
 			BlueprintError: BlueprintError{
-				Err: fmt.Errorf(format, args...),
+				Err: err,
 				Pos: pos,
 			},
 			module: d.module,
 		},
 		property: property,
-	})
+	}
 }
 
 func (d *baseModuleContext) Failed() bool {
@@ -292,6 +422,8 @@ type moduleContext struct {
 	scope              *localScope
 	actionDefs         localBuildActions
 	handledMissingDeps bool
+	defaultTarget      bool
+	defaultBuildParams BuildParams
 }
 
 func (m *baseModuleContext) OtherModuleName(logicModule Module) string {
@@ -495,6 +627,10 @@ func (m *moduleContext) ModuleSubDir() string {
 	return m.module.variantName
 }
 
+func (m *moduleContext) VariantOutDir() string {
+	return m.context.variantOutDir(m.module)
+}
+
 func (m *moduleContext) Variable(pctx PackageContext, name, value string) {
 	m.scope.ReparentTo(pctx)
 
@@ -506,6 +642,19 @@ func (m *moduleContext) Variable(pctx PackageContext, name, value string) {
 	m.actionDefs.variables = append(m.actionDefs.variables, v)
 }
 
+func (m *moduleContext) Pool(pctx PackageContext, name string, depth int) Pool {
+	m.scope.ReparentTo(pctx)
+
+	p, err := m.scope.AddLocalPool(name, &PoolParams{Depth: depth})
+	if err != nil {
+		panic(err)
+	}
+
+	m.actionDefs.pools = append(m.actionDefs.pools, p)
+
+	return p
+}
+
 func (m *moduleContext) Rule(pctx PackageContext, name string,
 	params RuleParams, argNames ...string) Rule {
 
@@ -524,6 +673,8 @@ func (m *moduleContext) Rule(pctx PackageContext, name string,
 func (m *moduleContext) Build(pctx PackageContext, params BuildParams) {
 	m.scope.ReparentTo(pctx)
 
+	params = mergeBuildParams(params, m.defaultBuildParams)
+
 	def, err := parseBuildParams(m.scope, &params)
 	if err != nil {
 		panic(err)
@@ -532,6 +683,59 @@ func (m *moduleContext) Build(pctx PackageContext, params BuildParams) {
 	m.actionDefs.buildDefs = append(m.actionDefs.buildDefs, def)
 }
 
+func (m *moduleContext) SetDefaultBuildParams(params BuildParams) {
+	m.defaultBuildParams = params
+}
+
+// mergeBuildParams returns params with the lists and args of defaults added to
+// it, and its empty fields set from defaults.  Args of defaults that are not
+// arguments of the rule are ignored.
+func mergeBuildParams(params, defaults BuildParams) BuildParams {
+	mergeString := func(s *string, def string) {
+		if *s == "" {
+			*s = def
+		}
+	}
+	mergeList := func(l *[]string, def []string) {
+		if len(def) > 0 {
+			*l = append(append([]string(nil), *l...), def...)
+		}
+	}
+
+	mergeString(&params.Comment, defaults.Comment)
+	mergeString(&params.Depfile, defaults.Depfile)
+	mergeString(&params.Description, defaults.Description)
+	if params.Deps == DepsNone {
+		params.Deps = defaults.Deps
+	}
+	if params.Rule == nil {
+		params.Rule = defaults.Rule
+	}
+	mergeList(&params.Outputs, defaults.Outputs)
+	mergeList(&params.ImplicitOutputs, defaults.ImplicitOutputs)
+	mergeList(&params.Inputs, defaults.Inputs)
+	mergeList(&params.Implicits, defaults.Implicits)
+	mergeList(&params.OrderOnly, defaults.OrderOnly)
+	mergeList(&params.Validations, defaults.Validations)
+	if len(defaults.Args) > 0 && params.Rule != nil {
+		args := make(map[string]string, len(params.Args)+len(defaults.Args))
+		for k, v := range defaults.Args {
+			// Default args are shared by statements using different rules, so
+			// only pass the ones the rule accepts.
+			if params.Rule.isArg(k) {
+				args[k] = v
+			}
+		}
+		for k, v := range params.Args {
+			args[k] = v
+		}
+		params.Args = args
+	}
+	params.Optional = params.Optional || defaults.Optional
+
+	return params
+}
+
 func (m *moduleContext) PrimaryModule() Module {
 	return m.module.group.modules[0].logicModule
 }
@@ -549,6 +753,10 @@ func (m *moduleContext) GetMissingDependencies() []string {
 	return m.module.missingDeps
 }
 
+func (m *moduleContext) SetAsDefaultTarget() {
+	m.defaultTarget = true
+}
+
 //
 // MutatorContext
 //
@@ -558,6 +766,7 @@ type mutatorContext struct {
 	name          string
 	reverseDeps   []reverseDep
 	rename        []rename
+	alias         []alias
 	replace       []replace
 	newVariations []*moduleInfo // new variants of existing modules
 	newModules    []*moduleInfo // brand new modules
@@ -589,6 +798,7 @@ type TopDownMutatorContext interface {
 	OtherModuleDependencyTag(m Module) DependencyTag
 
 	CreateModule(ModuleFactory, ...interface{})
+	CreateModuleWithType(ModuleFactory, string, ...interface{})
 
 	GetDirectDepWithTag(name string, tag DependencyTag) Module
 	GetDirectDep(name string) (Module, DependencyTag)
@@ -604,6 +814,17 @@ type BottomUpMutatorContext interface {
 	baseMutatorContext
 
 	AddDependency(module Module, tag DependencyTag, name ...string)
+
+	// AddDependencyAtFront is like AddDependency, but inserts the dependencies before the
+	// existing dependencies of the module, in the order given, and returns the modules that were
+	// added.  See mutatorContext.AddDependencyAtFront for details.
+	AddDependencyAtFront(module Module, tag DependencyTag, name ...string) []Module
+
+	// AddOptionalDependency is like AddDependency, but silently skips dependencies on modules
+	// that don't exist, even if missing dependencies are not allowed.  It returns the modules that
+	// were added, with nil for each dependency that was skipped.
+	AddOptionalDependency(module Module, tag DependencyTag, name ...string) []Module
+
 	AddReverseDependency(module Module, tag DependencyTag, name string)
 	CreateVariations(...string) []Module
 	CreateLocalVariations(...string) []Module
@@ -612,6 +833,12 @@ type BottomUpMutatorContext interface {
 	AddFarVariationDependencies([]Variation, DependencyTag, ...string)
 	AddInterVariantDependency(tag DependencyTag, from, to Module)
 	ReplaceDependencies(string)
+	CreateAlias(aliasName string)
+
+	// FarDepProperty returns the value of a property of another module, for example a
+	// dependency added with AddFarVariationDependencies, if that module has already been
+	// visited by the current mutator.  See mutatorContext.FarDepProperty for details.
+	FarDepProperty(dep Module, property string) (interface{}, bool)
 }
 
 // A Mutator function is called for each Module, and can use
@@ -711,11 +938,60 @@ func (mctx *mutatorContext) Module() Module {
 func (mctx *mutatorContext) AddDependency(module Module, tag DependencyTag, deps ...string) {
 	for _, dep := range deps {
 		modInfo := mctx.context.moduleInfo[module]
-		errs := mctx.context.addDependency(modInfo, tag, dep)
+		_, errs := mctx.context.addDependency(modInfo, tag, dep, -1)
+		if len(errs) > 0 {
+			mctx.errs = append(mctx.errs, errs...)
+		}
+	}
+}
+
+// Add dependencies to the given module before its existing dependencies, keeping the order of
+// deps, and return the modules that were added, leaving out any dependencies that could not be
+// found.  The order of dependencies is only meaningful to modules that visit their dependencies
+// in order, for example to build a link command line with VisitDirectDeps.  Like AddDependency,
+// this does not affect the ordering of the current mutator pass.
+func (mctx *mutatorContext) AddDependencyAtFront(module Module, tag DependencyTag,
+	deps ...string) []Module {
+
+	modInfo := mctx.context.moduleInfo[module]
+	var ret []Module
+	for _, dep := range deps {
+		depModule, errs := mctx.context.addDependency(modInfo, tag, dep, len(ret))
+		if len(errs) > 0 {
+			mctx.errs = append(mctx.errs, errs...)
+			continue
+		}
+		if depModule != nil {
+			ret = append(ret, depModule.logicModule)
+		}
+	}
+	return ret
+}
+
+// Add dependencies to the given module on the modules with the given names if they exist, and
+// return the modules that were added, with nil for each name that doesn't match a module.  Missing
+// modules are neither reported as errors nor returned by GetMissingDependencies, regardless of
+// SetAllowMissingDependencies, but a module that exists without a matching variant is still an
+// error.  Like AddDependency, this does not affect the ordering of the current mutator pass.
+func (mctx *mutatorContext) AddOptionalDependency(module Module, tag DependencyTag,
+	deps ...string) []Module {
+
+	modInfo := mctx.context.moduleInfo[module]
+	ret := make([]Module, len(deps))
+	for i, dep := range deps {
+		if mctx.context.modulesFromName(dep, modInfo.namespace()) == nil {
+			continue
+		}
+		depModule, errs := mctx.context.addDependency(modInfo, tag, dep, -1)
 		if len(errs) > 0 {
 			mctx.errs = append(mctx.errs, errs...)
+			continue
+		}
+		if depModule != nil {
+			ret[i] = depModule.logicModule
 		}
 	}
+	return ret
 }
 
 // Add a dependency from the destination to the given module.
@@ -777,6 +1053,61 @@ func (mctx *mutatorContext) AddInterVariantDependency(tag DependencyTag, from, t
 	mctx.context.addInterVariantDependency(mctx.module, tag, from, to)
 }
 
+// FarDepProperty returns the value of the property of dep with the given name, for example
+// "nested.foo", if dep has already been visited by the current mutator.  The value is the
+// property struct field, so strings, bools and int64s are returned as pointers that may be
+// nil, and it must not be modified.
+//
+// Modules are visited in parallel, and a bottom-up mutator is only guaranteed to have
+// visited the modules that were dependencies of the current module when the mutator started.
+// Dependencies added by the current mutator, including far variation dependencies, may not
+// have been visited yet, in which case FarDepProperty returns false instead of reading
+// properties that the mutator may be modifying concurrently.  Callers must handle false
+// without making the result depend on the order modules were visited in.  FarDepProperty
+// also returns false if dep is not a module or has no property with the given name.
+func (mctx *mutatorContext) FarDepProperty(dep Module, property string) (interface{}, bool) {
+	module := mctx.context.moduleInfo[dep]
+	if module == nil || module == mctx.module {
+		return nil, false
+	}
+
+	if atomic.LoadInt32(&module.finishedMutator) != mctx.context.mutatorGeneration {
+		return nil, false
+	}
+
+	return propertyValue(module.properties, property)
+}
+
+// propertyValue returns the value of the field for the property with the given name in a
+// list of pointers to property structs.
+func propertyValue(properties []interface{}, property string) (interface{}, bool) {
+	names := strings.Split(property, ".")
+	for _, props := range properties {
+		v := reflect.ValueOf(props)
+		for _, name := range names {
+			for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+				if v.IsNil() {
+					break
+				}
+				v = v.Elem()
+			}
+			if v.Kind() != reflect.Struct {
+				v = reflect.Value{}
+				break
+			}
+			v = v.FieldByName(proptools.FieldNameForProperty(name))
+			if !v.IsValid() {
+				break
+			}
+		}
+		if v.IsValid() && v.CanInterface() {
+			return v.Interface(), true
+		}
+	}
+
+	return nil, false
+}
+
 // ReplaceDependencies replaces all dependencies on the identical variant of the module with the
 // specified name with the current variant of this module.  Replacements don't take effect until
 // after the mutator pass is finished.
@@ -802,20 +1133,45 @@ func (mctx *mutatorContext) Rename(name string) {
 	mctx.rename = append(mctx.rename, rename{mctx.module.group, name})
 }
 
+// CreateAlias adds an alias name for all variants of the current module.  After this mutator pass
+// is complete the alias can be used anywhere the module's name could be used to add a dependency.
+// Aliases are resolved when the dependency is added, and the variant is selected exactly as if the
+// module's real name had been used.  It is an error for the alias to conflict with the name of
+// another module or an alias for another module, or for the Context's NameInterface not to
+// support aliases.
+func (mctx *mutatorContext) CreateAlias(aliasName string) {
+	mctx.alias = append(mctx.alias, alias{mctx.module.group, aliasName})
+}
+
+// PropertiesFrom wraps a property struct passed to CreateModule together with the module the
+// properties were taken from, for example a defaults module.  The properties it sets are applied
+// like those of an unwrapped property struct, but when property provenance tracking is enabled
+// PropertyProvenance reports them as set by Source instead of by the module calling CreateModule.
+type PropertiesFrom struct {
+	Source     Module
+	Properties interface{}
+}
+
 // Create a new module by calling the factory method for the specified moduleType, and apply
 // the specified property structs to it as if the properties were set in a blueprint file.
+// Property structs may be wrapped in PropertiesFrom to record which module they came from.
 func (mctx *mutatorContext) CreateModule(factory ModuleFactory, props ...interface{}) {
+	mctx.CreateModuleWithType(factory, "", props...)
+}
+
+// CreateModuleWithType is like CreateModule, but also takes the name the factory was registered
+// with, which is reported as the type of the new module.
+func (mctx *mutatorContext) CreateModuleWithType(factory ModuleFactory, typeName string,
+	props ...interface{}) {
+
 	module := mctx.context.newModule(factory)
 
+	module.typeName = typeName
 	module.relBlueprintsFile = mctx.module.relBlueprintsFile
 	module.pos = mctx.module.pos
+	module.createdBy = mctx.module
 
-	for _, p := range props {
-		err := proptools.AppendMatchingProperties(module.properties, p, nil)
-		if err != nil {
-			panic(err)
-		}
-	}
+	mctx.context.appendCreatedModuleProperties(module, mctx.module, props)
 
 	mctx.newModules = append(mctx.newModules, module)
 }
@@ -48,6 +48,13 @@ type NamespaceMarker struct {
 func (m *NamespaceMarker) namespace(Namespace) {
 }
 
+// A NamedNamespace is a Namespace that has a stable name, for example the path
+// of the directory that defines it, that tools can use to identify it.
+type NamedNamespace interface {
+	Namespace
+	NamespaceName() string
+}
+
 // A NameInterface tells how to locate modules by name.
 // There should only be one name interface per Context, but potentially many namespaces
 type NameInterface interface {
@@ -95,11 +102,13 @@ func (ctx *namespaceContextImpl) ModulePath() string {
 // a SimpleNameInterface just stores all modules in a map based on name
 type SimpleNameInterface struct {
 	modules map[string]ModuleGroup
+	aliases map[string]ModuleGroup
 }
 
 func NewSimpleNameInterface() *SimpleNameInterface {
 	return &SimpleNameInterface{
 		modules: make(map[string]ModuleGroup),
+		aliases: make(map[string]ModuleGroup),
 	}
 }
 
@@ -112,6 +121,12 @@ func (s *SimpleNameInterface) NewModule(ctx NamespaceContext, group ModuleGroup,
 				"       %s <-- previous definition here", name, group.modules[0].pos),
 		}
 	}
+	if alias, present := s.aliases[name]; present {
+		return nil, []error{
+			fmt.Errorf("module %q conflicts with an alias for module %q\n"+
+				"       %s <-- aliased module defined here", name, alias.name, alias.modules[0].pos),
+		}
+	}
 
 	s.modules[name] = group
 
@@ -120,6 +135,9 @@ func (s *SimpleNameInterface) NewModule(ctx NamespaceContext, group ModuleGroup,
 
 func (s *SimpleNameInterface) ModuleFromName(moduleName string, namespace Namespace) (group ModuleGroup, found bool) {
 	group, found = s.modules[moduleName]
+	if !found {
+		group, found = s.aliases[moduleName]
+	}
 	return group, found
 }
 
@@ -133,6 +151,12 @@ func (s *SimpleNameInterface) Rename(oldName string, newName string, namespace N
 				oldName, newName, existingGroup.modules[0].pos),
 		}
 	}
+	if alias, exists := s.aliases[newName]; exists {
+		return []error{
+			fmt.Errorf("renaming module %q to %q conflicts with an alias for module %q",
+				oldName, newName, alias.name),
+		}
+	}
 
 	group, exists := s.modules[oldName]
 	if !exists {
@@ -144,6 +168,46 @@ func (s *SimpleNameInterface) Rename(oldName string, newName string, namespace N
 	return nil
 }
 
+// Alias adds an alias that ModuleFromName will resolve to the given group.  It returns errors if
+// the alias conflicts with an existing module or alias.  It is called for the aliases created by
+// BottomUpMutatorContext.CreateAlias, which requires the NameInterface to implement it.
+func (s *SimpleNameInterface) Alias(aliasName string, group ModuleGroup, namespace Namespace) []error {
+	if existingGroup, exists := s.modules[aliasName]; exists {
+		return []error{
+			// seven characters at the start of the second line to align with the string "error: "
+			fmt.Errorf("alias %q for module %q conflicts with existing module\n"+
+				"       %s <-- existing module defined here",
+				aliasName, group.name, existingGroup.modules[0].pos),
+		}
+	}
+	if existingAlias, exists := s.aliases[aliasName]; exists {
+		if existingAlias.moduleGroup == group.moduleGroup {
+			return nil
+		}
+		return []error{
+			fmt.Errorf("alias %q for module %q conflicts with existing alias for module %q",
+				aliasName, group.name, existingAlias.name),
+		}
+	}
+
+	s.aliases[aliasName] = group
+	return nil
+}
+
+// RemoveModule removes the given module and any aliases for it, so that ModuleFromName no longer
+// finds it and a new module may be created with the same name.  It is called by
+// Context.ReparseChangedFiles, which requires the NameInterface to implement it.
+func (s *SimpleNameInterface) RemoveModule(group ModuleGroup, namespace Namespace) {
+	if existing, exists := s.modules[group.name]; exists && existing.moduleGroup == group.moduleGroup {
+		delete(s.modules, group.name)
+	}
+	for name, alias := range s.aliases {
+		if alias.moduleGroup == group.moduleGroup {
+			delete(s.aliases, name)
+		}
+	}
+}
+
 func (s *SimpleNameInterface) AllModules() []ModuleGroup {
 	groups := make([]ModuleGroup, 0, len(s.modules))
 	for _, group := range s.modules {
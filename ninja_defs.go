@@ -87,6 +87,7 @@ type BuildParams struct {
 	Inputs          []string          // The list of explicit input dependencies.
 	Implicits       []string          // The list of implicit input dependencies.
 	OrderOnly       []string          // The list of order-only dependencies.
+	Validations     []string          // The list of validations to run when the outputs are built.
 	Args            map[string]string // The variable/value pairs to set.
 	Optional        bool              // Skip outputting a default statement
 }
@@ -187,6 +188,10 @@ func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
 		r.Variables["restat"] = simpleNinjaString("true")
 	}
 
+	if (params.Rspfile != "") != (params.RspfileContent != "") {
+		return nil, fmt.Errorf("Rspfile and RspfileContent params must be set together")
+	}
+
 	if params.Rspfile != "" {
 		value, err = parseNinjaString(scope, params.Rspfile)
 		if err != nil {
@@ -257,6 +262,7 @@ type buildDef struct {
 	Inputs          []*ninjaString
 	Implicits       []*ninjaString
 	OrderOnly       []*ninjaString
+	Validations     []*ninjaString
 	Args            map[Variable]*ninjaString
 	Variables       map[string]*ninjaString
 	Optional        bool
@@ -314,6 +320,11 @@ func parseBuildParams(scope scope, params *BuildParams) (*buildDef,
 		return nil, fmt.Errorf("error parsing OrderOnly param: %s", err)
 	}
 
+	b.Validations, err = parseNinjaStrings(scope, params.Validations)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Validations param: %s", err)
+	}
+
 	b.Optional = params.Optional
 
 	if params.Depfile != "" {
@@ -373,6 +384,7 @@ func (b *buildDef) WriteTo(nw *ninjaWriter, pkgNames map[*packageContext]string)
 		explicitDeps  = valueList(b.Inputs, pkgNames, inputEscaper)
 		implicitDeps  = valueList(b.Implicits, pkgNames, inputEscaper)
 		orderOnlyDeps = valueList(b.OrderOnly, pkgNames, inputEscaper)
+		validations   = valueList(b.Validations, pkgNames, inputEscaper)
 	)
 
 	if b.RuleDef != nil {
@@ -380,7 +392,8 @@ func (b *buildDef) WriteTo(nw *ninjaWriter, pkgNames map[*packageContext]string)
 		orderOnlyDeps = append(valueList(b.RuleDef.CommandOrderOnly, pkgNames, inputEscaper), orderOnlyDeps...)
 	}
 
-	err := nw.Build(comment, rule, outputs, implicitOuts, explicitDeps, implicitDeps, orderOnlyDeps)
+	err := nw.Build(comment, rule, outputs, implicitOuts, explicitDeps, implicitDeps, orderOnlyDeps,
+		validations)
 	if err != nil {
 		return err
 	}
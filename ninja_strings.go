@@ -270,6 +270,11 @@ func parseNinjaStrings(scope scope, strs []string) ([]*ninjaString,
 	return result, nil
 }
 
+// Variables returns the variables referenced by the ninjaString, in order.
+func (n *ninjaString) Variables() []Variable {
+	return n.variables
+}
+
 func (n *ninjaString) Value(pkgNames map[*packageContext]string) string {
 	return n.ValueWithEscaper(pkgNames, defaultEscaper)
 }
@@ -286,13 +291,32 @@ func (n *ninjaString) ValueWithEscaper(pkgNames map[*packageContext]string,
 }
 
 func (n *ninjaString) Eval(variables map[Variable]*ninjaString) (string, error) {
+	return n.evalLayers(variableLayers{variables})
+}
+
+// variableLayers is a list of variable values in which the values in earlier
+// maps take precedence over the values in later maps, which allows adding
+// values to a large map without copying it.
+type variableLayers []map[Variable]*ninjaString
+
+func (l variableLayers) lookup(v Variable) (*ninjaString, bool) {
+	for _, variables := range l {
+		if value, ok := variables[v]; ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// evalLayers is like Eval, but looks up the values of the variables in layers.
+func (n *ninjaString) evalLayers(layers variableLayers) (string, error) {
 	str := n.strings[0]
 	for i, v := range n.variables {
-		variable, ok := variables[v]
+		variable, ok := layers.lookup(v)
 		if !ok {
 			return "", fmt.Errorf("no such global variable: %s", v)
 		}
-		value, err := variable.Eval(variables)
+		value, err := variable.evalLayers(layers)
 		if err != nil {
 			return "", err
 		}
@@ -16,6 +16,7 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"text/scanner"
 )
@@ -405,6 +406,120 @@ func (x *Bool) Type() Type {
 	return BoolType
 }
 
+// A Select is a conditional expression written as select(VAR, {"a": value, default: value}).  The
+// value of VAR is not known to the parser, it is bound by the tool that calls Evaluate.
+type Select struct {
+	KeywordPos  scanner.Position
+	Variable    string
+	VariablePos scanner.Position
+	LBracePos   scanner.Position
+	RBracePos   scanner.Position
+	RParenPos   scanner.Position
+	Cases       []*SelectCase
+}
+
+// A SelectCase is one branch of a Select, which is either selected when the variable is bound to
+// Key or, if Default is set, when no other case matches.
+type SelectCase struct {
+	Key      string
+	KeyPos   scanner.Position
+	Default  bool
+	ColonPos scanner.Position
+	Value    Expression
+}
+
+func (c *SelectCase) Pos() scanner.Position { return c.KeyPos }
+func (c *SelectCase) End() scanner.Position { return c.Value.End() }
+
+func (c *SelectCase) String() string {
+	key := "default"
+	if !c.Default {
+		key = strconv.Quote(c.Key)
+	}
+	return fmt.Sprintf("%s@%s: %s", key, c.ColonPos, c.Value)
+}
+
+func (x *Select) Pos() scanner.Position { return x.KeywordPos }
+func (x *Select) End() scanner.Position { return endPos(x.RParenPos, 1) }
+
+func (x *Select) Copy() Expression {
+	ret := *x
+	ret.Cases = make([]*SelectCase, len(x.Cases))
+	for i, c := range x.Cases {
+		newCase := *c
+		newCase.Value = c.Value.Copy()
+		ret.Cases[i] = &newCase
+	}
+	return &ret
+}
+
+// Eval returns the Select itself, as the value of a Select can only be resolved by calling
+// Evaluate with bindings for its variable.
+func (x *Select) Eval() Expression {
+	return x
+}
+
+func (x *Select) String() string {
+	caseStrings := make([]string, len(x.Cases))
+	for i, c := range x.Cases {
+		caseStrings[i] = c.String()
+	}
+	return fmt.Sprintf("select(%s, {%s})@%s", x.Variable, strings.Join(caseStrings, ", "),
+		x.KeywordPos)
+}
+
+func (x *Select) Type() Type {
+	return x.Cases[0].Value.Type()
+}
+
+// Evaluate returns the value of the case whose key matches the value bound to the Select's variable
+// in bindings, or the value of the default case if no key matches.  If the selected value is itself
+// a Select it is evaluated with the same bindings.
+func (x *Select) Evaluate(bindings map[string]string) (Expression, error) {
+	var selected *SelectCase
+	if value, ok := bindings[x.Variable]; ok {
+		for _, c := range x.Cases {
+			if !c.Default && c.Key == value {
+				selected = c
+				break
+			}
+		}
+	}
+
+	if selected == nil {
+		for _, c := range x.Cases {
+			if c.Default {
+				selected = c
+				break
+			}
+		}
+	}
+
+	if selected == nil {
+		return nil, fmt.Errorf("%s: no case of select(%s) matches %q and there is no default",
+			x.KeywordPos, x.Variable, bindings[x.Variable])
+	}
+
+	if s, ok := selected.Value.(*Select); ok {
+		return s.Evaluate(bindings)
+	}
+	return selected.Value, nil
+}
+
+// AllValues returns the values of every case of the Select without evaluating it, including the
+// values of the cases of any nested Selects.
+func (x *Select) AllValues() []Expression {
+	var values []Expression
+	for _, c := range x.Cases {
+		if s, ok := c.Value.(*Select); ok {
+			values = append(values, s.AllValues()...)
+		} else {
+			values = append(values, c.Value)
+		}
+	}
+	return values
+}
+
 type CommentGroup struct {
 	Comments []*Comment
 }
@@ -0,0 +1,69 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import "fmt"
+
+// CheckDuplicateProperties returns a pair of ParseErrors for each property that is defined more
+// than once in the module, one at the duplicate definition and one at the previous definition.
+// Properties of nested maps, including maps inside lists, are checked as well and are reported
+// using their full dotted name.
+func CheckDuplicateProperties(m *Module) []error {
+	return checkDuplicateProperties("", m.Properties)
+}
+
+func checkDuplicateProperties(prefix string, properties []*Property) (errs []error) {
+	seen := make(map[string]*Property)
+	for _, property := range properties {
+		name := prefix + property.Name
+		if first, present := seen[property.Name]; present {
+			errs = append(errs, &ParseError{
+				Err: fmt.Errorf("property %q already defined", name),
+				Pos: property.ColonPos,
+			})
+			errs = append(errs, &ParseError{
+				Err: fmt.Errorf("<-- previous definition here"),
+				Pos: first.ColonPos,
+			})
+		} else {
+			seen[property.Name] = property
+		}
+
+		errs = append(errs, checkDuplicatePropertiesInValue(name+".", property.Value)...)
+	}
+
+	return errs
+}
+
+func checkDuplicatePropertiesInValue(prefix string, value Expression) []error {
+	switch v := value.(type) {
+	case *Map:
+		return checkDuplicateProperties(prefix, v.Properties)
+	case *List:
+		var errs []error
+		for _, elem := range v.Values {
+			errs = append(errs, checkDuplicatePropertiesInValue(prefix, elem)...)
+		}
+		return errs
+	case *Select:
+		var errs []error
+		for _, c := range v.Cases {
+			errs = append(errs, checkDuplicatePropertiesInValue(prefix, c.Value)...)
+		}
+		return errs
+	}
+
+	return nil
+}
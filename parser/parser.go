@@ -98,6 +98,29 @@ func Parse(filename string, r io.Reader, scope *Scope) (file *File, errs []error
 	return parse(p)
 }
 
+// ParseExpression parses a single Blueprint expression, for example a literal
+// value taken from the command line.  It is an error if anything other than
+// comments follows the expression.  Variables in the expression are not
+// evaluated.
+func ParseExpression(r io.Reader) (value Expression, errs []error) {
+	p := newParser(r, NewScope(nil))
+
+	defer func() {
+		if r := recover(); r != nil {
+			if r == errTooManyErrors {
+				value = nil
+				errs = p.errors
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	value = p.parseExpression()
+	p.accept(scanner.EOF)
+	return value, p.errors
+}
+
 type parser struct {
 	scanner  scanner.Scanner
 	tok      rune
@@ -125,6 +148,16 @@ func (p *parser) error(err error) {
 	if !pos.IsValid() {
 		pos = p.scanner.Pos()
 	}
+	p.errorAt(pos, err)
+}
+
+func (p *parser) errorf(format string, args ...interface{}) {
+	p.error(fmt.Errorf(format, args...))
+}
+
+// errorAt reports an error at a position other than the current token, for
+// errors about an expression that has already been parsed.
+func (p *parser) errorAt(pos scanner.Position, err error) {
 	err = &ParseError{
 		Err: err,
 		Pos: pos,
@@ -135,8 +168,8 @@ func (p *parser) error(err error) {
 	}
 }
 
-func (p *parser) errorf(format string, args ...interface{}) {
-	p.error(fmt.Errorf(format, args...))
+func (p *parser) errorfAt(pos scanner.Position, format string, args ...interface{}) {
+	p.errorAt(pos, fmt.Errorf(format, args...))
 }
 
 func (p *parser) accept(toks ...rune) bool {
@@ -475,6 +508,9 @@ func (p *parser) parseVariable() Expression {
 			Token:      text,
 		}
 	default:
+		if text == "select" && p.scanner.Peek() == '(' {
+			return p.parseSelect()
+		}
 		if p.eval {
 			if assignment, local := p.scope.Get(text); assignment == nil {
 				p.errorf("variable %q is not set", text)
@@ -496,6 +532,103 @@ func (p *parser) parseVariable() Expression {
 	return value
 }
 
+func (p *parser) parseSelect() *Select {
+	keywordPos := p.scanner.Position
+	p.accept(scanner.Ident)
+	if !p.accept('(') {
+		return nil
+	}
+
+	variable := p.scanner.TokenText()
+	variablePos := p.scanner.Position
+	if !p.accept(scanner.Ident, ',') {
+		return nil
+	}
+
+	lBracePos := p.scanner.Position
+	if !p.accept('{') {
+		return nil
+	}
+
+	var cases []*SelectCase
+	seen := make(map[string]bool)
+	hasDefault := false
+	for p.tok == scanner.String || p.tok == scanner.Ident {
+		c := &SelectCase{
+			KeyPos: p.scanner.Position,
+		}
+
+		if p.tok == scanner.Ident {
+			if text := p.scanner.TokenText(); text != "default" {
+				p.errorf("expected string or default, found %s", text)
+				return nil
+			}
+			if hasDefault {
+				p.errorf("duplicate default case in select")
+			}
+			c.Default = true
+			hasDefault = true
+		} else {
+			str, err := strconv.Unquote(p.scanner.TokenText())
+			if err != nil {
+				p.errorf("couldn't parse string: %s", err)
+				return nil
+			}
+			if seen[str] {
+				p.errorf("duplicate case %q in select", str)
+			}
+			c.Key = str
+			seen[str] = true
+		}
+		p.next()
+
+		c.ColonPos = p.scanner.Position
+		if !p.accept(':') {
+			return nil
+		}
+
+		c.Value = p.parseExpression()
+		cases = append(cases, c)
+
+		if p.tok != ',' {
+			// There was no comma, so the list is done.
+			break
+		}
+
+		p.accept(',')
+	}
+
+	rBracePos := p.scanner.Position
+	if !p.accept('}') {
+		return nil
+	}
+
+	rParenPos := p.scanner.Position
+	if !p.accept(')') {
+		return nil
+	}
+
+	if len(cases) == 0 {
+		p.errorf("select must have at least one case")
+		return nil
+	}
+
+	if p.eval {
+		p.errorf("select is not supported in evaluated Blueprints files")
+		return nil
+	}
+
+	return &Select{
+		KeywordPos:  keywordPos,
+		Variable:    variable,
+		VariablePos: variablePos,
+		LBracePos:   lBracePos,
+		RBracePos:   rBracePos,
+		RParenPos:   rParenPos,
+		Cases:       cases,
+	}
+}
+
 func (p *parser) parseStringValue() *String {
 	str, err := strconv.Unquote(p.scanner.TokenText())
 	if err != nil {
@@ -548,7 +681,7 @@ func (p *parser) parseListValue() *List {
 	for p.tok != ']' {
 		element := p.parseExpression()
 		if p.eval && element.Type() != StringType {
-			p.errorf("Expected string in list, found %s", element.Type().String())
+			p.errorfAt(element.Pos(), "Expected string in list, found %s", element.Type().String())
 			return nil
 		}
 		elements = append(elements, element)
@@ -631,6 +764,21 @@ func (s *Scope) Remove(name string) {
 	delete(s.inheritedVars, name)
 }
 
+// Names returns the sorted names of the variables in the scope, including inherited ones.
+func (s *Scope) Names() []string {
+	names := make([]string, 0, len(s.vars)+len(s.inheritedVars))
+	for k := range s.vars {
+		names = append(names, k)
+	}
+	for k := range s.inheritedVars {
+		if _, ok := s.vars[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (s *Scope) Get(name string) (*Assignment, bool) {
 	if a, ok := s.vars[name]; ok {
 		return a, true
@@ -1122,3 +1122,201 @@ func TestParserEndPos(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckDuplicateProperties(t *testing.T) {
+	input := `
+		foo {
+			name: "foo",
+			props: {
+				a: true,
+				b: [{
+					c: "x",
+					c: "y",
+				}],
+				a: false,
+			},
+			name: "bar",
+		}
+	`
+
+	file, errs := Parse("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+
+	errs = CheckDuplicateProperties(file.Defs[0].(*Module))
+
+	expected := []string{
+		`<input>:8:7: property "props.b.c" already defined`,
+		`<input>:7:7: <-- previous definition here`,
+		`<input>:10:6: property "props.a" already defined`,
+		`<input>:5:6: <-- previous definition here`,
+		`<input>:12:8: property "name" already defined`,
+		`<input>:3:8: <-- previous definition here`,
+	}
+
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect errors:\nwant: %q\n got: %q", expected, got)
+	}
+}
+
+func TestListElementErrorPos(t *testing.T) {
+	input := `
+		x = 1
+		foo {
+			srcs: [
+				"a",
+				2,
+				x,
+			],
+		}
+	`
+
+	_, errs := ParseAndEval("", bytes.NewBufferString(input), NewScope(nil))
+
+	expected := []string{
+		`<input>:6:5: Expected string in list, found int64`,
+	}
+
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect errors:\nwant: %q\n got: %q", expected, got)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	input := `
+		foo {
+			srcs: select(ARCH, {
+				"arm": ["arm.c"],
+				"x86": select(OS, {
+					"linux": ["x86_linux.c"],
+					default: ["x86.c"],
+				}),
+				default: [],
+			}),
+		}
+	`
+
+	file, errs := Parse("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+
+	sel := file.Defs[0].(*Module).Properties[0].Value.(*Select)
+
+	printValues := func(values []Expression) string {
+		var s []string
+		for _, v := range values {
+			b, _ := PrintExpression(v)
+			s = append(s, strings.TrimSpace(string(b)))
+		}
+		return strings.Join(s, " ")
+	}
+
+	if got, expected := printValues(sel.AllValues()), `["arm.c"] ["x86_linux.c"] ["x86.c"] []`; got != expected {
+		t.Errorf("incorrect AllValues, expected %s got %s", expected, got)
+	}
+
+	testCases := []struct {
+		bindings map[string]string
+		expected string
+	}{
+		{map[string]string{"ARCH": "arm"}, `["arm.c"]`},
+		{map[string]string{"ARCH": "x86", "OS": "linux"}, `["x86_linux.c"]`},
+		{map[string]string{"ARCH": "x86", "OS": "darwin"}, `["x86.c"]`},
+		{map[string]string{"ARCH": "mips"}, `[]`},
+		{nil, `[]`},
+	}
+
+	for _, testCase := range testCases {
+		value, err := sel.Evaluate(testCase.bindings)
+		if err != nil {
+			t.Errorf("unexpected error for %v: %s", testCase.bindings, err)
+			continue
+		}
+		if got := printValues([]Expression{value}); got != testCase.expected {
+			t.Errorf("incorrect value for %v, expected %s got %s", testCase.bindings, testCase.expected, got)
+		}
+	}
+
+	noDefault := &Select{
+		Variable: "ARCH",
+		Cases:    sel.Cases[:1],
+	}
+	if _, err := noDefault.Evaluate(map[string]string{"ARCH": "x86"}); err == nil {
+		t.Errorf("expected error evaluating select with no matching case")
+	}
+
+	_, errs = ParseAndEval("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) == 0 {
+		t.Errorf("expected error evaluating select")
+	}
+}
+
+func TestParseExpression(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+		errs     []string
+	}{
+		{
+			input:    `"abc"`,
+			expected: `"abc"`,
+		},
+		{
+			input:    `true`,
+			expected: `true`,
+		},
+		{
+			input:    `["a", "b"] // comment`,
+			expected: "[\n    \"a\",\n    \"b\",\n]",
+		},
+		{
+			input:    `{ foo: "bar", baz: [1] }`,
+			expected: "{\n    foo: \"bar\",\n    baz: [1],\n}",
+		},
+		{
+			input: `["a",`,
+			errs:  []string{`<input>:1:6: expected bool, list, or string value; found EOF`},
+		},
+		{
+			input: `"a" "b"`,
+			errs:  []string{`<input>:1:5: expected EOF, found String`},
+		},
+	}
+
+	for _, testCase := range testCases {
+		value, errs := ParseExpression(bytes.NewBufferString(testCase.input))
+
+		var errStrings []string
+		for _, err := range errs {
+			errStrings = append(errStrings, err.Error())
+		}
+		if !reflect.DeepEqual(errStrings, testCase.errs) {
+			t.Errorf("%s: expected errors %q, got %q", testCase.input, testCase.errs, errStrings)
+			continue
+		}
+		if len(errs) > 0 {
+			continue
+		}
+
+		b, err := PrintExpression(value)
+		if err != nil {
+			t.Errorf("%s: unexpected error printing: %s", testCase.input, err)
+			continue
+		}
+		if got := strings.TrimSpace(string(b)); got != testCase.expected {
+			t.Errorf("%s: expected %s, got %s", testCase.input, testCase.expected, got)
+		}
+	}
+}
@@ -68,6 +68,22 @@ func Print(file *File) ([]byte, error) {
 	return p.output, nil
 }
 
+// PrintOptions are optional changes that PrintWithOptions makes to the formatting of a file.
+type PrintOptions struct {
+	// SortMapKeys prints the properties of every module and map in alphabetical order instead of
+	// source order, as if SortMaps had been called on the file.
+	SortMapKeys bool
+}
+
+// PrintWithOptions is like Print, but applies the given options.  The positions of properties and
+// comments in file are modified in place by SortMapKeys.
+func PrintWithOptions(file *File, options PrintOptions) ([]byte, error) {
+	if options.SortMapKeys {
+		SortMaps(file)
+	}
+	return Print(file)
+}
+
 func PrintExpression(expression Expression) ([]byte, error) {
 	dummyFile := &File{}
 	p := newPrinter(dummyFile)
@@ -131,6 +147,8 @@ func (p *printer) printExpression(value Expression) {
 		p.printList(v.Values, v.LBracePos, v.RBracePos)
 	case *Map:
 		p.printMap(v)
+	case *Select:
+		p.printSelect(v)
 	default:
 		panic(fmt.Errorf("bad property type: %s", value.Type()))
 	}
@@ -172,6 +190,32 @@ func (p *printer) printMap(m *Map) {
 	p.printToken("}", m.RBracePos)
 }
 
+func (p *printer) printSelect(s *Select) {
+	p.printToken("select", s.KeywordPos)
+	p.printToken("(", noPos)
+	p.printToken(s.Variable, s.VariablePos)
+	p.printToken(",", noPos)
+	p.requestSpace()
+	p.printToken("{", s.LBracePos)
+	p.requestNewline()
+	p.indent(p.curIndent() + 4)
+	for _, c := range s.Cases {
+		if c.Default {
+			p.printToken("default", c.KeyPos)
+		} else {
+			p.printToken(strconv.Quote(c.Key), c.KeyPos)
+		}
+		p.printToken(":", c.ColonPos)
+		p.requestSpace()
+		p.printExpression(c.Value)
+		p.printToken(",", noPos)
+		p.requestNewline()
+	}
+	p.unindent(s.RBracePos)
+	p.printToken("}", s.RBracePos)
+	p.printToken(")", s.RParenPos)
+}
+
 func (p *printer) printOperator(operator *Operator) {
 	p.printOperatorInternal(operator, true)
 }
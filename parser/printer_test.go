@@ -358,6 +358,30 @@ test { // test
 
 // test
 
+}
+`,
+	},
+	{
+		input: `
+foo {
+    srcs: select(ARCH, {"arm": ["b.c", "a.c"], default: select(OS, {
+        "linux": ["c.c"],
+        default: [],
+    })}),
+}
+`,
+		output: `
+foo {
+    srcs: select(ARCH, {
+        "arm": [
+            "a.c",
+            "b.c",
+        ],
+        default: select(OS, {
+            "linux": ["c.c"],
+            default: [],
+        }),
+    }),
 }
 `,
 	},
@@ -395,3 +419,316 @@ func TestPrinter(t *testing.T) {
 		}
 	}
 }
+
+var trailingCommaTestCases = []struct {
+	name   string
+	input  string
+	output string
+}{
+	{
+		name: "single-line lists",
+		input: `
+foo {
+    a: ["x",],
+    b: ["x"],
+    c: [],
+}
+`,
+		output: `
+foo {
+    a: ["x"],
+    b: ["x"],
+    c: [],
+}
+`,
+	},
+	{
+		name: "multi-line lists",
+		input: `
+foo {
+    a: [
+        "x",
+        "y"
+    ],
+    b: [
+        "x"
+    ],
+    c: ["x", "y",],
+}
+`,
+		output: `
+foo {
+    a: [
+        "x",
+        "y",
+    ],
+    b: [
+        "x",
+    ],
+    c: [
+        "x",
+        "y",
+    ],
+}
+`,
+	},
+	{
+		name: "maps",
+		input: `
+foo {
+    a: {x: "y"},
+    b: {x: "y",},
+    c: {
+        x: "y"
+    },
+    d: {},
+}
+`,
+		output: `
+foo {
+    a: {
+        x: "y",
+    },
+    b: {
+        x: "y",
+    },
+    c: {
+        x: "y",
+    },
+    d: {},
+}
+`,
+	},
+	{
+		name: "module property lists",
+		input: `
+foo { name: "abc" }
+bar { name: "abc", }
+baz(name = "abc")
+qux(name = "abc",)
+`,
+		output: `
+foo {
+    name: "abc",
+}
+
+bar {
+    name: "abc",
+}
+
+baz {
+    name: "abc",
+}
+
+qux {
+    name: "abc",
+}
+`,
+	},
+	{
+		name: "selects",
+		input: `
+foo {
+    a: select(ARCH, {"arm": "x", default: "y"}),
+    b: select(ARCH, {"arm": "x", default: "y",}),
+}
+`,
+		output: `
+foo {
+    a: select(ARCH, {
+        "arm": "x",
+        default: "y",
+    }),
+    b: select(ARCH, {
+        "arm": "x",
+        default: "y",
+    }),
+}
+`,
+	},
+}
+
+func TestPrinterTrailingCommas(t *testing.T) {
+	for _, testCase := range trailingCommaTestCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			in := testCase.input[1:]
+			expected := testCase.output[1:]
+
+			// Printing the output again checks that it round trips unchanged.
+			for _, input := range []string{in, expected} {
+				file, errs := Parse("", bytes.NewBufferString(input), NewScope(nil))
+				if len(errs) != 0 {
+					t.Errorf("test case: %s", input)
+					t.Errorf("unexpected errors:")
+					for _, err := range errs {
+						t.Errorf("  %s", err)
+					}
+					t.FailNow()
+				}
+
+				got, err := Print(file)
+				if err != nil {
+					t.Errorf("test case: %s", input)
+					t.Errorf("unexpected error: %s", err)
+					t.FailNow()
+				}
+
+				if string(got) != expected {
+					t.Errorf("test case: %s", input)
+					t.Errorf("  expected: %s", expected)
+					t.Errorf("       got: %s", string(got))
+				}
+			}
+		})
+	}
+}
+
+var sortMapKeysTestCases = []struct {
+	input  string
+	output string
+}{
+	{
+		input: `
+foo {
+    name: "abc",
+    srcs: ["a.c"],
+    cflags: ["-Wall"],
+}
+`,
+		output: `
+foo {
+    cflags: ["-Wall"],
+    name: "abc",
+    srcs: ["a.c"],
+}
+`,
+	},
+	{
+		input: `
+foo {
+    name: "abc", // name
+    // Comment for
+    // zeta
+    zeta: {
+        z: true,
+        /* multi
+           line */
+        a: false,
+    },
+
+    // Comment for alpha
+    alpha: [
+        "b",
+        "a",
+    ],
+    // Trailing comment
+}
+`,
+		output: `
+foo {
+    // Comment for alpha
+    alpha: [
+        "b",
+        "a",
+    ],
+    name: "abc", // name
+    // Comment for
+    // zeta
+    zeta: {
+        /* multi
+           line */
+        a: false,
+        z: true,
+    },
+
+    // Trailing comment
+}
+`,
+	},
+	{
+		input: `
+x = {b: "b", a: "a"}
+`,
+		output: `
+x = {
+    a: "a",
+    b: "b",
+}
+`,
+	},
+}
+
+func TestPrintSortMapKeys(t *testing.T) {
+	for _, testCase := range sortMapKeysTestCases {
+		in := testCase.input[1:]
+		expected := testCase.output[1:]
+
+		r := bytes.NewBufferString(in)
+		file, errs := Parse("", r, NewScope(nil))
+		if len(errs) != 0 {
+			t.Errorf("test case: %s", in)
+			t.Errorf("unexpected errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		got, err := PrintWithOptions(file, PrintOptions{SortMapKeys: true})
+		if err != nil {
+			t.Errorf("test case: %s", in)
+			t.Errorf("unexpected error: %s", err)
+			t.FailNow()
+		}
+
+		if string(got) != expected {
+			t.Errorf("test case: %s", in)
+			t.Errorf("  expected: %s", expected)
+			t.Errorf("       got: %s", string(got))
+		}
+	}
+}
+
+func TestSortMapComments(t *testing.T) {
+	for _, testCase := range sortMapKeysTestCases {
+		in := testCase.input[1:]
+		expected := testCase.output[1:]
+
+		r := bytes.NewBufferString(in)
+		file, errs := Parse("", r, NewScope(nil))
+		if len(errs) != 0 {
+			t.Errorf("test case: %s", in)
+			t.Errorf("unexpected errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		// Sorting each map on its own must leave the comments in an order that the printer
+		// accepts, without calling SortMaps or SortLists.
+		for _, def := range file.Defs {
+			switch def := def.(type) {
+			case *Module:
+				SortMap(file, &def.Map)
+			case *Assignment:
+				if m, ok := def.OrigValue.(*Map); ok {
+					SortMap(file, m)
+				}
+			}
+		}
+
+		got, err := Print(file)
+		if err != nil {
+			t.Errorf("test case: %s", in)
+			t.Errorf("unexpected error: %s", err)
+			t.FailNow()
+		}
+
+		if string(got) != expected {
+			t.Errorf("test case: %s", in)
+			t.Errorf("  expected: %s", expected)
+			t.Errorf("       got: %s", string(got))
+		}
+	}
+}
@@ -87,6 +87,10 @@ func sortListsInValue(value Expression, file *File) {
 		}
 	case *List:
 		SortList(file, v)
+	case *Select:
+		for _, c := range v.Cases {
+			sortListsInValue(c.Value, file)
+		}
 	}
 }
 
@@ -134,6 +138,159 @@ func sortSubList(values []Expression, nextPos scanner.Position, file *File) {
 	}
 }
 
+// SortMaps sorts the properties of every module and map in file alphabetically by name.  Comments
+// on the lines before a property, and at the end of its last line, are moved with it.
+func SortMaps(file *File) {
+	for _, def := range file.Defs {
+		if assignment, ok := def.(*Assignment); ok {
+			sortMapsInValue(assignment.OrigValue, file)
+		} else if module, ok := def.(*Module); ok {
+			sortMap(file, &module.Map)
+		}
+	}
+	sort.Sort(commentsByOffset(file.Comments))
+}
+
+// SortMap sorts the properties of a map, and of any maps nested inside it, alphabetically by name.
+// The comments of file are kept sorted by their new positions.
+func SortMap(file *File, m *Map) {
+	sortMap(file, m)
+	sort.Sort(commentsByOffset(file.Comments))
+}
+
+func sortMap(file *File, m *Map) {
+	for _, prop := range m.Properties {
+		sortMapsInValue(prop.Value, file)
+	}
+
+	if len(m.Properties) < 2 {
+		return
+	}
+
+	// Each property is moved along with the span of the file from the start of its leading
+	// comments to the start of the leading comments of the next property, or of the closing
+	// brace for the last property.
+	starts := make([]scanner.Position, len(m.Properties)+1)
+	prevEnd := m.LBracePos
+	for i, prop := range m.Properties {
+		starts[i] = leadingCommentsPos(file, prevEnd, prop.Pos())
+		prevEnd = prop.End()
+	}
+	starts[len(m.Properties)] = leadingCommentsPos(file, prevEnd, m.RBracePos)
+
+	l := make(propertyList, len(m.Properties))
+	for i, prop := range m.Properties {
+		l[i] = propertyElem{prop, starts[i], starts[i+1]}
+	}
+
+	if sort.IsSorted(l) {
+		return
+	}
+	sort.Stable(l)
+
+	// Compute the shift for every property and comment before modifying any of them, so that
+	// the spans are compared against the original positions.
+	type shift struct {
+		offset, line int
+	}
+	propertyShifts := make([]shift, len(l))
+	commentShifts := make(map[*CommentGroup]shift)
+	curPos := starts[0]
+	for i, e := range l {
+		propertyShifts[i] = shift{curPos.Offset - e.pos.Offset, curPos.Line - e.pos.Line}
+		for _, c := range file.Comments {
+			if c.Pos().Offset >= e.pos.Offset && c.Pos().Offset < e.nextPos.Offset {
+				commentShifts[c] = propertyShifts[i]
+			}
+		}
+		curPos.Offset += e.nextPos.Offset - e.pos.Offset
+		curPos.Line += e.nextPos.Line - e.pos.Line
+	}
+
+	for i, e := range l {
+		m.Properties[i] = e.property
+		s := propertyShifts[i]
+		shiftPositions(e.property, s.offset, s.line)
+	}
+	for c, s := range commentShifts {
+		for _, comment := range c.Comments {
+			comment.Slash.Offset += s.offset
+			comment.Slash.Line += s.line
+		}
+	}
+}
+
+func sortMapsInValue(value Expression, file *File) {
+	switch v := value.(type) {
+	case *Operator:
+		sortMapsInValue(v.Args[0], file)
+		sortMapsInValue(v.Args[1], file)
+	case *Map:
+		sortMap(file, v)
+	case *List:
+		for _, e := range v.Values {
+			sortMapsInValue(e, file)
+		}
+	case *Select:
+		for _, c := range v.Cases {
+			sortMapsInValue(c.Value, file)
+		}
+	}
+}
+
+// leadingCommentsPos returns the position of the first comment between prevEnd and pos that
+// starts on a later line than prevEnd, or pos if there is none.
+func leadingCommentsPos(file *File, prevEnd, pos scanner.Position) scanner.Position {
+	for _, c := range file.Comments {
+		if c.Pos().Offset > prevEnd.Offset && c.Pos().Offset < pos.Offset && c.Pos().Line > prevEnd.Line {
+			return c.Pos()
+		}
+	}
+	return pos
+}
+
+// shiftPositions moves the positions of node and of every node inside it by the given number of
+// bytes and lines.
+func shiftPositions(node Node, offset, line int) {
+	move := func(pos *scanner.Position) {
+		pos.Offset += offset
+		pos.Line += line
+	}
+	walk(node, nil, func(node Node, parent Node) bool {
+		switch n := node.(type) {
+		case *Property:
+			move(&n.NamePos)
+			move(&n.ColonPos)
+		case *Map:
+			move(&n.LBracePos)
+			move(&n.RBracePos)
+		case *List:
+			move(&n.LBracePos)
+			move(&n.RBracePos)
+		case *Operator:
+			move(&n.OperatorPos)
+		case *Variable:
+			move(&n.NamePos)
+		case *String:
+			move(&n.LiteralPos)
+		case *Int64:
+			move(&n.LiteralPos)
+		case *Bool:
+			move(&n.LiteralPos)
+		case *Select:
+			move(&n.KeywordPos)
+			move(&n.VariablePos)
+			move(&n.LBracePos)
+			move(&n.RBracePos)
+			move(&n.RParenPos)
+		case *SelectCase:
+			move(&n.KeyPos)
+			move(&n.ColonPos)
+		}
+		return true
+	})
+}
+
 func subListIsSorted(values []Expression) bool {
 	prev := ""
 	for _, v := range values {
@@ -171,6 +328,26 @@ func (l elemList) Less(i, j int) bool {
 	return l[i].s < l[j].s
 }
 
+type propertyElem struct {
+	property *Property
+	pos      scanner.Position
+	nextPos  scanner.Position
+}
+
+type propertyList []propertyElem
+
+func (l propertyList) Len() int {
+	return len(l)
+}
+
+func (l propertyList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+func (l propertyList) Less(i, j int) bool {
+	return l[i].property.Name < l[j].property.Name
+}
+
 type commentsByOffset []*CommentGroup
 
 func (l commentsByOffset) Len() int {
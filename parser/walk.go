@@ -0,0 +1,116 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Walk traverses the definitions in file in order, calling visit for each Node along with its
+// parent Node.  The parent of a top level Definition is the File.  If visit returns false the
+// children of the Node are not visited.
+//
+// Assignments are walked through their original value as written in the file, and the value
+// referenced by a Variable is not walked, so every Node is visited at most once.
+func Walk(file *File, visit func(node Node, parent Node) bool) {
+	for _, def := range file.Defs {
+		walk(def, file, visit)
+	}
+}
+
+func walk(node Node, parent Node, visit func(node Node, parent Node) bool) {
+	if !visit(node, parent) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Assignment:
+		walk(n.OrigValue, n, visit)
+	case *Module:
+		for _, p := range n.Properties {
+			walk(p, n, visit)
+		}
+	case *Property:
+		walk(n.Value, n, visit)
+	case *Map:
+		for _, p := range n.Properties {
+			walk(p, n, visit)
+		}
+	case *List:
+		for _, v := range n.Values {
+			walk(v, n, visit)
+		}
+	case *Operator:
+		walk(n.Args[0], n, visit)
+		walk(n.Args[1], n, visit)
+	case *Select:
+		for _, c := range n.Cases {
+			walk(c, n, visit)
+		}
+	case *SelectCase:
+		walk(n.Value, n, visit)
+	case *Variable, *String, *Int64, *Bool:
+	default:
+		panic(fmt.Errorf("unknown node type %T", node))
+	}
+}
+
+// FileReferences returns the string literals in the values of the named properties of a module,
+// in the order the properties are named and the literals are written, for example to find the
+// source files referenced by the srcs properties of modules without evaluating the modules.
+// Properties of nested maps are named with dots, for example "target.linux.srcs".  Glob
+// patterns are returned verbatim, and the strings in every case of a select are returned.
+// Values referenced through variables are skipped, as are the values of nested maps.
+func FileReferences(m *Module, properties []string) []string {
+	var refs []string
+	for _, name := range properties {
+		prop, found := findProperty(&m.Map, name)
+		if !found {
+			continue
+		}
+		walk(prop.Value, prop, func(node, parent Node) bool {
+			switch n := node.(type) {
+			case *String:
+				refs = append(refs, n.Value)
+			case *Map:
+				return false
+			}
+			return true
+		})
+	}
+	return refs
+}
+
+// findProperty returns the property of a map with a name that may contain dots to name the
+// properties of nested maps.
+func findProperty(m *Map, name string) (*Property, bool) {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		prop, found := m.GetProperty(part)
+		if !found {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return prop, true
+		}
+		nested, ok := prop.Value.(*Map)
+		if !ok {
+			return nil, false
+		}
+		m = nested
+	}
+	return nil, false
+}
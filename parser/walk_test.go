@@ -0,0 +1,157 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	input := `
+		a = ["x"]
+		a += ["y"]
+
+		foo {
+			name: "foo",
+			srcs: a + ["z"],
+			props: {
+				enabled: true,
+				count: 1,
+			},
+		}
+	`
+
+	file, errs := ParseAndEval("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+
+	describe := func(node Node) string {
+		switch n := node.(type) {
+		case *File:
+			return "file"
+		case *Assignment:
+			return "assignment " + n.Name
+		case *Module:
+			return "module " + n.Type
+		case *Property:
+			return "property " + n.Name
+		case *Map:
+			return "map"
+		case *List:
+			return "list"
+		case *Operator:
+			return "operator"
+		case *Variable:
+			return "variable " + n.Name
+		case *String:
+			return fmt.Sprintf("string %q", n.Value)
+		case *Int64:
+			return fmt.Sprintf("int64 %d", n.Value)
+		case *Bool:
+			return fmt.Sprintf("bool %t", n.Value)
+		}
+		return fmt.Sprintf("%T", node)
+	}
+
+	var got []string
+	Walk(file, func(node, parent Node) bool {
+		got = append(got, describe(node)+" in "+describe(parent))
+		if p, ok := node.(*Property); ok && p.Name == "name" {
+			return false
+		}
+		return true
+	})
+
+	expected := []string{
+		`assignment a in file`,
+		`list in assignment a`,
+		`string "x" in list`,
+		`assignment a in file`,
+		`list in assignment a`,
+		`string "y" in list`,
+		`module foo in file`,
+		`property name in module foo`,
+		`property srcs in module foo`,
+		`operator in property srcs`,
+		`variable a in operator`,
+		`list in operator`,
+		`string "z" in list`,
+		`property props in module foo`,
+		`map in property props`,
+		`property enabled in map`,
+		`bool true in property enabled`,
+		`property count in map`,
+		`int64 1 in property count`,
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect walk:\nwant: %q\n got: %q", expected, got)
+	}
+}
+
+func TestFileReferences(t *testing.T) {
+	input := `
+		common_srcs = ["common.c"]
+
+		foo {
+			name: "foo",
+			srcs: common_srcs + [
+				"a.c",
+				"dir/*.c",
+			] + select(os, {
+				"linux": ["linux.c"],
+				default: ["other.c"],
+			}),
+			exclude_srcs: ["dir/skip.c"],
+			props: {
+				src: "ignored.c",
+			},
+			target: {
+				linux: {
+					srcs: ["target_linux.c"],
+				},
+			},
+		}
+	`
+
+	file, errs := Parse("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+
+	var module *Module
+	for _, def := range file.Defs {
+		if m, ok := def.(*Module); ok {
+			module = m
+		}
+	}
+
+	got := FileReferences(module, []string{"srcs", "exclude_srcs", "props", "target.linux.srcs", "missing"})
+	expected := []string{
+		"a.c",
+		"dir/*.c",
+		"linux.c",
+		"other.c",
+		"dir/skip.c",
+		"target_linux.c",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect file references:\nwant: %q\n got: %q", expected, got)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// A PropertyDiff describes a property that has a different value in two property structs.
+type PropertyDiff struct {
+	// Property is the name of the property as it would be written in a Blueprints file, with
+	// nested properties separated by dots and list elements suffixed with their index, for
+	// example "nested.foo" or "srcs[2]".
+	Property string
+
+	// A and B are the values of the property in the first and second property structs.  Pointers
+	// are dereferenced, and a nil pointer or a list element that is only present in one of the
+	// structs is reported as nil.
+	A, B interface{}
+}
+
+// DiffProperties takes two pointers to property structs of the same type and returns the
+// properties that differ between them, in field order.  Nested structs and pointers to structs
+// are compared field by field, lists of strings are compared element by element, and any other
+// list is reported as a whole.  A nil pointer to a struct is compared as if it pointed to a zero
+// value.
+func DiffProperties(a, b interface{}) []PropertyDiff {
+	aValue := reflect.ValueOf(a)
+	bValue := reflect.ValueOf(b)
+	if aValue.Type() != bValue.Type() {
+		panic(fmt.Errorf("can't diff mismatching types (%s <-> %s)",
+			aValue.Type(), bValue.Type()))
+	}
+	if aValue.Kind() != reflect.Ptr || aValue.Type().Elem().Kind() != reflect.Struct {
+		panic(fmt.Errorf("can't diff type %s, expected a pointer to a struct", aValue.Type()))
+	}
+
+	return diffProperties(nil, "", elemOrZero(aValue), elemOrZero(bValue))
+}
+
+func diffProperties(diffs []PropertyDiff, namePrefix string, aValue, bValue reflect.Value) []PropertyDiff {
+	for i, field := range typeFields(aValue.Type()) {
+		if field.PkgPath != "" {
+			// The field is not exported so just skip it.
+			continue
+		}
+
+		aFieldValue := aValue.Field(i)
+		bFieldValue := bValue.Field(i)
+
+		propertyName := namePrefix + PropertyNameForField(field.Name)
+		if field.Anonymous {
+			propertyName = namePrefix
+		}
+
+		switch aFieldValue.Kind() {
+		case reflect.Bool, reflect.String, reflect.Int, reflect.Uint:
+			if aFieldValue.Interface() != bFieldValue.Interface() {
+				diffs = append(diffs, PropertyDiff{propertyName,
+					aFieldValue.Interface(), bFieldValue.Interface()})
+			}
+		case reflect.Struct:
+			diffs = diffProperties(diffs, structPrefix(field, propertyName), aFieldValue, bFieldValue)
+		case reflect.Slice:
+			if aFieldValue.Type().Elem().Kind() != reflect.String {
+				if !reflect.DeepEqual(aFieldValue.Interface(), bFieldValue.Interface()) {
+					diffs = append(diffs, PropertyDiff{propertyName,
+						aFieldValue.Interface(), bFieldValue.Interface()})
+				}
+				break
+			}
+			for j := 0; j < aFieldValue.Len() || j < bFieldValue.Len(); j++ {
+				elementName := propertyName + "[" + strconv.Itoa(j) + "]"
+				var a, b interface{}
+				if j < aFieldValue.Len() {
+					a = aFieldValue.Index(j).Interface()
+				}
+				if j < bFieldValue.Len() {
+					b = bFieldValue.Index(j).Interface()
+				}
+				if a != b {
+					diffs = append(diffs, PropertyDiff{elementName, a, b})
+				}
+			}
+		case reflect.Interface:
+			if aFieldValue.IsNil() && bFieldValue.IsNil() {
+				break
+			}
+			if aFieldValue.IsNil() || bFieldValue.IsNil() ||
+				aFieldValue.Elem().Type() != bFieldValue.Elem().Type() {
+				diffs = append(diffs, PropertyDiff{propertyName,
+					interfaceOrNil(aFieldValue), interfaceOrNil(bFieldValue)})
+				break
+			}
+
+			aFieldValue = aFieldValue.Elem()
+			bFieldValue = bFieldValue.Elem()
+
+			if aFieldValue.Kind() != reflect.Ptr || aFieldValue.Type().Elem().Kind() != reflect.Struct {
+				panic(fmt.Errorf("can't diff field %q: interface refers to a non-pointer to struct",
+					field.Name))
+			}
+			fallthrough
+		case reflect.Ptr:
+			switch aFieldValue.Type().Elem().Kind() {
+			case reflect.Struct:
+				diffs = diffProperties(diffs, structPrefix(field, propertyName),
+					elemOrZero(aFieldValue), elemOrZero(bFieldValue))
+			case reflect.Bool, reflect.Int64, reflect.String:
+				a, b := interfaceOrNil(aFieldValue), interfaceOrNil(bFieldValue)
+				if a != b {
+					diffs = append(diffs, PropertyDiff{propertyName, a, b})
+				}
+			default:
+				panic(fmt.Errorf("can't diff field %q: points to a %s",
+					field.Name, aFieldValue.Type().Elem().Kind()))
+			}
+		default:
+			panic(fmt.Errorf("unexpected kind for property struct field %q: %s",
+				field.Name, aFieldValue.Kind()))
+		}
+	}
+
+	return diffs
+}
+
+// structPrefix returns the prefix for the properties inside a struct field.
+func structPrefix(field reflect.StructField, propertyName string) string {
+	if field.Anonymous {
+		return propertyName
+	}
+	return propertyName + "."
+}
+
+// elemOrZero returns the value a pointer points to, or a zero value of the pointed to type if the
+// pointer is nil.
+func elemOrZero(v reflect.Value) reflect.Value {
+	if v.IsNil() {
+		return reflect.Zero(v.Type().Elem())
+	}
+	return v.Elem()
+}
+
+// interfaceOrNil returns the value a pointer or interface refers to, or nil if it is nil.
+func interfaceOrNil(v reflect.Value) interface{} {
+	if v.IsNil() {
+		return nil
+	}
+	return v.Elem().Interface()
+}
@@ -0,0 +1,165 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"reflect"
+	"testing"
+)
+
+type DiffEmbedded struct {
+	E *string
+}
+
+type diffNested struct {
+	N string
+}
+
+type diffTestStruct struct {
+	DiffEmbedded
+
+	S          string
+	B          bool
+	I          int `blueprint:"mutated"`
+	S_ptr      *string
+	B_ptr      *bool
+	I_ptr      *int64
+	L          []string
+	M          []int `blueprint:"mutated"`
+	Nested     diffNested
+	Nested_ptr *diffNested
+	Iface      interface{}
+}
+
+var diffPropertiesTestCases = []struct {
+	name  string
+	a, b  *diffTestStruct
+	diffs []PropertyDiff
+}{
+	{
+		name: "equal",
+		a: &diffTestStruct{
+			S:     "a",
+			S_ptr: StringPtr("b"),
+			L:     []string{"c"},
+		},
+		b: &diffTestStruct{
+			S:     "a",
+			S_ptr: StringPtr("b"),
+			L:     []string{"c"},
+		},
+	},
+	{
+		name: "scalars",
+		a: &diffTestStruct{
+			S: "a",
+			B: true,
+			I: 1,
+		},
+		b: &diffTestStruct{
+			S: "b",
+			B: false,
+			I: 2,
+		},
+		diffs: []PropertyDiff{
+			{"s", "a", "b"},
+			{"b", true, false},
+			{"i", 1, 2},
+		},
+	},
+	{
+		name: "pointers",
+		a: &diffTestStruct{
+			DiffEmbedded: DiffEmbedded{E: StringPtr("e")},
+			S_ptr:        StringPtr("a"),
+			B_ptr:        BoolPtr(true),
+		},
+		b: &diffTestStruct{
+			S_ptr: StringPtr("b"),
+			B_ptr: BoolPtr(true),
+			I_ptr: Int64Ptr(3),
+		},
+		diffs: []PropertyDiff{
+			{"e", "e", nil},
+			{"s_ptr", "a", "b"},
+			{"i_ptr", nil, int64(3)},
+		},
+	},
+	{
+		name: "lists",
+		a: &diffTestStruct{
+			L: []string{"a", "b", "c"},
+			M: []int{1},
+		},
+		b: &diffTestStruct{
+			L: []string{"a", "x"},
+			M: []int{2},
+		},
+		diffs: []PropertyDiff{
+			{"l[1]", "b", "x"},
+			{"l[2]", "c", nil},
+			{"m", []int{1}, []int{2}},
+		},
+	},
+	{
+		name: "structs",
+		a: &diffTestStruct{
+			Nested: diffNested{N: "a"},
+		},
+		b: &diffTestStruct{
+			Nested:     diffNested{N: "b"},
+			Nested_ptr: &diffNested{N: "c"},
+		},
+		diffs: []PropertyDiff{
+			{"nested.n", "a", "b"},
+			{"nested_ptr.n", "", "c"},
+		},
+	},
+	{
+		name: "interfaces",
+		a: &diffTestStruct{
+			Iface: &diffNested{N: "a"},
+		},
+		b: &diffTestStruct{
+			Iface: &diffNested{N: "b"},
+		},
+		diffs: []PropertyDiff{
+			{"iface.n", "a", "b"},
+		},
+	},
+	{
+		name: "nil interface",
+		a: &diffTestStruct{
+			Iface: &diffNested{N: "a"},
+		},
+		b: &diffTestStruct{},
+		diffs: []PropertyDiff{
+			{"iface", &diffNested{N: "a"}, nil},
+		},
+	},
+}
+
+func TestDiffProperties(t *testing.T) {
+	for _, testCase := range diffPropertiesTestCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := DiffProperties(testCase.a, testCase.b)
+			if !reflect.DeepEqual(got, testCase.diffs) {
+				t.Errorf("incorrect diffs:")
+				t.Errorf("  expected: %#v", testCase.diffs)
+				t.Errorf("       got: %#v", got)
+			}
+		})
+	}
+}
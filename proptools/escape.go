@@ -88,12 +88,100 @@ func ShellEscape(s string) string {
 	return `'` + singleQuoteReplacer.Replace(s) + `'`
 }
 
+// CmdEscapeList takes a slice of strings that may contain characters that are meaningful to the
+// Windows cmd shell or to the command line parsing of Windows programs and escapes them if
+// necessary by calling CmdEscape on each one.  A new slice containing the escaped strings is
+// returned.
+func CmdEscapeList(slice []string) []string {
+	slice = append([]string(nil), slice...)
+
+	for i, s := range slice {
+		slice[i] = CmdEscape(s)
+	}
+	return slice
+}
+
+// CmdEscape takes a string that may contain characters that are meaningful to the Windows cmd
+// shell or to the command line parsing of Windows programs and escapes it if necessary so that the
+// program receives it as a single argument.  Strings that are empty or that contain spaces, quotes
+// or any character other than letters, digits and _+-=.,/\: are wrapped in double quotes.  Inside
+// the quotes, double quotes are escaped with a backslash, and backslashes that precede a double
+// quote or the closing quote are doubled.  Unlike ShellEscape, spaces are always quoted.
+//
+// cmd expands %var% and !var! even inside double quotes, so strings containing them cannot be
+// passed through safely.  $ has no meaning to cmd, but still needs to be escaped for ninja with
+// NinjaAndCmdEscape.
+func CmdEscape(s string) string {
+	cmdUnsafeChar := func(r rune) bool {
+		switch {
+		case 'A' <= r && r <= 'Z',
+			'a' <= r && r <= 'z',
+			'0' <= r && r <= '9',
+			r == '_',
+			r == '+',
+			r == '-',
+			r == '=',
+			r == '.',
+			r == ',',
+			r == '/',
+			r == '\\',
+			r == ':':
+			return false
+		default:
+			return true
+		}
+	}
+
+	if s != "" && strings.IndexFunc(s, cmdUnsafeChar) == -1 {
+		// No escaping necessary
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	backslashes := 0
+	for _, r := range s {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, 2*backslashes+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		b.WriteRune(r)
+		backslashes = 0
+	}
+	b.WriteString(strings.Repeat(`\`, 2*backslashes))
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// NinjaAndShellEscapeList takes a slice of strings that are used in a command run by a POSIX
+// shell and escapes them for ninja and then for the shell.  Use NinjaAndCmdEscapeList instead for
+// commands run by the Windows cmd shell.
 func NinjaAndShellEscapeList(slice []string) []string {
 	return ShellEscapeList(NinjaEscapeList(slice))
 }
 
+// NinjaAndShellEscape takes a string that is used in a command run by a POSIX shell and escapes
+// it for ninja and then for the shell, so that it is passed to the command unchanged.
 func NinjaAndShellEscape(s string) string {
 	return ShellEscape(NinjaEscape(s))
 }
 
+// NinjaAndCmdEscapeList takes a slice of strings that are used in a command run by the Windows cmd
+// shell and escapes them for ninja and then for cmd.
+func NinjaAndCmdEscapeList(slice []string) []string {
+	return CmdEscapeList(NinjaEscapeList(slice))
+}
+
+// NinjaAndCmdEscape takes a string that is used in a command run by the Windows cmd shell and
+// escapes it for ninja and then for cmd, so that it is passed to the command unchanged.
+func NinjaAndCmdEscape(s string) string {
+	return CmdEscape(NinjaEscape(s))
+}
+
 var singleQuoteReplacer = strings.NewReplacer(`'`, `'\''`)
@@ -89,6 +89,95 @@ var shellEscapeTestCase = []escapeTestCase{
 		in:   `-Wl,--rpath,${ORIGIN}/../bionic-loader-test-libs`,
 		out:  `'-Wl,--rpath,${ORIGIN}/../bionic-loader-test-libs'`,
 	},
+	{
+		name: "empty",
+		in:   ``,
+		out:  ``,
+	},
+	{
+		name: "spaces",
+		in:   `a b`,
+		out:  `a b`,
+	},
+	{
+		name: "embedded quotes",
+		in:   `a"b'c`,
+		out:  `'a"b'\''c'`,
+	},
+}
+
+var cmdEscapeTestCase = []escapeTestCase{
+	{
+		name: "no escaping",
+		in:   `test`,
+		out:  `test`,
+	},
+	{
+		name: "windows path",
+		in:   `C:\dir\file.txt`,
+		out:  `C:\dir\file.txt`,
+	},
+	{
+		name: "empty",
+		in:   ``,
+		out:  `""`,
+	},
+	{
+		name: "spaces",
+		in:   `a b`,
+		out:  `"a b"`,
+	},
+	{
+		name: "$var",
+		in:   `$var`,
+		out:  `"$var"`,
+	},
+	{
+		name: "double quote",
+		in:   `a"b`,
+		out:  `"a\"b"`,
+	},
+	{
+		name: "backslash before double quote",
+		in:   `a\"b`,
+		out:  `"a\\\"b"`,
+	},
+	{
+		name: "trailing backslash",
+		in:   `dir a\`,
+		out:  `"dir a\\"`,
+	},
+	{
+		name: "special characters",
+		in:   `a&b|c<d>e^f`,
+		out:  `"a&b|c<d>e^f"`,
+	},
+}
+
+var ninjaAndShellEscapeTestCase = []escapeTestCase{
+	{
+		name: "$var",
+		in:   `$var`,
+		out:  `'$$var'`,
+	},
+	{
+		name: "${var} with quotes",
+		in:   `"${var}"`,
+		out:  `'"$${var}"'`,
+	},
+}
+
+var ninjaAndCmdEscapeTestCase = []escapeTestCase{
+	{
+		name: "$var",
+		in:   `$var`,
+		out:  `"$$var"`,
+	},
+	{
+		name: "$var with spaces",
+		in:   `a $var`,
+		out:  `"a $$var"`,
+	},
 }
 
 func TestNinjaEscaping(t *testing.T) {
@@ -109,6 +198,33 @@ func TestShellEscaping(t *testing.T) {
 	}
 }
 
+func TestCmdEscaping(t *testing.T) {
+	for _, testCase := range cmdEscapeTestCase {
+		got := CmdEscape(testCase.in)
+		if got != testCase.out {
+			t.Errorf("%s: expected `%s` got `%s`", testCase.name, testCase.out, got)
+		}
+	}
+}
+
+func TestNinjaAndShellEscaping(t *testing.T) {
+	for _, testCase := range ninjaAndShellEscapeTestCase {
+		got := NinjaAndShellEscape(testCase.in)
+		if got != testCase.out {
+			t.Errorf("%s: expected `%s` got `%s`", testCase.name, testCase.out, got)
+		}
+	}
+}
+
+func TestNinjaAndCmdEscaping(t *testing.T) {
+	for _, testCase := range ninjaAndCmdEscapeTestCase {
+		got := NinjaAndCmdEscape(testCase.in)
+		if got != testCase.out {
+			t.Errorf("%s: expected `%s` got `%s`", testCase.name, testCase.out, got)
+		}
+	}
+}
+
 func TestExternalShellEscaping(t *testing.T) {
 	if testing.Short() {
 		return
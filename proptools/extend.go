@@ -145,6 +145,26 @@ func ExtendMatchingProperties(dst []interface{}, src interface{},
 	return extendMatchingProperties(dst, src, filter, order)
 }
 
+// MergeTristateProperties merges the tri-state bool properties, which are pointers to bools, in the
+// property struct src into the property struct dst. dst and src must be the same type, and both
+// must be pointers to structs.  A property that is set in src replaces the property in dst, and a
+// property that is nil in src leaves the property in dst unchanged, as with MergeBoolPtr.  Nested
+// structs, pointers to structs and interfaces containing pointers to structs are merged
+// recursively, and all other properties are left unchanged.
+//
+// An error returned by MergeTristateProperties that applies to a specific property will be an
+// *ExtendPropertyError, and can have the property name and error extracted from it.
+func MergeTristateProperties(dst interface{}, src interface{}) error {
+	return extendProperties(dst, src, tristateFilter, orderAppend)
+}
+
+func tristateFilter(property string, dstField, srcField reflect.StructField,
+	dstValue, srcValue interface{}) (bool, error) {
+
+	_, ok := srcValue.(*bool)
+	return ok, nil
+}
+
 type Order int
 
 const (
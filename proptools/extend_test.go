@@ -1306,6 +1306,81 @@ func TestExtendMatchingProperties(t *testing.T) {
 	}
 }
 
+func TestMergeBoolPtr(t *testing.T) {
+	values := []*bool{nil, BoolPtr(true), BoolPtr(false)}
+	for _, base := range values {
+		for _, override := range values {
+			expected := base
+			if override != nil {
+				expected = override
+			}
+
+			got := MergeBoolPtr(base, override)
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("MergeBoolPtr(%s, %s): expected %s, got %s",
+					p(base), p(override), p(expected), p(got))
+			}
+			if got != nil && (got == base || got == override) {
+				t.Errorf("MergeBoolPtr(%s, %s): expected a new pointer", p(base), p(override))
+			}
+		}
+	}
+}
+
+func TestMergeTristateProperties(t *testing.T) {
+	type nested struct {
+		B *bool
+	}
+	type props struct {
+		A      *bool
+		S      *string
+		L      []string
+		Nested nested
+		Ptr    *nested
+		Iface  interface{}
+	}
+
+	values := []*bool{nil, BoolPtr(true), BoolPtr(false)}
+	for _, base := range values {
+		for _, override := range values {
+			dst := &props{
+				A:      base,
+				S:      StringPtr("dst"),
+				L:      []string{"dst"},
+				Nested: nested{B: base},
+				Iface:  &nested{B: base},
+			}
+			src := &props{
+				A:      override,
+				S:      StringPtr("src"),
+				L:      []string{"src"},
+				Nested: nested{B: override},
+				Ptr:    &nested{B: override},
+				Iface:  &nested{B: override},
+			}
+
+			expectedB := MergeBoolPtr(base, override)
+			expected := &props{
+				A:      expectedB,
+				S:      StringPtr("dst"),
+				L:      []string{"dst"},
+				Nested: nested{B: expectedB},
+				Ptr:    &nested{B: override},
+				Iface:  &nested{B: expectedB},
+			}
+
+			testString := fmt.Sprintf("%s, %s", p(base), p(override))
+			err := MergeTristateProperties(dst, src)
+			check(t, "merge tristate", testString, dst, err, expected, nil)
+		}
+	}
+
+	err := MergeTristateProperties(&props{}, &nested{})
+	if err == nil {
+		t.Errorf("expected an error for mismatched types")
+	}
+}
+
 func check(t *testing.T, testType, testString string,
 	got interface{}, err error,
 	expected interface{}, expectedErr error) {
@@ -0,0 +1,170 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const printIndent = "    "
+
+// PrintProperties takes a pointer to a property struct and returns the properties it contains in
+// the syntax of the body of a module in a Blueprints file, one "name: value," property per line,
+// so that unpacking the result into an empty property struct of the same type recreates the
+// values.  Nested structs are printed as maps and lists of strings as lists.  Nil pointers and
+// slices, zero values that are not behind pointers, and fields tagged blueprint:"mutated" are
+// omitted, as they can't be distinguished from properties that are not set.  An error is returned if the
+// struct contains a field of a type that can't be written in a Blueprints file.
+func PrintProperties(props interface{}) (string, error) {
+	value := reflect.ValueOf(props)
+	if value.Kind() != reflect.Ptr || value.Type().Elem().Kind() != reflect.Struct {
+		return "", fmt.Errorf("can't print type %T, expected a pointer to a struct", props)
+	}
+
+	var b strings.Builder
+	if err := printStruct(&b, "", "", value.Elem(), make(map[string]bool)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// printStruct prints the fields of a struct.  printed contains the names of the properties that
+// have already been printed at the same level, as fields of embedded structs are printed as if
+// they were fields of the outer struct, and all the fields with the same name are unpacked from
+// the same property.
+func printStruct(b *strings.Builder, indent, namePrefix string, structValue reflect.Value,
+	printed map[string]bool) error {
+
+	structType := structValue.Type()
+	for i := 0; i < structValue.NumField(); i++ {
+		field := structType.Field(i)
+		fieldValue := structValue.Field(i)
+
+		if field.PkgPath != "" {
+			// The field is not exported so just skip it.
+			continue
+		}
+		if HasTag(field, "blueprint", "mutated") {
+			continue
+		}
+
+		propertyName := PropertyNameForField(field.Name)
+
+		if fieldValue.Kind() == reflect.Interface {
+			if fieldValue.IsNil() {
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+		}
+
+		isPtr := false
+		if fieldValue.Kind() == reflect.Ptr {
+			if fieldValue.IsNil() {
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+			isPtr = true
+		}
+
+		if fieldValue.Kind() == reflect.Struct {
+			if field.Anonymous || field.Name == "BlueprintEmbed" {
+				if err := printStruct(b, indent, namePrefix, fieldValue, printed); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if printed[propertyName] {
+				continue
+			}
+
+			var nested strings.Builder
+			if err := printStruct(&nested, indent+printIndent, namePrefix+propertyName+".",
+				fieldValue, make(map[string]bool)); err != nil {
+				return err
+			}
+			if nested.Len() == 0 {
+				if isPtr {
+					printed[propertyName] = true
+					fmt.Fprintf(b, "%s%s: {},\n", indent, propertyName)
+				}
+				continue
+			}
+			printed[propertyName] = true
+			fmt.Fprintf(b, "%s%s: {\n%s%s},\n", indent, propertyName, nested.String(), indent)
+			continue
+		}
+
+		if printed[propertyName] || !isPtr && isZero(fieldValue) {
+			continue
+		}
+
+		s, err := printValue(indent, fieldValue)
+		if err != nil {
+			return fmt.Errorf("can't print property %q: %s", namePrefix+propertyName, err)
+		}
+		printed[propertyName] = true
+		fmt.Fprintf(b, "%s%s: %s,\n", indent, propertyName, s)
+	}
+
+	return nil
+}
+
+func printValue(indent string, value reflect.Value) (string, error) {
+	switch value.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool()), nil
+	case reflect.String:
+		return strconv.Quote(value.String()), nil
+	case reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), nil
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return "", fmt.Errorf("unsupported list type %s", value.Type())
+		}
+		switch value.Len() {
+		case 0:
+			return "[]", nil
+		case 1:
+			return "[" + strconv.Quote(value.Index(0).String()) + "]", nil
+		}
+		s := "[\n"
+		for i := 0; i < value.Len(); i++ {
+			s += indent + printIndent + strconv.Quote(value.Index(i).String()) + ",\n"
+		}
+		return s + indent + "]", nil
+	default:
+		return "", fmt.Errorf("unsupported type %s", value.Type())
+	}
+}
+
+func isZero(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.String:
+		return value.Len() == 0
+	case reflect.Slice:
+		// An empty list is unpacked into an empty, non-nil slice.
+		return value.IsNil()
+	case reflect.Int64:
+		return value.Int() == 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"testing"
+)
+
+type PrintEmbedded struct {
+	E *string
+}
+
+type printNested struct {
+	N string
+	L []string
+}
+
+type printTestStruct struct {
+	PrintEmbedded
+
+	S          string
+	B          bool
+	I          int `blueprint:"mutated"`
+	S_ptr      *string
+	B_ptr      *bool
+	I_ptr      *int64
+	L          []string
+	Nested     printNested
+	Nested_ptr *printNested
+	Iface      interface{}
+}
+
+var printPropertiesTestCases = []struct {
+	name   string
+	in     interface{}
+	out    string
+	errStr string
+}{
+	{
+		name: "empty",
+		in:   &printTestStruct{},
+		out:  "",
+	},
+	{
+		name: "values",
+		in: &printTestStruct{
+			PrintEmbedded: PrintEmbedded{E: StringPtr("e")},
+			S:             "a \"quoted\" string",
+			B:             true,
+			I:             1,
+			S_ptr:         StringPtr(""),
+			B_ptr:         BoolPtr(false),
+			I_ptr:         Int64Ptr(-3),
+			L:             []string{"x"},
+			Nested: printNested{
+				N: "n",
+				L: []string{"y", "z"},
+			},
+			Nested_ptr: &printNested{},
+			Iface:      &printNested{L: []string{}},
+		},
+		out: `e: "e",
+s: "a \"quoted\" string",
+b: true,
+s_ptr: "",
+b_ptr: false,
+i_ptr: -3,
+l: ["x"],
+nested: {
+    n: "n",
+    l: [
+        "y",
+        "z",
+    ],
+},
+nested_ptr: {},
+iface: {
+    l: [],
+},
+`,
+	},
+	{
+		name: "unsupported",
+		in: &struct {
+			Nested struct {
+				M map[string]string
+			}
+		}{
+			Nested: struct {
+				M map[string]string
+			}{
+				M: map[string]string{},
+			},
+		},
+		errStr: `can't print property "nested.m": unsupported type map[string]string`,
+	},
+	{
+		name:   "not a pointer",
+		in:     printTestStruct{},
+		errStr: "can't print type proptools.printTestStruct, expected a pointer to a struct",
+	},
+}
+
+func TestPrintProperties(t *testing.T) {
+	for _, testCase := range printPropertiesTestCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			out, err := PrintProperties(testCase.in)
+			if testCase.errStr != "" {
+				if err == nil || err.Error() != testCase.errStr {
+					t.Errorf("expected error %q, got %v", testCase.errStr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if out != testCase.out {
+				t.Errorf("incorrect output:\nwant:\n%s\n got:\n%s", testCase.out, out)
+			}
+		})
+	}
+}
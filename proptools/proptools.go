@@ -62,6 +62,19 @@ func BoolDefault(b *bool, def bool) bool {
 	return def
 }
 
+// MergeBoolPtr merges two tri-state bool properties, where nil means the property was not set.  It
+// returns a pointer to a new bool containing the value of override if it is non-nil, otherwise a
+// pointer to a new bool containing the value of base if it is non-nil, otherwise nil.
+func MergeBoolPtr(base, override *bool) *bool {
+	if override != nil {
+		return BoolPtr(*override)
+	}
+	if base != nil {
+		return BoolPtr(*base)
+	}
+	return nil
+}
+
 // Bool takes a pointer to a bool and returns true iff the pointer is non-nil and points to a true
 // value.
 func Bool(b *bool) bool {
@@ -32,6 +32,19 @@ func HasTag(field reflect.StructField, name, value string) bool {
 	return false
 }
 
+// PropertyAlias returns the alternate property name given to a StructField by a tag in the form
+// `blueprint:"alias:old_name"`, or "" if the field has no alias.
+func PropertyAlias(field reflect.StructField) string {
+	tag := field.Tag.Get("blueprint")
+	for _, entry := range strings.Split(tag, ",") {
+		if strings.HasPrefix(entry, "alias:") {
+			return strings.TrimPrefix(entry, "alias:")
+		}
+	}
+
+	return ""
+}
+
 // PropertyIndexesWithTag returns the indexes of all properties (in the form used by reflect.Value.FieldByIndex) that
 // are tagged with the given key and value, including ones found in embedded structs or pointers to structs.
 func PropertyIndexesWithTag(ps interface{}, key, value string) [][]int {
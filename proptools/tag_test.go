@@ -68,6 +68,35 @@ func TestHasTag(t *testing.T) {
 	}
 }
 
+func TestPropertyAlias(t *testing.T) {
+	type testType struct {
+		NoTag    string
+		Mutated  string `blueprint:"mutated"`
+		Alias    string `blueprint:"alias:old_name"`
+		AliasMut string `blueprint:"mutated,alias:old_name"`
+		Other    string `name:"alias:old_name"`
+	}
+
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{field: "NoTag", want: ""},
+		{field: "Mutated", want: ""},
+		{field: "Alias", want: "old_name"},
+		{field: "AliasMut", want: "old_name"},
+		{field: "Other", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.field, func(t *testing.T) {
+			field, _ := reflect.TypeOf(testType{}).FieldByName(test.field)
+			if got := PropertyAlias(field); got != test.want {
+				t.Errorf(`PropertyAlias(%q) = %q, want %q`, field.Tag, got, test.want)
+			}
+		})
+	}
+}
+
 func TestPropertyIndexesWithTag(t *testing.T) {
 	tests := []struct {
 		name string
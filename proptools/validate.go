@@ -0,0 +1,126 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ValidatePropertyStruct takes a pointer to a property struct and returns an error if any of its
+// exported fields, or the exported fields of any nested structs, pointers to structs or interfaces
+// containing pointers to structs, has a type that can't be set from a Blueprints file.  Supported
+// types are bool, string, []string, and pointers to bool, int64 or string.  Fields of type int,
+// uint or slices of other types are only allowed if they are tagged with `blueprint:"mutated"`.
+// An unsupported field is reported as a *ValidatePropertyError, which names the field by its path
+// from the property struct, for example "Nested.Foo".
+func ValidatePropertyStruct(props interface{}) error {
+	v := reflect.ValueOf(props)
+	if v.Kind() != reflect.Ptr || v.Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unsupported type %s, expected a pointer to a struct", reflect.TypeOf(props))
+	}
+
+	return validatePropertyStruct("", elemOrZero(v))
+}
+
+// A ValidatePropertyError is returned by ValidatePropertyStruct for a field with an unsupported
+// type.
+type ValidatePropertyError struct {
+	Err   error
+	Field string // The path of the field from the property struct, for example "Nested.Foo"
+}
+
+func (e *ValidatePropertyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Err)
+}
+
+func validatePropertyErrorf(field string, format string, a ...interface{}) *ValidatePropertyError {
+	return &ValidatePropertyError{
+		Err:   fmt.Errorf(format, a...),
+		Field: trimDot(field),
+	}
+}
+
+func validatePropertyStruct(namePrefix string, structValue reflect.Value) error {
+	for i, field := range typeFields(structValue.Type()) {
+		if field.Name == "BlueprintEmbed" {
+			field.Anonymous = true
+		}
+
+		if field.PkgPath != "" {
+			// This is an unexported field, so just skip it.
+			continue
+		}
+
+		fieldValue := structValue.Field(i)
+		fieldName := namePrefix + field.Name
+		if field.Anonymous {
+			fieldName = namePrefix
+		}
+
+		unsupported := func(typ reflect.Type) error {
+			return validatePropertyErrorf(fieldName, "unsupported type %s", typ)
+		}
+
+		switch kind := fieldValue.Kind(); kind {
+		case reflect.Bool, reflect.String:
+			// Do nothing
+		case reflect.Struct:
+			if err := validatePropertyStruct(structPrefix(field, fieldName), fieldValue); err != nil {
+				return err
+			}
+		case reflect.Slice:
+			if field.Type.Elem().Kind() != reflect.String && !HasTag(field, "blueprint", "mutated") {
+				return unsupported(field.Type)
+			}
+		case reflect.Int, reflect.Uint:
+			if !HasTag(field, "blueprint", "mutated") {
+				return validatePropertyErrorf(fieldName, `int field must be tagged blueprint:"mutated"`)
+			}
+		case reflect.Interface:
+			if fieldValue.IsNil() {
+				return validatePropertyErrorf(fieldName, "nil interface")
+			}
+			fieldValue = fieldValue.Elem()
+			if fieldValue.Kind() != reflect.Ptr || fieldValue.Type().Elem().Kind() != reflect.Struct {
+				return validatePropertyErrorf(fieldName, "interface contains unsupported type %s",
+					fieldValue.Type())
+			}
+			fallthrough
+		case reflect.Ptr:
+			switch fieldValue.Type().Elem().Kind() {
+			case reflect.Struct:
+				if err := validatePropertyStruct(structPrefix(field, fieldName), elemOrZero(fieldValue)); err != nil {
+					return err
+				}
+			case reflect.Bool, reflect.Int64, reflect.String:
+				// Do nothing
+			default:
+				return unsupported(fieldValue.Type())
+			}
+		default:
+			return unsupported(field.Type)
+		}
+	}
+
+	return nil
+}
+
+func trimDot(name string) string {
+	if len(name) > 0 && name[len(name)-1] == '.' {
+		return name[:len(name)-1]
+	}
+	return name
+}
@@ -0,0 +1,121 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"testing"
+)
+
+type ValidateEmbedded struct {
+	E chan int
+}
+
+var validatePropertyStructTestCases = []struct {
+	name  string
+	props interface{}
+	err   string
+}{
+	{
+		name: "supported",
+		props: &struct {
+			S          string
+			B          bool
+			L          []string
+			S_ptr      *string
+			B_ptr      *bool
+			I_ptr      *int64
+			I          int   `blueprint:"mutated"`
+			M          []int `blueprint:"mutated"`
+			Nested     struct{ S string }
+			Nested_ptr *struct{ S string }
+			Iface      interface{}
+			private    chan int
+		}{
+			Iface: &struct{ S string }{},
+		},
+	},
+	{
+		name: "unsupported",
+		props: &struct {
+			C chan int
+		}{},
+		err: "C: unsupported type chan int",
+	},
+	{
+		name: "non-string slice",
+		props: &struct {
+			L []int
+		}{},
+		err: "L: unsupported type []int",
+	},
+	{
+		name: "int",
+		props: &struct {
+			I int
+		}{},
+		err: `I: int field must be tagged blueprint:"mutated"`,
+	},
+	{
+		name: "nested",
+		props: &struct {
+			Nested struct {
+				Foo map[string]string
+			}
+		}{},
+		err: "Nested.Foo: unsupported type map[string]string",
+	},
+	{
+		name: "nil nested pointer",
+		props: &struct {
+			Nested *struct {
+				Foo *int
+			}
+		}{},
+		err: "Nested.Foo: unsupported type *int",
+	},
+	{
+		name: "embedded",
+		props: &struct {
+			ValidateEmbedded
+		}{},
+		err: "E: unsupported type chan int",
+	},
+	{
+		name: "nil interface",
+		props: &struct {
+			Iface interface{}
+		}{},
+		err: "Iface: nil interface",
+	},
+	{
+		name:  "not a pointer",
+		props: struct{}{},
+		err:   "unsupported type struct {}, expected a pointer to a struct",
+	},
+}
+
+func TestValidatePropertyStruct(t *testing.T) {
+	for _, testCase := range validatePropertyStructTestCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := ValidatePropertyStruct(testCase.props)
+			if got := fmt.Sprint(err); err == nil && testCase.err != "" {
+				t.Errorf("expected error %q, got none", testCase.err)
+			} else if err != nil && got != testCase.err {
+				t.Errorf("expected error %q, got %q", testCase.err, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// A ResolvedGraph is a read-only description of the module graph of a Context after
+// ResolveDependencies, written by ExportGraph and read by ImportGraph, that can be analyzed in a
+// different process than the one that resolved it.  It does not contain the modules themselves,
+// so it can't be used to generate build actions.
+type ResolvedGraph struct {
+	// Modules contains every module variant, sorted by name and variant.
+	Modules []*ResolvedModule
+
+	// BlueprintFiles contains the sorted paths of the Blueprints files that were parsed.
+	BlueprintFiles []string
+
+	// set by ImportGraph
+	moduleIndex  map[resolvedModuleKey]*ResolvedModule
+	reverseDeps  map[*ResolvedModule][]*ResolvedModule
+	modulesNamed map[string][]*ResolvedModule
+}
+
+// A ResolvedModule is a module variant in a ResolvedGraph.
+type ResolvedModule struct {
+	Name           string            // The unique name of the module
+	Type           string            // The module type name
+	Variant        string            // The variant name, empty for modules without variants
+	Variations     map[string]string // The variations of the variant, keyed by mutator name
+	BlueprintsFile string            // The Blueprints file that defines the module
+	Deps           []ResolvedDep     // The direct dependencies, in the order they were added
+}
+
+// A ResolvedDep is a dependency of a ResolvedModule.
+type ResolvedDep struct {
+	Name    string // The unique name of the dependency
+	Variant string // The variant name of the dependency
+	TagType string // The type of the dependency tag, as returned by DependencyTagTypes
+}
+
+type resolvedModuleKey struct {
+	name, variant string
+}
+
+// ExportGraph writes a ResolvedGraph describing the module graph to w in gob format, which can be
+// read by ImportGraph.  It returns an error if it is called before ResolveDependencies has
+// completed successfully.
+func (c *Context) ExportGraph(w io.Writer) error {
+	if !c.dependenciesReady {
+		return fmt.Errorf("ExportGraph called before ResolveDependencies")
+	}
+
+	uniqueName := func(module *moduleInfo) string {
+		return c.nameInterface.UniqueName(newNamespaceContext(module), module.group.name)
+	}
+
+	modules := append([]*moduleInfo(nil), c.modulesSorted...)
+	sort.Sort(moduleSorter{modules, c.nameInterface})
+
+	graph := &ResolvedGraph{
+		BlueprintFiles: c.BlueprintFiles(),
+	}
+	for _, module := range modules {
+		resolved := &ResolvedModule{
+			Name:           uniqueName(module),
+			Type:           module.typeName,
+			Variant:        module.variantName,
+			Variations:     module.variant.clone(),
+			BlueprintsFile: module.relBlueprintsFile,
+		}
+		for _, dep := range module.directDeps {
+			resolved.Deps = append(resolved.Deps, ResolvedDep{
+				Name:    uniqueName(dep.module),
+				Variant: dep.module.variantName,
+				TagType: qualifiedTypeName(reflect.TypeOf(dep.tag)),
+			})
+		}
+		graph.Modules = append(graph.Modules, resolved)
+	}
+
+	return gob.NewEncoder(w).Encode(graph)
+}
+
+// ImportGraph reads a ResolvedGraph written by ExportGraph from r.
+func ImportGraph(r io.Reader) (*ResolvedGraph, error) {
+	graph := &ResolvedGraph{}
+	if err := gob.NewDecoder(r).Decode(graph); err != nil {
+		return nil, err
+	}
+
+	graph.moduleIndex = make(map[resolvedModuleKey]*ResolvedModule)
+	graph.modulesNamed = make(map[string][]*ResolvedModule)
+	for _, module := range graph.Modules {
+		graph.moduleIndex[resolvedModuleKey{module.Name, module.Variant}] = module
+		graph.modulesNamed[module.Name] = append(graph.modulesNamed[module.Name], module)
+	}
+
+	graph.reverseDeps = make(map[*ResolvedModule][]*ResolvedModule)
+	for _, module := range graph.Modules {
+		seen := make(map[*ResolvedModule]bool)
+		for _, dep := range module.Deps {
+			depModule, ok := graph.moduleIndex[resolvedModuleKey{dep.Name, dep.Variant}]
+			if !ok {
+				return nil, fmt.Errorf("module %q variant %q depends on unknown module %q variant %q",
+					module.Name, module.Variant, dep.Name, dep.Variant)
+			}
+			if !seen[depModule] {
+				seen[depModule] = true
+				graph.reverseDeps[depModule] = append(graph.reverseDeps[depModule], module)
+			}
+		}
+	}
+
+	return graph, nil
+}
+
+// Module returns the variant of the module with the given name, or nil if there is none.
+func (g *ResolvedGraph) Module(name, variant string) *ResolvedModule {
+	return g.moduleIndex[resolvedModuleKey{name, variant}]
+}
+
+// ModulesNamed returns all the variants of the module with the given name, sorted by variant.
+func (g *ResolvedGraph) ModulesNamed(name string) []*ResolvedModule {
+	return append([]*ResolvedModule(nil), g.modulesNamed[name]...)
+}
+
+// ModulesOfType returns the module variants of the given module type, sorted by name and variant.
+func (g *ResolvedGraph) ModulesOfType(typ string) []*ResolvedModule {
+	var modules []*ResolvedModule
+	for _, module := range g.Modules {
+		if module.Type == typ {
+			modules = append(modules, module)
+		}
+	}
+	return modules
+}
+
+// DirectDeps returns the module variants that module depends on directly, in the order the
+// dependencies were added.  A module that is depended on multiple times is returned once.
+func (g *ResolvedGraph) DirectDeps(module *ResolvedModule) []*ResolvedModule {
+	var deps []*ResolvedModule
+	seen := make(map[*ResolvedModule]bool)
+	for _, dep := range module.Deps {
+		depModule := g.Module(dep.Name, dep.Variant)
+		if !seen[depModule] {
+			seen[depModule] = true
+			deps = append(deps, depModule)
+		}
+	}
+	return deps
+}
+
+// ReverseDeps returns the module variants that depend directly on module, sorted by name and
+// variant.
+func (g *ResolvedGraph) ReverseDeps(module *ResolvedModule) []*ResolvedModule {
+	return append([]*ResolvedModule(nil), g.reverseDeps[module]...)
+}
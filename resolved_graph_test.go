@@ -0,0 +1,139 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestExportGraph(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "C"],
+			}
+		`),
+		"dir/Blueprints": []byte(`
+			foo_module {
+			    name: "B",
+			    deps: ["C"],
+			}
+
+			bar_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterBottomUpMutator("deps", func(mctx BottomUpMutatorContext) {
+		if m, ok := mctx.Module().(*fooModule); ok {
+			for _, dep := range m.properties.Deps {
+				mctx.AddDependency(mctx.Module(), dependencyPathTag{name: dep}, dep)
+			}
+		}
+	})
+	ctx.RegisterBottomUpMutator("arch", func(mctx BottomUpMutatorContext) {
+		mctx.CreateVariations("arm", "x86")
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expectedErr := "ExportGraph called before ResolveDependencies"
+	if err := ctx.ExportGraph(&bytes.Buffer{}); err == nil || err.Error() != expectedErr {
+		t.Errorf("expected error %q, got %v", expectedErr, err)
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.ExportGraph(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	graph, err := ImportGraph(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if expected := []string{"Blueprints", "dir/Blueprints"}; !reflect.DeepEqual(graph.BlueprintFiles, expected) {
+		t.Errorf("incorrect Blueprints files:\nwant: %q\n got: %q", expected, graph.BlueprintFiles)
+	}
+
+	ids := func(modules []*ResolvedModule) []string {
+		var ret []string
+		for _, m := range modules {
+			ret = append(ret, m.Name+":"+m.Variant)
+		}
+		return ret
+	}
+
+	if got, expected := ids(graph.Modules), []string{"A:arm", "A:x86", "B:arm", "B:x86", "C:arm", "C:x86"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect modules:\nwant: %q\n got: %q", expected, got)
+	}
+
+	b := graph.Module("B", "x86")
+	if b == nil {
+		t.Fatalf("missing module B variant x86")
+	}
+	expectedB := &ResolvedModule{
+		Name:           "B",
+		Type:           "foo_module",
+		Variant:        "x86",
+		Variations:     map[string]string{"arch": "x86"},
+		BlueprintsFile: "dir/Blueprints",
+		Deps: []ResolvedDep{
+			{Name: "C", Variant: "x86", TagType: "github.com/google/blueprint.dependencyPathTag"},
+		},
+	}
+	if !reflect.DeepEqual(b, expectedB) {
+		t.Errorf("incorrect module B:\nwant: %+v\n got: %+v", expectedB, b)
+	}
+
+	if got, expected := ids(graph.ModulesNamed("C")), []string{"C:arm", "C:x86"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect modules named C:\nwant: %q\n got: %q", expected, got)
+	}
+	if got, expected := ids(graph.ModulesOfType("foo_module")), []string{"A:arm", "A:x86", "B:arm", "B:x86"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect foo_modules:\nwant: %q\n got: %q", expected, got)
+	}
+	if got, expected := ids(graph.DirectDeps(graph.Module("A", "arm"))), []string{"B:arm", "C:arm"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect deps of A:\nwant: %q\n got: %q", expected, got)
+	}
+	if got, expected := ids(graph.ReverseDeps(graph.Module("C", "arm"))), []string{"A:arm", "B:arm"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect reverse deps of C:\nwant: %q\n got: %q", expected, got)
+	}
+	if graph.Module("D", "") != nil {
+		t.Errorf("unexpected module D")
+	}
+}
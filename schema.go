@@ -0,0 +1,199 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// RegisterModuleTypesFromSchema registers module types that are described by a
+// JSON schema instead of by Go code, so that tools can parse and inspect
+// modules of types they have no implementation for.  The schema is a list of
+// module types, each with a name and an object describing its properties:
+//
+//   [
+//       {
+//           "name": "my_module",
+//           "properties": {
+//               "srcs": "list",
+//               "enabled": "bool",
+//               "version": "int64",
+//               "target": {
+//                   "arch": "string"
+//               }
+//           }
+//       }
+//   ]
+//
+// A property is either one of the types "string", "bool", "int64" or "list",
+// which is a list of strings, or an object describing the properties of a
+// nested map.  Every module type also has the usual "name" property.
+//
+// Modules of these types are created as *SchemaModule objects.  Their
+// GenerateBuildActions method does nothing unless a handler has been set with
+// SetSchemaModuleHandler.  No module types are registered if the schema is
+// invalid.
+func (c *Context) RegisterModuleTypesFromSchema(r io.Reader) error {
+	var schema []struct {
+		Name       string
+		Properties map[string]interface{}
+	}
+	if err := json.NewDecoder(r).Decode(&schema); err != nil {
+		return fmt.Errorf("failed to parse module type schema: %s", err)
+	}
+
+	var types []*schemaModuleType
+	seen := make(map[string]bool)
+	for _, s := range schema {
+		if s.Name == "" {
+			return fmt.Errorf("module type schema is missing a name")
+		}
+		if _, present := c.moduleFactories[s.Name]; present || seen[s.Name] {
+			return fmt.Errorf("module type %q is already registered", s.Name)
+		}
+		seen[s.Name] = true
+		if _, present := s.Properties["name"]; present {
+			return fmt.Errorf("module type %q: property \"name\" is built in", s.Name)
+		}
+		propertiesType, err := schemaPropertiesType(s.Properties)
+		if err != nil {
+			return fmt.Errorf("module type %q: %s", s.Name, err)
+		}
+		types = append(types, &schemaModuleType{
+			name:           s.Name,
+			propertiesType: propertiesType,
+		})
+	}
+
+	for _, t := range types {
+		if c.schemaModuleTypes == nil {
+			c.schemaModuleTypes = make(map[string]*schemaModuleType)
+		}
+		c.schemaModuleTypes[t.name] = t
+		c.RegisterModuleType(t.name, t.factory)
+	}
+
+	return nil
+}
+
+// SetSchemaModuleHandler sets a function that is called from the
+// GenerateBuildActions method of every module of a type registered by
+// RegisterModuleTypesFromSchema.  It panics if no such module type has been
+// registered.
+func (c *Context) SetSchemaModuleHandler(typeName string, handler func(ModuleContext, *SchemaModule)) {
+	t, ok := c.schemaModuleTypes[typeName]
+	if !ok {
+		panic(fmt.Errorf("module type %q was not registered from a schema", typeName))
+	}
+	t.handler = handler
+}
+
+var schemaPropertyNameRegexp = regexp.MustCompile(`^[a-z][a-zA-Z0-9_]*$`)
+
+// schemaPropertiesType returns a struct type with a field for each property in
+// a module type schema.
+func schemaPropertiesType(properties map[string]interface{}) (reflect.Type, error) {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []reflect.StructField
+	for _, name := range names {
+		if !schemaPropertyNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid property name %q", name)
+		}
+
+		var typ reflect.Type
+		switch value := properties[name].(type) {
+		case string:
+			switch value {
+			case "string":
+				typ = reflect.TypeOf((*string)(nil))
+			case "bool":
+				typ = reflect.TypeOf((*bool)(nil))
+			case "int64":
+				typ = reflect.TypeOf((*int64)(nil))
+			case "list":
+				typ = reflect.TypeOf([]string(nil))
+			default:
+				return nil, fmt.Errorf("property %q has unknown type %q", name, value)
+			}
+		case map[string]interface{}:
+			var err error
+			typ, err = schemaPropertiesType(value)
+			if err != nil {
+				return nil, fmt.Errorf("in property %q: %s", name, err)
+			}
+		default:
+			return nil, fmt.Errorf("property %q must be a type name or an object", name)
+		}
+
+		fields = append(fields, reflect.StructField{
+			Name: proptools.FieldNameForProperty(name),
+			Type: typ,
+		})
+	}
+
+	return reflect.StructOf(fields), nil
+}
+
+type schemaModuleType struct {
+	name           string
+	propertiesType reflect.Type
+
+	// set by SetSchemaModuleHandler
+	handler func(ModuleContext, *SchemaModule)
+}
+
+func (t *schemaModuleType) factory() (Module, []interface{}) {
+	m := &SchemaModule{
+		moduleType: t,
+		properties: reflect.New(t.propertiesType).Interface(),
+	}
+	return m, []interface{}{m.properties, &m.SimpleName.Properties}
+}
+
+// A SchemaModule is a module of a type registered by
+// Context.RegisterModuleTypesFromSchema.
+type SchemaModule struct {
+	SimpleName
+
+	moduleType *schemaModuleType
+	properties interface{}
+}
+
+// Properties returns a pointer to the struct holding the module's properties.
+// The struct has a field for each property in the schema, named by converting
+// the first letter of the property name to upper case.  Strings, bools and
+// int64s are stored as pointers that are nil if the property was not set, and
+// nested maps are stored as structs.
+func (m *SchemaModule) Properties() interface{} {
+	return m.properties
+}
+
+func (m *SchemaModule) GenerateBuildActions(ctx ModuleContext) {
+	if m.moduleType.handler != nil {
+		m.moduleType.handler(ctx, m)
+	}
+}
@@ -45,8 +45,9 @@ type SingletonContext interface {
 	RequireNinjaVersion(major, minor, micro int)
 
 	// SetNinjaBuildDir sets the value of the top-level "builddir" Ninja variable
-	// that controls where Ninja stores its build log files.  This value can be
-	// set at most one time for a single build, later calls are ignored.
+	// that controls where Ninja stores its build log files, or of the variable
+	// named by Context.SetOutDirVariableName.  This value can be set at most
+	// one time for a single build, later calls are ignored.
 	SetNinjaBuildDir(pctx PackageContext, value string)
 
 	// AddSubninja adds a ninja file to include with subninja. This should likely
@@ -0,0 +1,156 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"runtime"
+	"time"
+)
+
+// A traceEvent marks the beginning or end of a phase of the build, a mutator or a singleton.
+// Events are only recorded from the goroutine that drives the Context, so every begin event is
+// followed by its matching end event before the end event of the enclosing phase.
+type traceEvent struct {
+	name     string
+	category string
+	phase    string
+	time     time.Time
+
+	// set if SetPhaseAllocTracking is enabled
+	totalAlloc uint64
+	mallocs    uint64
+}
+
+// maxTraceEvents limits the number of events kept by a Context that is used for many builds, for
+// example with ReparseChangedFiles.  Once it is reached the events recorded so far are discarded
+// when the next top level phase begins, so that begin and end events stay balanced.
+var maxTraceEvents = 10000
+
+func (c *Context) beginEvent(category, name string) {
+	if c.traceDepth == 0 && len(c.traceEvents) >= maxTraceEvents {
+		c.traceEvents = c.traceEvents[:0]
+	}
+	c.traceDepth++
+	c.addEvent(traceEvent{name: name, category: category, phase: "B"})
+}
+
+func (c *Context) endEvent(category, name string) {
+	c.traceDepth--
+	c.addEvent(traceEvent{name: name, category: category, phase: "E"})
+}
+
+func (c *Context) addEvent(event traceEvent) {
+	if c.phaseAllocTracking {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		event.totalAlloc = stats.TotalAlloc
+		event.mallocs = stats.Mallocs
+	}
+	event.time = time.Now()
+	c.traceEvents = append(c.traceEvents, event)
+}
+
+// SetPhaseAllocTracking enables or disables recording the memory allocated
+// during each phase of the build, mutator and singleton, which is returned by
+// PhaseAllocStats.  Reading the allocation counters stops the world briefly,
+// so it is disabled by default.
+func (c *Context) SetPhaseAllocTracking(track bool) {
+	c.phaseAllocTracking = track
+}
+
+// PhaseAllocStats describes the memory allocated during a phase of the build,
+// a mutator or a singleton.
+type PhaseAllocStats struct {
+	Category string // "phase", "mutator" or "singleton"
+	Name     string
+	Bytes    uint64 // The number of bytes allocated
+	Objects  uint64 // The number of objects allocated
+}
+
+// PhaseAllocStats returns the memory allocated during each phase of the build,
+// mutator and singleton that has run so far while SetPhaseAllocTracking was
+// enabled, in the order they started.  The numbers are process-wide deltas of
+// the runtime allocation counters, so they include allocations made by any
+// other goroutines running at the same time, and the numbers for a phase
+// include those of the mutators and singletons inside it.  A Context that is
+// used for many builds only keeps the stats of the most recent phases.
+func (c *Context) PhaseAllocStats() []PhaseAllocStats {
+	// Events end in the reverse order they start, so index the stats by the
+	// position of their begin events.
+	stats := make([]*PhaseAllocStats, len(c.traceEvents))
+	var stack []int
+	for i, event := range c.traceEvents {
+		switch event.phase {
+		case "B":
+			stack = append(stack, i)
+		case "E":
+			start := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			begin := c.traceEvents[start]
+			if begin.mallocs == 0 || event.mallocs == 0 {
+				// Tracking was not enabled for the whole event.
+				continue
+			}
+			stats[start] = &PhaseAllocStats{
+				Category: event.category,
+				Name:     event.name,
+				Bytes:    event.totalAlloc - begin.totalAlloc,
+				Objects:  event.mallocs - begin.mallocs,
+			}
+		}
+	}
+
+	var ret []PhaseAllocStats
+	for _, s := range stats {
+		if s != nil {
+			ret = append(ret, *s)
+		}
+	}
+	return ret
+}
+
+type chromeTraceEvent struct {
+	Name      string `json:"name"`
+	Category  string `json:"cat"`
+	Phase     string `json:"ph"`
+	Timestamp int64  `json:"ts"`
+	Pid       int    `json:"pid"`
+	Tid       int    `json:"tid"`
+}
+
+// WriteChromeTrace writes the phases of the build that have run so far, along with the mutators
+// and singletons inside them, to w in the Trace Event Format that can be loaded into
+// chrome://tracing or the Perfetto trace viewer.  Timestamps are in microseconds relative to the
+// start of the first recorded phase.  A Context that is used for many builds only keeps the events
+// of the most recent phases.
+func (c *Context) WriteChromeTrace(w io.Writer) error {
+	events := make([]chromeTraceEvent, len(c.traceEvents))
+	pid := os.Getpid()
+	for i, event := range c.traceEvents {
+		events[i] = chromeTraceEvent{
+			Name:      event.name,
+			Category:  event.category,
+			Phase:     event.phase,
+			Timestamp: event.time.Sub(c.traceEvents[0].time).Nanoseconds() / 1000,
+			Pid:       pid,
+			Tid:       1,
+		}
+	}
+
+	return json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,242 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestWriteChromeTrace(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("first", func(BottomUpMutatorContext) {})
+	ctx.RegisterBottomUpMutator("second", func(BottomUpMutatorContext) {})
+	ctx.RegisterSingletonType("build_dir", newBuildDirSingleton)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteChromeTrace(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var events []struct {
+		Name  string `json:"name"`
+		Phase string `json:"ph"`
+		Ts    int64  `json:"ts"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &events); err != nil {
+		t.Fatalf("failed to parse trace: %s\n%s", err, buf.String())
+	}
+
+	var got []string
+	var stack []string
+	var lastTs int64
+	for _, event := range events {
+		got = append(got, event.Phase+" "+event.Name)
+		if event.Ts < lastTs {
+			t.Errorf("timestamp of %s %s went backwards", event.Phase, event.Name)
+		}
+		lastTs = event.Ts
+		switch event.Phase {
+		case "B":
+			stack = append(stack, event.Name)
+		case "E":
+			if len(stack) == 0 || stack[len(stack)-1] != event.Name {
+				t.Errorf("unbalanced end event %s, stack %q", event.Name, stack)
+			} else {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	expected := []string{
+		"B PrepareBuildActions",
+		"B ResolveDependencies",
+		"B first",
+		"E first",
+		"B second",
+		"E second",
+		"E ResolveDependencies",
+		"B generateModuleBuildActions",
+		"E generateModuleBuildActions",
+		"B build_dir",
+		"E build_dir",
+		"E PrepareBuildActions",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect events:\nwant: %q\n got: %q", expected, got)
+	}
+}
+
+func TestTraceEventsLimit(t *testing.T) {
+	defer func(limit int) { maxTraceEvents = limit }(maxTraceEvents)
+	maxTraceEvents = 4
+
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("first", func(BottomUpMutatorContext) {})
+	ctx.RegisterBottomUpMutator("second", func(BottomUpMutatorContext) {})
+	ctx.RegisterSingletonType("build_dir", newBuildDirSingleton)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if len(ctx.traceEvents) != 6 {
+		t.Errorf("expected the events of a phase to be kept past the limit, got %d", len(ctx.traceEvents))
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	var got []string
+	for _, event := range ctx.traceEvents {
+		got = append(got, event.phase+" "+event.name)
+	}
+	expected := []string{
+		"B PrepareBuildActions",
+		"B generateModuleBuildActions",
+		"E generateModuleBuildActions",
+		"B build_dir",
+		"E build_dir",
+		"E PrepareBuildActions",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect events:\nwant: %q\n got: %q", expected, got)
+	}
+}
+
+var phaseAllocSink []byte
+
+func TestPhaseAllocStats(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("alloc", func(BottomUpMutatorContext) {
+		phaseAllocSink = make([]byte, 1<<20)
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints")
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	ctx.SetPhaseAllocTracking(true)
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	ctx.SetPhaseAllocTracking(false)
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	stats := ctx.PhaseAllocStats()
+
+	var got []string
+	for _, s := range stats {
+		got = append(got, s.Category+" "+s.Name)
+	}
+	expected := []string{"phase ResolveDependencies", "mutator alloc"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("incorrect phases:\nwant: %q\n got: %q", expected, got)
+	}
+
+	for _, s := range stats {
+		if s.Bytes < 1<<20 || s.Objects < 1 {
+			t.Errorf("expected %s %s to allocate at least 1MB, got %d bytes in %d objects",
+				s.Category, s.Name, s.Bytes, s.Objects)
+		}
+	}
+}
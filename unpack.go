@@ -17,6 +17,7 @@ package blueprint
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -27,15 +28,36 @@ import (
 type packedProperty struct {
 	property *parser.Property
 	unpacked bool
+
+	// set to the canonical property name if the property was unpacked
+	// through a deprecated alias
+	aliasFor string
 }
 
+// propertyUnpackHook is the type of the function set by
+// Context.SetPropertyUnpackHook.
+type propertyUnpackHook func(field reflect.StructField, value parser.Expression) (interface{}, bool, error)
+
+// unpackProperties unpacks the property definitions into the property structs.
+// It returns a map of the set properties by name, a list of warnings for
+// properties that were set through a deprecated `blueprint:"alias:old_name"`
+// struct tag, and a list of errors.
 func unpackProperties(propertyDefs []*parser.Property,
-	propertiesStructs ...interface{}) (map[string]*parser.Property, []error) {
+	propertiesStructs ...interface{}) (map[string]*parser.Property, []error, []error) {
+
+	return unpackPropertiesWithHook(propertyDefs, nil, propertiesStructs...)
+}
+
+// unpackPropertiesWithHook is like unpackProperties, but first offers the
+// value of every property that is set to a non-struct field to hook, if it is
+// not nil.
+func unpackPropertiesWithHook(propertyDefs []*parser.Property, hook propertyUnpackHook,
+	propertiesStructs ...interface{}) (map[string]*parser.Property, []error, []error) {
 
 	propertyMap := make(map[string]*packedProperty)
 	errs := buildPropertyMap("", propertyDefs, propertyMap)
 	if len(errs) > 0 {
-		return nil, errs
+		return nil, nil, errs
 	}
 
 	for _, properties := range propertiesStructs {
@@ -49,19 +71,28 @@ func unpackProperties(propertyDefs []*parser.Property,
 			panic("properties must be a pointer to a struct")
 		}
 
-		newErrs := unpackStructValue("", propertiesValue, propertyMap, "", "")
+		newErrs := unpackStructValue("", propertiesValue, propertyMap, "", "", hook)
 		errs = append(errs, newErrs...)
 
 		if len(errs) >= maxErrors {
-			return nil, errs
+			return nil, nil, errs
 		}
 	}
 
 	// Report any properties that didn't have corresponding struct fields as
-	// errors.
+	// errors, and any properties that were set through an alias as warnings.
 	result := make(map[string]*parser.Property)
+	var warnings []error
 	for name, packedProperty := range propertyMap {
 		result[name] = packedProperty.property
+		if packedProperty.aliasFor != "" {
+			result[packedProperty.aliasFor] = packedProperty.property
+			warnings = append(warnings, &BlueprintError{
+				Err: fmt.Errorf("property %q is deprecated, use %q instead",
+					name, packedProperty.aliasFor),
+				Pos: packedProperty.property.ColonPos,
+			})
+		}
 		if !packedProperty.unpacked {
 			err := &BlueprintError{
 				Err: fmt.Errorf("unrecognized property %q", name),
@@ -72,10 +103,14 @@ func unpackProperties(propertyDefs []*parser.Property,
 	}
 
 	if len(errs) > 0 {
-		return nil, errs
+		return nil, nil, errs
 	}
 
-	return result, nil
+	sort.Slice(warnings, func(i, j int) bool {
+		return errorLess(warnings[i], warnings[j])
+	})
+
+	return result, warnings, nil
 }
 
 func buildPropertyMap(namePrefix string, propertyDefs []*parser.Property,
@@ -119,7 +154,8 @@ func buildPropertyMap(namePrefix string, propertyDefs []*parser.Property,
 }
 
 func unpackStructValue(namePrefix string, structValue reflect.Value,
-	propertyMap map[string]*packedProperty, filterKey, filterValue string) []error {
+	propertyMap map[string]*packedProperty, filterKey, filterValue string,
+	hook propertyUnpackHook) []error {
 
 	structType := structValue.Type()
 
@@ -151,6 +187,32 @@ func unpackStructValue(namePrefix string, structValue reflect.Value,
 		// Get the property value if it was specified.
 		packedProperty, propertyIsSet := propertyMap[propertyName]
 
+		// Fall back to the deprecated alias of the property if it has one.
+		if alias := proptools.PropertyAlias(field); alias != "" {
+			aliasName := namePrefix + alias
+			if aliasProperty, aliasIsSet := propertyMap[aliasName]; aliasIsSet {
+				aliasProperty.unpacked = true
+				if propertyIsSet {
+					packedProperty.unpacked = true
+					errs = append(errs, &BlueprintError{
+						Err: fmt.Errorf("property %q is also set by its deprecated alias %q",
+							propertyName, aliasName),
+						Pos: packedProperty.property.ColonPos,
+					})
+					errs = append(errs, &BlueprintError{
+						Err: fmt.Errorf("<-- alias set here"),
+						Pos: aliasProperty.property.ColonPos,
+					})
+					if len(errs) >= maxErrors {
+						return errs
+					}
+					continue
+				}
+				aliasProperty.aliasFor = propertyName
+				packedProperty, propertyIsSet = aliasProperty, true
+			}
+		}
+
 		origFieldValue := fieldValue
 
 		// To make testing easier we validate the struct field's type regardless
@@ -204,7 +266,8 @@ func unpackStructValue(namePrefix string, structValue reflect.Value,
 		}
 
 		if field.Anonymous && fieldValue.Kind() == reflect.Struct {
-			newErrs := unpackStructValue(namePrefix, fieldValue, propertyMap, filterKey, filterValue)
+			newErrs := unpackStructValue(namePrefix, fieldValue, propertyMap, filterKey, filterValue,
+				hook)
 			errs = append(errs, newErrs...)
 			continue
 		}
@@ -242,6 +305,21 @@ func unpackStructValue(namePrefix string, structValue reflect.Value,
 
 		var newErrs []error
 
+		if hook != nil && fieldValue.Kind() != reflect.Struct && fieldValue.CanSet() {
+			handled, err := unpackWithHook(hook, field, fieldValue, propertyName,
+				packedProperty.property)
+			if err != nil {
+				errs = append(errs, err)
+				if len(errs) >= maxErrors {
+					return errs
+				}
+				continue
+			}
+			if handled {
+				continue
+			}
+		}
+
 		if fieldValue.Kind() == reflect.Struct {
 			localFilterKey, localFilterValue := filterKey, filterValue
 			if k, v, err := HasFilter(field.Tag); err != nil {
@@ -261,7 +339,7 @@ func unpackStructValue(namePrefix string, structValue reflect.Value,
 				}
 			}
 			newErrs = unpackStruct(propertyName+".", fieldValue,
-				packedProperty.property, propertyMap, localFilterKey, localFilterValue)
+				packedProperty.property, propertyMap, localFilterKey, localFilterValue, hook)
 
 			errs = append(errs, newErrs...)
 			if len(errs) >= maxErrors {
@@ -287,6 +365,36 @@ func unpackStructValue(namePrefix string, structValue reflect.Value,
 	return errs
 }
 
+// unpackWithHook offers the value of a property to hook, and sets the value it
+// returns into fieldValue.  It returns false if the hook declined to unpack the
+// property.
+func unpackWithHook(hook propertyUnpackHook, field reflect.StructField, fieldValue reflect.Value,
+	propertyName string, property *parser.Property) (bool, error) {
+
+	result, ok, err := hook(field, property.Value)
+	if err != nil {
+		return false, &BlueprintError{
+			Err: fmt.Errorf("can't unpack property %q: %s", propertyName, err),
+			Pos: property.Value.Pos(),
+		}
+	}
+	if !ok {
+		return false, nil
+	}
+
+	value := reflect.ValueOf(result)
+	if !value.IsValid() || !value.Type().AssignableTo(fieldValue.Type()) {
+		return false, &BlueprintError{
+			Err: fmt.Errorf("property unpack hook returned %T for property %q of type %s",
+				result, propertyName, fieldValue.Type()),
+			Pos: property.Value.Pos(),
+		}
+	}
+
+	fieldValue.Set(value)
+	return true, nil
+}
+
 func propertyToValue(typ reflect.Type, property *parser.Property) (reflect.Value, error) {
 	var value reflect.Value
 
@@ -355,7 +463,7 @@ func propertyToValue(typ reflect.Type, property *parser.Property) (reflect.Value
 
 func unpackStruct(namePrefix string, structValue reflect.Value,
 	property *parser.Property, propertyMap map[string]*packedProperty,
-	filterKey, filterValue string) []error {
+	filterKey, filterValue string, hook propertyUnpackHook) []error {
 
 	m, ok := property.Value.Eval().(*parser.Map)
 	if !ok {
@@ -367,10 +475,18 @@ func unpackStruct(namePrefix string, structValue reflect.Value,
 
 	errs := buildPropertyMap(namePrefix, m.Properties, propertyMap)
 	if len(errs) > 0 {
+		// The map is not unpacked, don't also report its properties as unrecognized.
+		// buildPropertyMap stops adding properties after maxErrors errors, so later
+		// properties may be missing from propertyMap.
+		for _, propertyDef := range m.Properties {
+			if packed, ok := propertyMap[namePrefix+propertyDef.Name]; ok {
+				packed.unpacked = true
+			}
+		}
 		return errs
 	}
 
-	return unpackStructValue(namePrefix, structValue, propertyMap, filterKey, filterValue)
+	return unpackStructValue(namePrefix, structValue, propertyMap, filterKey, filterValue, hook)
 }
 
 func HasFilter(field reflect.StructTag) (k, v string, err error) {
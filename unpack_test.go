@@ -26,10 +26,11 @@ import (
 )
 
 var validUnpackTestCases = []struct {
-	input  string
-	output []interface{}
-	empty  []interface{}
-	errs   []error
+	input    string
+	output   []interface{}
+	empty    []interface{}
+	errs     []error
+	warnings []error
 }{
 	{
 		input: `
@@ -543,6 +544,96 @@ var validUnpackTestCases = []struct {
 			},
 		},
 	},
+
+	// Aliased properties
+	{
+		input: `
+			m {
+				old_name: "abc",
+			}
+		`,
+		output: []interface{}{
+			struct {
+				New_name *string `blueprint:"alias:old_name"`
+			}{
+				New_name: proptools.StringPtr("abc"),
+			},
+		},
+		warnings: []error{
+			&BlueprintError{
+				Err: fmt.Errorf(`property "old_name" is deprecated, use "new_name" instead`),
+				Pos: mkpos(20, 3, 13),
+			},
+		},
+	},
+
+	{
+		input: `
+			m {
+				new_name: "abc",
+			}
+		`,
+		output: []interface{}{
+			struct {
+				New_name *string `blueprint:"alias:old_name"`
+			}{
+				New_name: proptools.StringPtr("abc"),
+			},
+		},
+	},
+
+	{
+		input: `
+			m {
+				nested: {
+					old_name: "abc",
+				},
+			}
+		`,
+		output: []interface{}{
+			struct {
+				Nested struct {
+					New_name *string `blueprint:"alias:old_name"`
+				}
+			}{
+				Nested: struct {
+					New_name *string `blueprint:"alias:old_name"`
+				}{
+					New_name: proptools.StringPtr("abc"),
+				},
+			},
+		},
+		warnings: []error{
+			&BlueprintError{
+				Err: fmt.Errorf(`property "nested.old_name" is deprecated, use "nested.new_name" instead`),
+				Pos: mkpos(35, 4, 14),
+			},
+		},
+	},
+
+	{
+		input: `
+			m {
+				new_name: "abc",
+				old_name: "def",
+			}
+		`,
+		output: []interface{}{
+			struct {
+				New_name *string `blueprint:"alias:old_name"`
+			}{},
+		},
+		errs: []error{
+			&BlueprintError{
+				Err: fmt.Errorf(`property "new_name" is also set by its deprecated alias "old_name"`),
+				Pos: mkpos(20, 3, 13),
+			},
+			&BlueprintError{
+				Err: fmt.Errorf("<-- alias set here"),
+				Pos: mkpos(41, 4, 13),
+			},
+		},
+	},
 }
 
 type EmbeddedStruct struct{ Name string }
@@ -575,7 +666,8 @@ func TestUnpackProperties(t *testing.T) {
 					output = append(output, proptools.CloneEmptyProperties(reflect.ValueOf(p)).Interface())
 				}
 			}
-			_, errs = unpackProperties(module.Properties, output...)
+			var warnings []error
+			_, warnings, errs = unpackProperties(module.Properties, output...)
 			if len(errs) != 0 && len(testCase.errs) == 0 {
 				t.Errorf("test case: %s", testCase.input)
 				t.Errorf("unexpected unpack errors:")
@@ -590,6 +682,13 @@ func TestUnpackProperties(t *testing.T) {
 				t.Errorf("       got: %+v", errs)
 			}
 
+			if !reflect.DeepEqual(warnings, testCase.warnings) {
+				t.Errorf("test case: %s", testCase.input)
+				t.Errorf("incorrect warnings:")
+				t.Errorf("  expected: %+v", testCase.warnings)
+				t.Errorf("       got: %+v", warnings)
+			}
+
 			if len(output) != len(testCase.output) {
 				t.Fatalf("incorrect number of property structs, expected %d got %d",
 					len(testCase.output), len(output))
@@ -615,3 +714,57 @@ func mkpos(offset, line, column int) scanner.Position {
 		Column: column,
 	}
 }
+
+func TestPrintPropertiesRoundTrip(t *testing.T) {
+	for _, testCase := range validUnpackTestCases {
+		if len(testCase.errs) > 0 || len(testCase.empty) > 0 {
+			continue
+		}
+
+		var props []string
+		for _, p := range testCase.output {
+			value := reflect.New(reflect.TypeOf(p))
+			value.Elem().Set(reflect.ValueOf(p))
+			s, err := proptools.PrintProperties(value.Interface())
+			if err != nil {
+				t.Errorf("test case: %s", testCase.input)
+				t.Errorf("unexpected print error: %s", err)
+				continue
+			}
+			props = append(props, s)
+		}
+
+		for i, s := range props {
+			input := "m {\n" + s + "}\n"
+			file, errs := parser.ParseAndEval("", bytes.NewBufferString(input), parser.NewScope(nil))
+			if len(errs) != 0 {
+				t.Errorf("test case: %s", testCase.input)
+				t.Errorf("unexpected parse errors for printed properties:\n%s", input)
+				for _, err := range errs {
+					t.Errorf("  %s", err)
+				}
+				continue
+			}
+
+			module := file.Defs[0].(*parser.Module)
+			output := proptools.CloneEmptyProperties(reflect.ValueOf(testCase.output[i])).Interface()
+			_, _, errs = unpackProperties(module.Properties, output)
+			if len(errs) != 0 {
+				t.Errorf("test case: %s", testCase.input)
+				t.Errorf("unexpected unpack errors for printed properties:\n%s", input)
+				for _, err := range errs {
+					t.Errorf("  %s", err)
+				}
+				continue
+			}
+
+			got := reflect.ValueOf(output).Elem().Interface()
+			if !reflect.DeepEqual(got, testCase.output[i]) {
+				t.Errorf("test case: %s", testCase.input)
+				t.Errorf("incorrect output for printed properties:\n%s", input)
+				t.Errorf("  expected: %+v", testCase.output[i])
+				t.Errorf("       got: %+v", got)
+			}
+		}
+	}
+}
@@ -162,6 +162,31 @@ func TestVisit(t *testing.T) {
 	assertString(t, eModule.properties.VisitDirectDepsIf, "FF")
 }
 
+func TestTransitiveDeps(t *testing.T) {
+	ctx := setupVisitTest(t)
+
+	names := func(modules []Module) string {
+		s := ""
+		for _, m := range modules {
+			s += ctx.ModuleName(m)
+		}
+		return s
+	}
+
+	topModule := ctx.modulesFromName("A", nil)[0].logicModule
+	assertString(t, names(ctx.TransitiveDeps(topModule)), "BCDEF")
+	assertString(t, names(ctx.TransitiveDepsIf(topModule, func(tag DependencyTag) bool {
+		return tag != visitTagDep
+	})), "")
+
+	dModule := ctx.modulesFromName("D", nil)[0].logicModule
+	assertString(t, names(ctx.TransitiveDeps(dModule)), "EF")
+
+	if deps := ctx.TransitiveDeps(&visitModule{}); deps != nil {
+		t.Errorf("expected no deps for an unknown module, got %q", names(deps))
+	}
+}
+
 func assertString(t *testing.T, got, expected string) {
 	if got != expected {
 		t.Errorf("expected %q got %q", expected, got)
@@ -0,0 +1,164 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+)
+
+// A WholeGraphMutator is called once with a WholeGraphMutatorContext that gives it access to every
+// module and variant in the graph.  See Context.RegisterWholeGraphMutator.
+type WholeGraphMutator func(mctx WholeGraphMutatorContext)
+
+type WholeGraphMutatorContext interface {
+	Config() interface{}
+
+	Name() string
+
+	ModuleName(module Module) string
+	ModuleDir(module Module) string
+	ModuleSubDir(module Module) string
+	ModuleType(module Module) string
+	BlueprintFile(module Module) string
+
+	ModuleErrorf(module Module, format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Failed() bool
+
+	VisitAllModules(visit func(Module))
+	VisitAllModulesIf(pred func(Module) bool, visit func(Module))
+	VisitDirectDeps(module Module, visit func(Module))
+	VisitDepsDepthFirst(module Module, visit func(Module))
+	VisitAllModuleVariants(module Module, visit func(Module))
+
+	// CreateModule creates a new module on behalf of an existing module by calling the factory
+	// method and applying the specified property structs to it as if the properties were set in
+	// a Blueprints file.  The new module takes its Blueprints file and position from the
+	// existing module, which is returned by its CreatedBy method.  The new module is added to
+	// the graph after the whole graph mutator returns.  No mutators are run on it, so it can't
+	// have any dependencies.
+	CreateModule(Module, ModuleFactory, ...interface{})
+
+	// CreateModuleWithType is like CreateModule, but also takes the name the factory was
+	// registered with, which is reported as the type of the new module.
+	CreateModuleWithType(Module, ModuleFactory, string, ...interface{})
+}
+
+var _ WholeGraphMutatorContext = (*wholeGraphMutatorContext)(nil)
+
+type wholeGraphMutatorContext struct {
+	name    string
+	context *Context
+	config  interface{}
+
+	errs       []error
+	newModules []*moduleInfo
+}
+
+func (w *wholeGraphMutatorContext) Config() interface{} {
+	return w.config
+}
+
+func (w *wholeGraphMutatorContext) Name() string {
+	return w.name
+}
+
+func (w *wholeGraphMutatorContext) ModuleName(logicModule Module) string {
+	return w.context.ModuleName(logicModule)
+}
+
+func (w *wholeGraphMutatorContext) ModuleDir(logicModule Module) string {
+	return w.context.ModuleDir(logicModule)
+}
+
+func (w *wholeGraphMutatorContext) ModuleSubDir(logicModule Module) string {
+	return w.context.ModuleSubDir(logicModule)
+}
+
+func (w *wholeGraphMutatorContext) ModuleType(logicModule Module) string {
+	return w.context.ModuleType(logicModule)
+}
+
+func (w *wholeGraphMutatorContext) BlueprintFile(logicModule Module) string {
+	return w.context.BlueprintFile(logicModule)
+}
+
+func (w *wholeGraphMutatorContext) error(err error) {
+	if err != nil {
+		w.errs = append(w.errs, err)
+	}
+}
+
+func (w *wholeGraphMutatorContext) ModuleErrorf(logicModule Module, format string,
+	args ...interface{}) {
+
+	w.error(w.context.ModuleErrorf(logicModule, format, args...))
+}
+
+func (w *wholeGraphMutatorContext) Errorf(format string, args ...interface{}) {
+	w.error(fmt.Errorf(format, args...))
+}
+
+func (w *wholeGraphMutatorContext) Failed() bool {
+	return len(w.errs) > 0
+}
+
+func (w *wholeGraphMutatorContext) VisitAllModules(visit func(Module)) {
+	w.context.VisitAllModules(visit)
+}
+
+func (w *wholeGraphMutatorContext) VisitAllModulesIf(pred func(Module) bool,
+	visit func(Module)) {
+
+	w.context.VisitAllModulesIf(pred, visit)
+}
+
+func (w *wholeGraphMutatorContext) VisitDirectDeps(module Module, visit func(Module)) {
+	w.context.VisitDirectDeps(module, visit)
+}
+
+func (w *wholeGraphMutatorContext) VisitDepsDepthFirst(module Module, visit func(Module)) {
+	w.context.VisitDepsDepthFirst(module, visit)
+}
+
+func (w *wholeGraphMutatorContext) VisitAllModuleVariants(module Module, visit func(Module)) {
+	w.context.VisitAllModuleVariants(module, visit)
+}
+
+func (w *wholeGraphMutatorContext) CreateModule(from Module, factory ModuleFactory,
+	props ...interface{}) {
+
+	w.CreateModuleWithType(from, factory, "", props...)
+}
+
+func (w *wholeGraphMutatorContext) CreateModuleWithType(from Module, factory ModuleFactory,
+	typeName string, props ...interface{}) {
+
+	creator := w.context.moduleInfo[from]
+	if creator == nil {
+		panic(fmt.Errorf("CreateModule called on behalf of unknown module %q", from.Name()))
+	}
+
+	module := w.context.newModule(factory)
+
+	module.typeName = typeName
+	module.relBlueprintsFile = creator.relBlueprintsFile
+	module.pos = creator.pos
+	module.createdBy = creator
+
+	w.context.appendCreatedModuleProperties(module, creator, props)
+
+	w.newModules = append(w.newModules, module)
+}